@@ -2,16 +2,15 @@ package cmd
 
 import (
 	"bytes"
-	_ "embed"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/fs"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"sdk/pkg/compressor"
+	"sdk/pkg/handlers"
 	"sdk/pkg/hasher"
 	"strings"
 
@@ -19,12 +18,13 @@ import (
 	"github.com/spf13/cobra"
 )
 
-//go:embed scripts/extract_model.py
-var extractPy []byte
-
+// FileData is one entry of index.json. SkipWorktree marks paths left out
+// of the working directory by sparse checkout; they stay in the index so
+// commits keep them unchanged.
 type FileData struct {
-	Hash string `json:"hash"`
-	Path string `json:"path"`
+	Hash         string `json:"hash"`
+	Path         string `json:"path"`
+	SkipWorktree bool   `json:"skip_worktree,omitempty"`
 }
 
 type ModelMetadata struct {
@@ -32,10 +32,13 @@ type ModelMetadata struct {
 	Index  map[string]int `json:"index"`
 }
 
+// ModelManifest fields are kept in alphabetical order so a manifest hashes
+// the same whether it is marshalled from this struct or from a decoded
+// model_index.json entry.
 type ModelManifest struct {
-	Metadata     string   `json:"metadata"`
 	Architecture string   `json:"architecture"`
 	Chunks       []string `json:"chunks"`
+	Metadata     string   `json:"metadata"`
 }
 
 type NestedIndex map[string]interface{}
@@ -51,6 +54,17 @@ var addCmd = &cobra.Command{
 	},
 }
 
+// versionedDotfiles are the dotfiles at the top of the worktree that are
+// staged like any other file, since they configure merges and have to travel
+// with the branch.
+var versionedDotfiles = map[string]bool{attributesFile: true, mergeRulesFile: true}
+
+// skipDotfile reports whether the walks of add and status leave the file at
+// path out.
+func skipDotfile(path string, d fs.DirEntry) bool {
+	return d.Name()[0] == '.' && !versionedDotfiles[filepath.ToSlash(filepath.Clean(path))]
+}
+
 func fileExists(filename string) bool {
 	_, err := os.Stat(filename)
 	return err == nil || !os.IsNotExist(err)
@@ -65,11 +79,6 @@ func addFiles(rootPath string) {
 		fmt.Println(err)
 		return
 	}
-
-	scriptPath := filepath.Join(tmpDir, "extract_model.py")
-	if err := os.WriteFile(scriptPath, extractPy, 0o755); err != nil {
-		return
-	}
 	// defer os.RemoveAll(tmpDir)
 
 	walkErr := filepath.WalkDir(rootPath, func(path string, d fs.DirEntry, err error) error {
@@ -83,20 +92,14 @@ func addFiles(rootPath string) {
 			return filepath.SkipDir
 		}
 
-		if !d.IsDir() && d.Name()[0] == '.' {
+		if !d.IsDir() && skipDotfile(path, d) {
 			return nil
 		}
 
 		if !d.IsDir() && strings.HasPrefix(path, "models/") {
 
-			absPath, _ := filepath.Abs(path)
-			pythonPath := "/home/joyvin/miniforge3/envs/ml/bin/python"
-			cmd := exec.Command(pythonPath, scriptPath, absPath)
-			cmd.Dir = tmpDir
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-
-			if err := cmd.Run(); err != nil {
+			manifest, err := extractModel(path, tmpDir, rootPath)
+			if err != nil {
 				fmt.Println("err")
 
 				return err
@@ -104,13 +107,12 @@ func addFiles(rootPath string) {
 
 			fmt.Println("Saved in:", tmpDir)
 
-			updateIndex(index, path, "")
-			manifest := updateModelIndex(tmpDir, rootPath)
+			updateIndex(index, path, hasher.HashFile(path))
 			modelIndex[path] = manifest
 
 		} else if !d.IsDir() {
 			digest := hasher.HashFile(path)
-			blobPath := fmt.Sprintf("%s/.stk/objects/blobs/%s/%s", rootPath, digest[:2], digest[2:])
+			blobPath := commonPath("objects", "blobs", digest[:2], digest[2:])
 			fmt.Println(path)
 			createObject(path, blobPath)
 			updateIndex(index, path, digest)
@@ -123,13 +125,17 @@ func addFiles(rootPath string) {
 		log.Fatal("Error walking directory:", walkErr)
 	}
 
+	if err := keepSkippedEntries(index, modelIndex); err != nil {
+		log.Fatal("Error reading index: ", err)
+	}
+
 	fmt.Println(index)
 	jsonIndex, err := json.MarshalIndent(index, "", "  ")
 	if err != nil {
 		panic(err)
 	}
 
-	err = os.WriteFile(".stk/index.json", jsonIndex, 0644)
+	err = os.WriteFile(stkPath(indexFile), jsonIndex, 0644)
 	if err != nil {
 		panic(err)
 	}
@@ -139,55 +145,115 @@ func addFiles(rootPath string) {
 		panic(err)
 	}
 
-	err = os.WriteFile(".stk/model_index.json", jsonModelIndex, 0644)
+	err = os.WriteFile(stkPath(modelIndexFile), jsonModelIndex, 0644)
 	if err != nil {
 		panic(err)
 	}
 
+	if err := markResolved(rootPath); err != nil {
+		log.Fatal("Error updating merge state: ", err)
+	}
+}
+
+// extractModel splits the model at path with the handler that recognizes
+// its format, working inside tmpDir, and stores the resulting architecture,
+// metadata and weight chunks.
+func extractModel(path string, tmpDir string, rootPath string) (ModelManifest, error) {
+	registry, err := modelHandlers()
+	if err != nil {
+		return ModelManifest{}, err
+	}
+
+	absPath, _ := filepath.Abs(path)
+	handler, result, err := registry.Extract(handlers.ExtractRequest{
+		Path:     absPath,
+		WorkDir:  tmpDir,
+		Progress: printHandlerProgress,
+	})
+	if err != nil {
+		return ModelManifest{}, fmt.Errorf("failed to extract %s: %w", path, err)
+	}
+	fmt.Printf("Extracted %s with the %s handler (%d tensors)\n", path, handler.Name(), len(result.Tensors))
+
+	return updateModelIndex(result, rootPath), nil
 }
 
 func createObject(path string, blobPath string) {
 	compressor.CompressFile(path, blobPath)
 }
 
+// keepSkippedEntries carries the entries that sparse checkout left out of
+// the working directory over from the current index, so adding files does
+// not stage their deletion.
+func keepSkippedEntries(index, modelIndex NestedIndex) error {
+	entries, err := readIndexEntries()
+	if err != nil {
+		return err
+	}
+	_, oldModelIndex, err := readIndexFiles()
+	if err != nil {
+		return err
+	}
+
+	for path, entry := range entries {
+		if !entry.SkipWorktree || fileExists(path) {
+			continue
+		}
+		setIndexEntry(index, entry)
+		if manifest, ok := oldModelIndex[path]; ok {
+			modelIndex[path] = manifest
+		}
+	}
+	return nil
+}
+
 func updateIndex(index NestedIndex, path string, hash string) {
-	f := FileData{Hash: hash, Path: path}
+	setIndexEntry(index, FileData{Hash: hash, Path: path})
+}
+
+func setIndexEntry(index NestedIndex, f FileData) {
+	path := f.Path
 	parts := strings.Split(path, "/")
 	current := index
 	for i, part := range parts {
 		if i == len(parts)-1 {
 			current[part] = f
 		} else {
-			if _, ok := current[part]; !ok {
-				current[part] = make(NestedIndex)
+			// Directories decoded from index.json are plain maps.
+			next, ok := current[part].(NestedIndex)
+			if m, isMap := current[part].(map[string]interface{}); isMap {
+				if _, isFile := m["hash"]; !isFile {
+					next, ok = NestedIndex(m), true
+				}
 			}
-			current = current[part].(NestedIndex)
+			if !ok {
+				next = make(NestedIndex)
+			}
+			current[part] = next
+			current = next
 		}
 	}
-	fmt.Println("---------Start-----------")
-	fmt.Println(current)
-	fmt.Println("---------End-----------")
 }
 
-func updateModelIndex(tmpDir string, rootPath string) ModelManifest {
+func updateModelIndex(result *handlers.ExtractResult, rootPath string) ModelManifest {
 	var path, digest, blobPath string
 	manifest := ModelManifest{Chunks: []string{}, Architecture: "", Metadata: ""}
 
-	path = filepath.Join(tmpDir, "architecture.json")
+	path = result.Architecture
 	digest = hasher.HashFile(path)
-	blobPath = fmt.Sprintf("%s/.stk/objects/blobs/%s/%s", rootPath, digest[:2], digest[2:])
+	blobPath = commonPath("objects", "blobs", digest[:2], digest[2:])
 	compressor.CompressFile(path, blobPath)
 	manifest.Architecture = digest
 	// os.Remove(path)
 
-	path = filepath.Join(tmpDir, "metadata.json")
+	path = result.Metadata
 	digest = hasher.HashFile(path)
-	blobPath = fmt.Sprintf("%s/.stk/objects/blobs/%s/%s", rootPath, digest[:2], digest[2:])
+	blobPath = commonPath("objects", "blobs", digest[:2], digest[2:])
 	compressor.CompressFile(path, blobPath)
 	manifest.Metadata = digest
 	// os.Remove(path)
 
-	path = filepath.Join(tmpDir, "weights.safetensors")
+	path = result.Weights
 	manifest.Chunks = chunkAndStore(path, rootPath)
 	// os.Remove(path)
 
@@ -195,6 +261,11 @@ func updateModelIndex(tmpDir string, rootPath string) ModelManifest {
 }
 
 func chunkAndStore(tensorsPath string, rootPath string) []string {
+	tensors, _ := os.ReadFile(tensorsPath)
+	return chunkAndStoreData(tensors, rootPath)
+}
+
+func chunkAndStoreData(tensors []byte, rootPath string) []string {
 	var chunks []string
 
 	opts := fastcdc.Options{
@@ -203,13 +274,12 @@ func chunkAndStore(tensorsPath string, rootPath string) []string {
 		MaxSize:     256 * 1024,
 	}
 
-	tensors, _ := os.ReadFile(tensorsPath)
 	chunker, _ := fastcdc.NewChunker(bytes.NewReader(tensors), opts)
 
 	for {
 		chunk, err := chunker.Next()
 		digest := hasher.HashData(chunk.Data)
-		blobPath := fmt.Sprintf("%s/.stk/objects/blobs/%s/%s", rootPath, digest[:2], digest[2:])
+		blobPath := commonPath("objects", "blobs", digest[:2], digest[2:])
 		compressor.CompressData(chunk.Data, blobPath)
 		chunks = append(chunks, digest)
 		if err == io.EOF {
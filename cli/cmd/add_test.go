@@ -0,0 +1,19 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAddModelUsesConfiguredPython(t *testing.T) {
+	r := newTestRepo(t)
+	r.write(".stk/config.json", `{"python": "/nonexistent/python"}`)
+	r.write("models/m.pt", "weights\n")
+	out, err := r.stk("add", ".")
+	if err == nil {
+		t.Fatalf("add succeeded without an interpreter:\n%s", out)
+	}
+	if !strings.Contains(out, `"/nonexistent/python" from config`) {
+		t.Errorf("add did not run the configured interpreter:\n%s", out)
+	}
+}
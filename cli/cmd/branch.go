@@ -4,7 +4,8 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"strings"
+	"sdk/pkg/refs"
+	"text/tabwriter"
 
 	"github.com/spf13/cobra"
 )
@@ -13,13 +14,26 @@ var (
 	deleteFlag bool
 	forceFlag  bool
 	moveFlag   bool
+
+	branchVerbose       int
+	branchSetUpstreamTo string
+	branchUnsetUpstream bool
 )
 
 // branchCmd defines the "branch" command
 var branchCmd = &cobra.Command{
-	Use:   "branch [branch_name]",
+	Use:   "branch [branch_name] [start_point]",
 	Short: "Manage branches",
-	Long:  `Create, list, delete, or rename branches.`,
+	Long: `Create, list, delete, or rename branches.
+
+-v lists each branch with its commit and subject; -vv adds its upstream and
+how many commits it is ahead of and behind it. --set-upstream-to makes a
+remote-tracking branch the upstream of the current branch, or of the named
+one, which push and pull then default to.
+Example:
+  stk branch -vv
+  stk branch --set-upstream-to origin/main
+  stk branch --set-upstream-to origin/dev feature-x`,
 	Run: func(cmd *cobra.Command, args []string) {
 		runBranch(args)
 	},
@@ -27,6 +41,35 @@ var branchCmd = &cobra.Command{
 
 func runBranch(args []string) {
 	switch {
+	case branchSetUpstreamTo != "" || branchUnsetUpstream:
+		if len(args) > 1 {
+			log.Fatal("Usage: stk branch --set-upstream-to <remote>/<branch> [<branch>] | --unset-upstream [<branch>]")
+		}
+		branch := currentBranch()
+		if len(args) == 1 {
+			branch = refs.BranchRef(args[0])
+		}
+		if branch == "" {
+			log.Fatal("HEAD does not point to a branch; name the branch to change.")
+		}
+		if !refStore().Exists(branch) {
+			log.Fatalf("Branch '%s' does not exist.", refs.Short(branch))
+		}
+		if branchUnsetUpstream {
+			if err := unsetUpstream(branch); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		remoteName, merge, err := parseUpstream(branchSetUpstreamTo)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := setUpstream(branch, remoteName, merge); err != nil {
+			log.Fatal("Error writing config: ", err)
+		}
+		fmt.Printf("branch '%s' set up to track '%s/%s'.\n", refs.Short(branch), remoteName, merge)
+
 	case moveFlag:
 		// Rename: mygit branch -m old new
 		if len(args) != 2 {
@@ -34,7 +77,7 @@ func runBranch(args []string) {
 		}
 		renameBranch(args[0], args[1])
 
-	case deleteFlag:
+	case deleteFlag || forceFlag:
 		// Delete: mygit branch -d name
 		if len(args) != 1 {
 			log.Fatal("Usage: mygit branch -d <branch>")
@@ -47,7 +90,11 @@ func runBranch(args []string) {
 
 	case len(args) == 1:
 		// Create a new branch
-		createBranch(args[0])
+		createBranch(args[0], "")
+
+	case len(args) == 2:
+		// Create a new branch at a given revision
+		createBranch(args[0], args[1])
 
 	default:
 		log.Fatal("Invalid usage. Run 'mygit branch --help' for details.")
@@ -55,35 +102,69 @@ func runBranch(args []string) {
 }
 
 func listBranches() {
-	head, _ := os.ReadFile(".stk/HEAD")
-	currentBranch := strings.Split(string(head), "/")[1]
+	currentBranch := currentBranch()
+	if currentBranch == "" {
+		fmt.Printf("* (%s)\n", headName())
+	}
 
-	files, err := os.ReadDir(".stk/branches/")
+	branches, err := refStore().List(refs.HeadsPrefix)
 	if err != nil {
 		log.Fatal("Error reading branches: ", err)
 	}
 
-	for _, f := range files {
-		if f.Name() == currentBranch {
-			fmt.Printf("* %s\n", f.Name())
-		} else {
-			fmt.Println("  " + f.Name())
+	if branchVerbose == 0 {
+		for _, b := range branches {
+			if b.Name == currentBranch {
+				fmt.Printf("* %s\n", refs.Short(b.Name))
+			} else {
+				fmt.Println("  " + refs.Short(b.Name))
+			}
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	for _, b := range branches {
+		marker := " "
+		if b.Name == currentBranch {
+			marker = "*"
+		}
+		subject := ""
+		if commit, err := readCommit(b.Hash); err == nil {
+			subject = firstLine(commit.Message)
+		}
+		if branchVerbose > 1 {
+			if label := trackingLabel(b.Name); label != "" {
+				subject = label + " " + subject
+			}
 		}
+		fmt.Fprintf(w, "%s %s\t%s\t%s\n", marker, refs.Short(b.Name), shortHash(b.Hash), subject)
 	}
+	w.Flush()
 }
 
-func createBranch(name string) {
-	branch, _ := os.ReadFile(".stk/HEAD")
-	currCommitBytes, _ := os.ReadFile(fmt.Sprintf(".stk/%s", branch))
-	currCommit := strings.TrimSpace(string(currCommitBytes))
+func createBranch(name string, startPoint string) {
+	if err := refs.ValidateName(name); err != nil {
+		log.Fatal(err)
+	}
+
+	currCommit := headCommit()
+	if startPoint != "" {
+		var err error
+		if currCommit, err = resolveRevision(startPoint); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if currCommit == "" {
+		log.Fatalf("Cannot create branch '%s': the current branch has no commits yet.", name)
+	}
 
-	path := fmt.Sprintf(".stk/branches/%s", name)
-	if _, err := os.Stat(path); err == nil {
+	store := refStore()
+	if store.Exists(refs.BranchRef(name)) {
 		log.Fatalf("Branch '%s' already exists.", name)
 	}
 
-	err := os.WriteFile(path, []byte(currCommit), 0644)
-	if err != nil {
+	if err := store.Write(refs.BranchRef(name), currCommit); err != nil {
 		log.Fatal("Error creating branch: ", err)
 	}
 
@@ -91,29 +172,52 @@ func createBranch(name string) {
 }
 
 func deleteBranch(name string, force bool) {
-	path := fmt.Sprintf(".stk/refs/heads/%s", name)
-	if _, err := os.Stat(path); os.IsNotExist(err) {
+	store := refStore()
+	ref := refs.BranchRef(name)
+
+	hash, err := store.Read(ref)
+	if err != nil {
 		log.Fatalf("Branch '%s' does not exist.", name)
 	}
+	if ref == currentBranch() {
+		log.Fatalf("Cannot delete branch '%s': it is checked out.", name)
+	}
+	if other, ok := branchCheckedOutElsewhere(ref); ok {
+		log.Fatalf("Cannot delete branch '%s': it is checked out at '%s'.", name, other)
+	}
+	if !force && !isAncestor(hash, headCommit()) {
+		log.Fatalf("Branch '%s' is not fully merged. Use -D to delete it anyway.", name)
+	}
 
-	if err := os.Remove(path); err != nil {
+	if err := store.Delete(ref); err != nil {
 		log.Fatal("Error deleting branch:", err)
 	}
+	if err := moveUpstream(name, ""); err != nil {
+		log.Fatal("Error writing config: ", err)
+	}
 
 	fmt.Println("Deleted branch:", name)
 }
 
 func renameBranch(oldName, newName string) {
-	oldPath := fmt.Sprintf(".stk/refs/heads/%s", oldName)
-	newPath := fmt.Sprintf(".stk/refs/heads/%s", newName)
+	if err := refs.ValidateName(newName); err != nil {
+		log.Fatal(err)
+	}
 
-	if _, err := os.Stat(oldPath); os.IsNotExist(err) {
+	store := refStore()
+	if !store.Exists(refs.BranchRef(oldName)) {
 		log.Fatalf("Branch '%s' does not exist.", oldName)
 	}
+	if other, ok := branchCheckedOutElsewhere(refs.BranchRef(oldName)); ok {
+		log.Fatalf("Cannot rename branch '%s': it is checked out at '%s'.", oldName, other)
+	}
 
-	if err := os.Rename(oldPath, newPath); err != nil {
+	if err := store.Rename(refs.BranchRef(oldName), refs.BranchRef(newName)); err != nil {
 		log.Fatal("Error renaming branch:", err)
 	}
+	if err := moveUpstream(oldName, newName); err != nil {
+		log.Fatal("Error writing config: ", err)
+	}
 
 	fmt.Printf("Renamed branch '%s' to '%s'\n", oldName, newName)
 }
@@ -123,4 +227,7 @@ func init() {
 	branchCmd.Flags().BoolVarP(&deleteFlag, "delete", "d", false, "Delete branch")
 	branchCmd.Flags().BoolVarP(&forceFlag, "force", "D", false, "Force delete branch")
 	branchCmd.Flags().BoolVarP(&moveFlag, "move", "m", false, "Rename branch")
+	branchCmd.Flags().CountVarP(&branchVerbose, "verbose", "v", "Show the commit of each branch; twice to show its upstream too")
+	branchCmd.Flags().StringVarP(&branchSetUpstreamTo, "set-upstream-to", "u", "", "Set the upstream of the branch to <remote>/<branch>")
+	branchCmd.Flags().BoolVar(&branchUnsetUpstream, "unset-upstream", false, "Remove the upstream of the branch")
 }
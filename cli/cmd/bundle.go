@@ -0,0 +1,367 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sdk/pkg/bundle"
+	"sdk/pkg/pack"
+	"sdk/pkg/refs"
+	"sdk/pkg/transfer"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var bundleRemote string
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Move history between repositories as a single file",
+	Long: `A bundle is one file holding branches and tags together with the commits,
+trees, model manifests and chunks they need, for repositories that cannot
+reach each other, such as on an air-gapped cluster. A bundle may leave out
+history the receiving repository already has; those prerequisite commits
+are recorded in the bundle and checked when it is imported.
+Example:
+  stk bundle create update.stkb main ^v1.0
+  stk bundle verify update.stkb
+  stk bundle unbundle update.stkb
+  stk merge origin/main`,
+}
+
+var bundleCreateCmd = &cobra.Command{
+	Use:   "create <file> <rev>...",
+	Short: "Write branches and tags to a bundle",
+	Long: `Writes the named branches and tags to <file>, with every commit reachable
+from them. ^<rev> leaves out the commits reachable from <rev>, and
+<rev1>..<rev2> bundles <rev2> without the commits reachable from <rev1>;
+the parents of the bundled commits that are left out become the bundle's
+prerequisites. HEAD stands for the current branch.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		createBundle(args[0], args[1:])
+	},
+}
+
+var bundleVerifyCmd = &cobra.Command{
+	Use:   "verify <file>",
+	Short: "Check a bundle and whether this repository can import it",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		verifyBundle(args[0])
+	},
+}
+
+var bundleUnbundleCmd = &cobra.Command{
+	Use:   "unbundle <file>",
+	Short: "Import the objects and refs of a bundle",
+	Long: `Imports a bundle as a fetch from a remote would: the objects are stored,
+each branch is recorded as refs/remotes/<remote>/<branch> and tags that do
+not exist yet are created. Local branches and the working directory are
+left alone. The repository must have the bundle's prerequisite commits.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		unbundle(args[0])
+	},
+}
+
+// bundleRevs resolves the revisions given to bundle create into the refs
+// to bundle and the commits whose history is left out.
+func bundleRevs(args []string) ([]refs.Ref, []string, error) {
+	var include []refs.Ref
+	var exclude []string
+	addRef := func(name string) error {
+		if name == "HEAD" || name == "@" {
+			branch, err := refStore().SymbolicHead()
+			if err != nil {
+				return err
+			}
+			if branch == "" {
+				return fmt.Errorf("HEAD is detached; name a branch to bundle")
+			}
+			name = branch
+		}
+		full, hash, err := refStore().Resolve(name)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a branch or tag: %w", name, err)
+		}
+		if !strings.HasPrefix(full, refs.HeadsPrefix) && !strings.HasPrefix(full, refs.TagsPrefix) {
+			return fmt.Errorf("'%s' is not a branch or tag", name)
+		}
+		for _, ref := range include {
+			if ref.Name == full {
+				return nil
+			}
+		}
+		include = append(include, refs.Ref{Name: full, Hash: hash})
+		return nil
+	}
+
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "^"):
+			commit, err := resolveRevision(arg[1:])
+			if err != nil {
+				return nil, nil, err
+			}
+			exclude = append(exclude, commit)
+		case strings.Contains(arg, ".."):
+			from, to, _ := strings.Cut(arg, "..")
+			if from == "" {
+				from = "HEAD"
+			}
+			if to == "" {
+				to = "HEAD"
+			}
+			commit, err := resolveRevision(from)
+			if err != nil {
+				return nil, nil, err
+			}
+			exclude = append(exclude, commit)
+			if err := addRef(to); err != nil {
+				return nil, nil, err
+			}
+		default:
+			if err := addRef(arg); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if len(include) == 0 {
+		return nil, nil, fmt.Errorf("name at least one branch or tag to bundle")
+	}
+	return include, exclude, nil
+}
+
+func createBundle(path string, args []string) {
+	include, exclude, err := bundleRevs(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	excluded := make(map[string]bool)
+	for _, commit := range exclude {
+		for hash := range reachableCommits(commit) {
+			excluded[hash] = true
+		}
+	}
+	// Each ref's new commits go in front of those already listed, which
+	// cannot descend from them, so that objectKeysFrom still stores
+	// parents first.
+	have := make(map[string]bool)
+	for hash := range excluded {
+		have[hash] = true
+	}
+	var commits []string
+	for _, ref := range include {
+		found := missingCommitsFrom(ref.Hash, have)
+		for _, hash := range found {
+			have[hash] = true
+		}
+		commits = append(found, commits...)
+	}
+	if len(commits) == 0 {
+		log.Fatal("Refusing to create an empty bundle; every commit is excluded.")
+	}
+
+	var prerequisites []bundle.Prerequisite
+	var boundary []string
+	listed := make(map[string]bool)
+	for _, hash := range commits {
+		commit, err := readCommit(hash)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, parent := range commit.Parents {
+			if !excluded[parent] || listed[parent] {
+				continue
+			}
+			listed[parent] = true
+			parentCommit, err := readCommit(parent)
+			if err != nil {
+				log.Fatal(err)
+			}
+			prerequisites = append(prerequisites, bundle.Prerequisite{Commit: parent, Subject: firstLine(parentCommit.Message)})
+			boundary = append(boundary, parent)
+		}
+	}
+
+	// The receiver has everything in the trees of the prerequisites.
+	provided, err := treeObjects(localObjects{}, boundary)
+	if err != nil {
+		log.Fatal("Error collecting objects: ", err)
+	}
+	keys, err := objectKeysFrom(localObjects{}, commits, provided, noModelFilter)
+	if err != nil {
+		log.Fatal("Error collecting objects: ", err)
+	}
+
+	size, err := writeBundle(path, bundle.Header{Prerequisites: prerequisites, Refs: include}, keys)
+	if err != nil {
+		log.Fatal("Error writing bundle: ", err)
+	}
+	fmt.Printf("Bundled %d objects for %d commits into %s (%s)\n", len(keys), len(commits), path, transfer.FormatBytes(size))
+	for _, ref := range include {
+		fmt.Printf("  %s %s\n", shortHash(ref.Hash), ref.Name)
+	}
+	if len(prerequisites) > 0 {
+		fmt.Printf("The bundle requires %s the receiving repository must already have.\n", pluralCommits(len(prerequisites)))
+	}
+}
+
+// writeBundle writes the bundle next to path and moves it into place once
+// complete, returning its size.
+func writeBundle(path string, header bundle.Header, keys []string) (int64, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	w := bufio.NewWriter(f)
+	if err := bundle.WriteHeader(w, header); err != nil {
+		f.Close()
+		return 0, err
+	}
+	progress := transfer.NewProgress("Writing objects", len(keys), 0)
+	err = writeLocalPack(w, keys, progress)
+	progress.Finish()
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	return info.Size(), os.Rename(f.Name(), path)
+}
+
+// openBundle opens a bundle and reads its header, leaving the reader at
+// the start of the pack.
+func openBundle(path string) (*os.File, *bufio.Reader, bundle.Header) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r := bufio.NewReader(f)
+	header, err := bundle.ReadHeader(r)
+	if err != nil {
+		f.Close()
+		log.Fatalf("%s: %v", path, err)
+	}
+	return f, r, header
+}
+
+// missingPrerequisites returns the prerequisites of a bundle that are not
+// in the local repository.
+func missingPrerequisites(header bundle.Header) []bundle.Prerequisite {
+	var missing []bundle.Prerequisite
+	for _, p := range header.Prerequisites {
+		if !objectExists(commitsDir, p.Commit) {
+			missing = append(missing, p)
+		}
+	}
+	return missing
+}
+
+func reportMissingPrerequisites(missing []bundle.Prerequisite) {
+	fmt.Fprintln(os.Stderr, "error: the repository lacks these prerequisite commits:")
+	for _, p := range missing {
+		fmt.Fprintf(os.Stderr, "  %s %s\n", p.Commit, p.Subject)
+	}
+	fmt.Fprintln(os.Stderr, "hint: import the bundle that carries them first, or create this one with less history excluded.")
+	os.Exit(1)
+}
+
+func verifyBundle(path string) {
+	f, r, header := openBundle(path)
+	defer f.Close()
+
+	pr, err := pack.NewReader(r)
+	if err != nil {
+		log.Fatalf("%s: %v", path, err)
+	}
+	inPack := make(map[string]bool)
+	progress := transfer.NewProgress("Checking objects", 0, 0)
+	for {
+		key, raw, err := pr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			_, _, err = verifyObject(key, raw)
+		}
+		if err != nil {
+			progress.Finish()
+			log.Fatalf("%s: %v", path, err)
+		}
+		inPack[key] = true
+		progress.Add(int64(len(raw)))
+		progress.Done(1)
+	}
+	progress.Finish()
+
+	fmt.Printf("The bundle contains %d objects and these refs:\n", len(inPack))
+	for _, ref := range header.Refs {
+		fmt.Printf("  %s %s\n", ref.Hash, ref.Name)
+		if !inPack[objectKey(commitsDir, ref.Hash)] && !objectExists(commitsDir, ref.Hash) {
+			log.Fatalf("%s: the commit of %s is missing from the bundle", path, ref.Name)
+		}
+	}
+	if len(header.Prerequisites) == 0 {
+		fmt.Println("The bundle records a complete history.")
+	} else {
+		fmt.Printf("The bundle requires %s:\n", pluralCommits(len(header.Prerequisites)))
+		for _, p := range header.Prerequisites {
+			fmt.Printf("  %s %s\n", p.Commit, p.Subject)
+		}
+	}
+	if missing := missingPrerequisites(header); len(missing) > 0 {
+		reportMissingPrerequisites(missing)
+	}
+	fmt.Printf("%s is okay\n", path)
+}
+
+func unbundle(path string) {
+	f, r, header := openBundle(path)
+	defer f.Close()
+	if missing := missingPrerequisites(header); len(missing) > 0 {
+		reportMissingPrerequisites(missing)
+	}
+
+	progress := transfer.NewProgress("Unbundling objects", 0, 0)
+	received, err := storePack(r, progress)
+	progress.Finish()
+	if err != nil {
+		log.Fatalf("%s: %v", path, err)
+	}
+	for _, ref := range header.Refs {
+		if !objectExists(commitsDir, ref.Hash) {
+			log.Fatalf("%s: the commit of %s is missing from the bundle", path, ref.Name)
+		}
+	}
+
+	if err := recordFetchedRefs(bundleRemote, path, header.Refs); err != nil {
+		log.Fatal(err)
+	}
+	if received > 0 {
+		fmt.Printf("Received %d objects\n", received)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(bundleCmd)
+	bundleCmd.AddCommand(bundleCreateCmd, bundleVerifyCmd, bundleUnbundleCmd)
+	bundleUnbundleCmd.Flags().StringVar(&bundleRemote, "remote", defaultRemoteName, "Remote whose tracking refs record the bundle's branches")
+}
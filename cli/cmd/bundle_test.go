@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnbundleChecksPrerequisites(t *testing.T) {
+	src := newTestRepo(t)
+	src.write("a.txt", "a\n")
+	src.commit("one")
+	first := src.head()
+	src.write("b.txt", "b\n")
+	src.commit("two")
+	second := src.head()
+
+	dir := t.TempDir()
+	full := filepath.Join(dir, "full.stkb")
+	update := filepath.Join(dir, "update.stkb")
+	src.run("bundle", "create", full, "main")
+	src.run("bundle", "create", update, "main", "^"+first)
+
+	dst := newTestRepo(t)
+	for _, args := range [][]string{{"bundle", "verify", update}, {"bundle", "unbundle", update}} {
+		out, err := dst.stk(args...)
+		if err == nil {
+			t.Fatalf("stk %s succeeded without the prerequisite:\n%s", strings.Join(args, " "), out)
+		}
+		if !strings.Contains(out, "lacks these prerequisite commits") || !strings.Contains(out, first) {
+			t.Errorf("stk %s does not name the prerequisite:\n%s", strings.Join(args, " "), out)
+		}
+	}
+	if out, _ := dst.stk("rev-parse", "origin/main"); strings.Contains(out, second) {
+		t.Errorf("a refused unbundle recorded origin/main")
+	}
+
+	dst.run("bundle", "unbundle", full)
+	dst.run("bundle", "verify", update)
+	dst.run("bundle", "unbundle", update)
+	if got := strings.TrimSpace(dst.run("rev-parse", "origin/main")); got != second {
+		t.Errorf("origin/main = %s, want %s", got, second)
+	}
+}
+
+func TestBundleDetectsCorruption(t *testing.T) {
+	src := newTestRepo(t)
+	src.write("a.txt", "a\n")
+	src.commit("one")
+	path := filepath.Join(t.TempDir(), "repo.stkb")
+	src.run("bundle", "create", path, "main")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corrupt := map[string][]byte{
+		// Rename the branch without updating the header checksum.
+		"header": bytes.Replace(data, []byte("refs/heads/main"), []byte("refs/heads/mine"), 1),
+		// Change the last digit of the pack checksum.
+		"pack": append(bytes.Clone(data[:len(data)-2]), data[len(data)-2]^1, '\n'),
+	}
+	for name, content := range corrupt {
+		bad := filepath.Join(t.TempDir(), name+".stkb")
+		if err := os.WriteFile(bad, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		dst := newTestRepo(t)
+		for _, args := range [][]string{{"bundle", "verify", bad}, {"bundle", "unbundle", bad}} {
+			out, err := dst.stk(args...)
+			if err == nil {
+				t.Errorf("%s: stk %s accepted a corrupt bundle:\n%s", name, strings.Join(args, " "), out)
+			} else if !strings.Contains(out, "corrupt") {
+				t.Errorf("%s: stk %s does not report the corruption:\n%s", name, strings.Join(args, " "), out)
+			}
+		}
+		if out, err := dst.stk("rev-parse", "origin/main"); err == nil {
+			t.Errorf("%s: a corrupt bundle recorded origin/main at %s", name, out)
+		}
+	}
+}
@@ -1,176 +1,497 @@
 package cmd
 
 import (
-	_ "embed"
-	"encoding/json"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"sdk/pkg/compressor"
-	"strings"
+	"sdk/pkg/diff"
+	"sdk/pkg/handlers"
+	"sdk/pkg/hasher"
+	"sdk/pkg/refs"
+	"sort"
 
 	"github.com/spf13/cobra"
 )
 
-//go:embed scripts/rebuild_model.py
-var rebuildPy []byte
-
-var newBranch bool
+var (
+	newBranch     bool
+	checkoutForce bool
+	checkoutMerge bool
+)
 
 var checkoutCmd = &cobra.Command{
-	Use:   "checkout [branch-name]",
+	Use:   "checkout [branch-name | revision] [-- <path>...]",
 	Short: "Switch branches or restore working tree files",
 	Long: `Switch to a specified branch or create a new one using the -b flag.
+Any other revision is checked out as a detached HEAD. Only files that differ
+between the current and the target commit are written, rebuilt or deleted;
+checkout stops if that would overwrite local changes, unless --force
+discards them or --merge carries them over.
+With -- and paths, only those files and models are restored from the
+revision (HEAD by default) and HEAD stays where it is.
 Example:
   stk checkout main          # switch to existing branch
-  stk checkout -b feature-x  # create and switch to new branch`,
-	Args: cobra.ExactArgs(1),
+  stk checkout -b feature-x  # create and switch to new branch
+  stk checkout v1.0          # detach HEAD at a tag
+  stk checkout 3fa9c2        # detach HEAD at an abbreviated commit hash
+  stk checkout main~3        # detach HEAD three commits behind main
+  stk checkout v1.0 -- models/encoder.pt  # restore one model from a tag`,
+	Args: cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
+		if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+			if dash > 1 || dash == len(args) {
+				log.Fatal("Usage: stk checkout [<revision>] -- <path>...")
+			}
+			source := "HEAD"
+			if dash == 1 {
+				source = args[0]
+			}
+			runRestore(source, args[dash:])
+			return
+		}
+		if len(args) != 1 {
+			log.Fatal("Usage: stk checkout <branch | revision>, or stk checkout <revision> -- <path>...")
+		}
+		if checkoutForce && checkoutMerge {
+			log.Fatal("--force and --merge cannot be used together")
+		}
 		branch := args[0]
 		runCheckout(branch)
 	},
 }
 
-func runCheckout(branch string) {
-	branchPath := filepath.Join(".stk", "branches", branch)
-	tmpDir, _ := os.MkdirTemp("", "stk-*")
+func runCheckout(target string) {
+	store := refStore()
+	branchRef := refs.BranchRef(target)
 
-	scriptPath := filepath.Join(tmpDir, "rebuild_model.py")
-	if err := os.WriteFile(scriptPath, rebuildPy, 0o755); err != nil {
-		return
+	previousRef, previousCommit, err := store.Head()
+	if err != nil {
+		log.Fatal("Error reading HEAD: ", err)
 	}
 
-	if newBranch {
-		head, _ := os.ReadFile(".stk/HEAD")
-		currCommitBytes, _ := os.ReadFile(fmt.Sprintf(".stk/%s", head))
-		currCommit := strings.TrimSpace(string(currCommitBytes))
+	detached := false
+	var targetCommit string
 
-		path := fmt.Sprintf(".stk/branches/%s", branch)
-		if _, err := os.Stat(path); err == nil {
-			log.Fatalf("Branch '%s' already exists.", branch)
+	if newBranch {
+		if err := refs.ValidateName(target); err != nil {
+			log.Fatal(err)
 		}
+		if store.Exists(branchRef) {
+			log.Fatalf("Branch '%s' already exists.", target)
+		}
+		targetCommit = previousCommit
+	} else if hash, err := store.Read(branchRef); err == nil {
+		if other, ok := branchCheckedOutElsewhere(branchRef); ok {
+			log.Fatalf("Branch '%s' is already checked out at '%s'.", target, other)
+		}
+		targetCommit = hash
+	} else {
+		hash, err := resolveRevision(target)
+		if err != nil {
+			log.Fatalf("'%s' is not a branch or revision: %v. Use -b to create a branch.", target, err)
+		}
+		targetCommit = hash
+		detached = true
+	}
 
-		err := os.WriteFile(path, []byte(currCommit), 0644)
+	fromTree, err := commitTree(previousCommit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	toTree, err := commitTree(targetCommit)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Work out what happens to local changes before anything is touched.
+	var merged map[string][]byte
+	if fromTree != toTree && !checkoutForce {
+		changed, err := localChanges(fromTree, toTree)
 		if err != nil {
-			log.Fatal("Error creating branch: ", err)
+			log.Fatal(err)
+		}
+		if len(changed) > 0 && !checkoutMerge {
+			printOverwritten("checkout", changed)
+			fmt.Println("Please commit your changes, or use --merge to carry them over or --force to discard them.")
+			os.Exit(1)
 		}
+		if merged, err = mergeLocalChanges(changed, fromTree, toTree, target); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-		fmt.Println("Created branch:", branch)
-	} else {
-		// Check if branch exists
-		if _, err := os.Stat(branchPath); os.IsNotExist(err) {
-			log.Fatalf("Branch '%s' does not exist. Use -b to create it.", branch)
+	if newBranch {
+		if previousCommit != "" {
+			if err := store.Write(branchRef, previousCommit); err != nil {
+				log.Fatal("Error creating branch: ", err)
+			}
 		}
+		fmt.Println("Created branch:", target)
+	}
+
+	if previousRef == "" && previousCommit != "" && previousCommit != targetCommit {
+		warnUnreferencedCommits(previousCommit)
 	}
 
-	// Update HEAD to point to the branch
-	err := os.WriteFile(".stk/HEAD", []byte(fmt.Sprintf("branches/%s", branch)), 0644)
+	// Update HEAD to point to the branch, or straight at the commit
+	if detached {
+		err = store.SetDetachedHead(targetCommit)
+	} else {
+		err = store.SetSymbolicHead(branchRef)
+	}
 	if err != nil {
 		log.Fatalf("Error updating HEAD: %v", err)
 	}
 
-	entries, _ := os.ReadDir(".")
-	for _, e := range entries {
-		if e.Name() == ".stk" {
-			continue
+	if fromTree != toTree {
+		if err := checkoutTree(fromTree, toTree); err != nil {
+			log.Fatal("Error updating working directory: ", err)
+		}
+		if err := writeIndexFromTree(toTree); err != nil {
+			log.Fatal("Error updating index: ", err)
 		}
-		os.RemoveAll(e.Name())
 	}
 
-	currCommitBytes, _ := os.ReadFile(branchPath)
-	currCommit := strings.TrimSpace(string(currCommitBytes))
+	paths := make([]string, 0, len(merged))
+	for path := range merged {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := os.WriteFile(path, merged[path], 0644); err != nil {
+			log.Fatal(err)
+		}
+		if diff.HasConflictMarkers(merged[path]) {
+			fmt.Printf("CONFLICT (content): Merge conflict in %s\n", path)
+		} else {
+			fmt.Printf("M\t%s\n", path)
+		}
+	}
 
-	commitDataBytes, _ := compressor.GetDecompressFile(filepath.Join(".stk", "commits", currCommit[:2], currCommit[2:]))
-	var commitData Commit
-	json.Unmarshal(commitDataBytes, &commitData)
+	if detached {
+		commitData, _ := readCommit(targetCommit)
+		fmt.Printf("Note: switching to '%s'.\n", target)
+		fmt.Println("You are in 'detached HEAD' state. Commits made here belong to no branch;")
+		fmt.Println("create one with 'stk checkout -b <name>' to keep them.")
+		fmt.Printf("HEAD is now at %s %s\n", shortHash(targetCommit), firstLine(commitData.Message))
+		return
+	}
+	fmt.Printf("Switched to branch '%s'\n", target)
+}
+
+// localChanges lists the paths that differ between fromTree and toTree and
+// whose working copy holds changes that checking out toTree would lose:
+// edits to tracked files, staged models and untracked files in the way.
+func localChanges(fromTree, toTree string) ([]string, error) {
+	from, err := flattenTree(fromTree)
+	if err != nil {
+		return nil, err
+	}
+	to, err := flattenTree(toTree)
+	if err != nil {
+		return nil, err
+	}
+	index, err := readIndexEntries()
+	if err != nil {
+		return nil, err
+	}
+	staged, err := stagedModels()
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]bool)
+	for path := range from {
+		paths[path] = true
+	}
+	for path := range to {
+		paths[path] = true
+	}
+
+	var changed []string
+	for path := range paths {
+		base, tracked := from[path]
+		if next, ok := to[path]; ok && tracked && base == next {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
 
-	restoreTree(commitData.Tree, ".", tmpDir)
+		modified := true
+		switch {
+		case info.IsDir():
+		case !tracked:
+			// An untracked file is only safe to replace with identical content.
+			if next := to[path]; next.Type == "blob" {
+				modified = hasher.HashFile(path) != next.Hash
+			}
+		case base.Type == "blob":
+			modified = hasher.HashFile(path) != base.Hash
+		case base.Type == "model":
+			fileHash := index[path].Hash
+			modified = (staged[path] != "" && staged[path] != base.Hash) ||
+				(fileHash != "" && hasher.HashFile(path) != fileHash)
+		}
+		if modified {
+			changed = append(changed, path)
+		}
+	}
 
-	fmt.Printf("Switched to branch '%s'\n", branch)
+	sort.Strings(changed)
+	return changed, nil
 }
 
-func restoreTree(treeHash, prefix string, tmpDir string) {
-	treeBytes, _ := compressor.GetDecompressFile(fmt.Sprintf(".stk/objects/trees/%s/%s", treeHash[:2], treeHash[2:]))
-	var entries []Tree
-	json.Unmarshal(treeBytes, &entries)
-	var models []Tree
+// printOverwritten lists the local changes that operation would overwrite.
+func printOverwritten(operation string, changed []string) {
+	fmt.Printf("error: your local changes to the following files would be overwritten by %s:\n", operation)
+	for _, path := range changed {
+		fmt.Println("\t" + path)
+	}
+}
 
-	for _, entry := range entries {
-		switch entry.Type {
-		case "blob":
-			var blobPath string
+// mergeLocalChanges three-way merges the working copy of each changed path
+// with its versions in fromTree (the base) and toTree. Only text files
+// present in both trees can be merged.
+func mergeLocalChanges(changed []string, fromTree, toTree, target string) (map[string][]byte, error) {
+	if len(changed) == 0 {
+		return nil, nil
+	}
+
+	from, err := flattenTree(fromTree)
+	if err != nil {
+		return nil, err
+	}
+	to, err := flattenTree(toTree)
+	if err != nil {
+		return nil, err
+	}
 
-			blobPath = fmt.Sprintf(".stk/objects/blobs/%s/%s", entry.Hash[:2], entry.Hash[2:])
+	merged := make(map[string][]byte)
+	for _, path := range changed {
+		base, inFrom := from[path]
+		next, inTo := to[path]
+		if !inFrom || !inTo || base.Type != "blob" || next.Type != "blob" {
+			return nil, fmt.Errorf("cannot merge local changes to %s; commit them or use --force", path)
+		}
 
-			data, _ := compressor.GetDecompressFile(blobPath) // returns []byte
-			outPath := filepath.Join(prefix, entry.Path)
-			os.MkdirAll(filepath.Dir(outPath), 0755)
-			os.WriteFile(outPath, data, 0644)
+		local, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		baseData, err := readBlob(base.Hash)
+		if err != nil {
+			return nil, err
+		}
+		nextData, err := readBlob(next.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if diff.IsBinary(local) || diff.IsBinary(baseData) || diff.IsBinary(nextData) {
+			return nil, fmt.Errorf("cannot merge local changes to binary file %s; commit them or use --force", path)
+		}
 
-		case "tree":
-			restoreTree(entry.Hash, prefix, tmpDir)
+		merged[path], _ = diff.Merge3(baseData, local, nextData, "local", target)
+	}
+	return merged, nil
+}
 
-		case "model":
-			models = append(models, entry)
+// warnUnreferencedCommits tells the user about commits made on a detached
+// HEAD that no branch, tag or remote-tracking ref can reach any more.
+func warnUnreferencedCommits(commit string) {
+	allRefs, err := refStore().List("refs/")
+	if err != nil {
+		log.Fatal("Error reading refs: ", err)
+	}
+	referenced := make(map[string]bool)
+	for _, ref := range allRefs {
+		for hash := range reachableCommits(ref.Hash) {
+			referenced[hash] = true
 		}
+	}
 
+	lost := missingCommitsFrom(commit, referenced)
+	if len(lost) == 0 {
+		return
 	}
 
-	for _, model := range models {
-		manifestDataBytes, _ := compressor.GetDecompressFile(fmt.Sprintf(".stk/models/%s/%s", model.Hash[:2], model.Hash[2:]))
-		var manifestData ModelManifest
-		json.Unmarshal(manifestDataBytes, &manifestData)
+	fmt.Printf("Warning: you are leaving %d commit(s) behind, not connected to any of your branches:\n", len(lost))
+	for _, hash := range lost {
+		commitData, _ := readCommit(hash)
+		fmt.Printf("  %s %s\n", shortHash(hash), firstLine(commitData.Message))
+	}
+	fmt.Printf("If you want to keep them, create a branch now with:\n  stk branch <name> %s\n", shortHash(commit))
+}
 
-		archData := fmt.Sprintf(".stk/objects/blobs/%s/%s", manifestData.Architecture[:2], manifestData.Architecture[2:])
-		metadataData := fmt.Sprintf(".stk/objects/blobs/%s/%s", manifestData.Metadata[:2], manifestData.Metadata[2:])
+// rebuildModel restores the chunks, architecture and metadata of a model
+// entry into tmpDir and has the handler for its format write model.Path.
+func rebuildModel(model Tree, tmpDir string) error {
+	manifestData, err := readModelManifest(model.Hash)
+	if err != nil {
+		return err
+	}
 
-		archFile := filepath.Join(tmpDir, "architecture.json")
-		metadataFile := filepath.Join(tmpDir, "metadata.json")
-		tensorFile := restoreChunks(manifestData.Chunks, tmpDir)
+	archData, err := readBlob(manifestData.Architecture)
+	if err != nil {
+		return err
+	}
+	metadataData, err := readBlob(manifestData.Metadata)
+	if err != nil {
+		return err
+	}
+
+	archFile := filepath.Join(tmpDir, "architecture.json")
+	metadataFile := filepath.Join(tmpDir, "metadata.json")
+	if err := prefetchChunks(model.Path, manifestData.Chunks); err != nil {
+		return err
+	}
+	tensorFile, err := restoreChunks(manifestData.Chunks, tmpDir)
+	if err != nil {
+		return err
+	}
 
-		compressor.DecompressFile(archData, archFile)
-		compressor.DecompressFile(metadataData, metadataFile)
+	if err := os.WriteFile(archFile, archData, 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(metadataFile, metadataData, 0644); err != nil {
+		return err
+	}
 
-		fmt.Println(archFile)
+	os.MkdirAll(filepath.Dir(model.Path), 0755)
 
-		os.Mkdir("models", 0755)
+	registry, err := modelHandlers()
+	if err != nil {
+		return err
+	}
+	absPath, _ := filepath.Abs(model.Path)
+	err = registry.Rebuild(handlers.RebuildRequest{
+		Architecture: archFile,
+		Metadata:     metadataFile,
+		Weights:      tensorFile,
+		Output:       absPath,
+		Progress:     printHandlerProgress,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to rebuild %s: %w", model.Path, err)
+	}
 
-		scriptPath := filepath.Join(tmpDir, "rebuild_model.py")
-		pythonPath := "/home/joyvin/miniforge3/envs/ml/bin/python"
-		absPath, _ := filepath.Abs(model.Path)
-		cmd := exec.Command(pythonPath, scriptPath, tensorFile, archFile, metadataFile, absPath)
-		cmd.Dir = tmpDir
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+	fmt.Println("Saved in:", model.Path)
+	return nil
+}
 
-		if err := cmd.Run(); err != nil {
-			fmt.Println("err")
-			return
+// newRebuildDir creates a scratch directory for rebuilding models.
+func newRebuildDir() (string, error) {
+	return os.MkdirTemp("", "stk-*")
+}
+
+// checkoutTree moves the working directory from the contents of fromTree to
+// those of toTree. Only entries that differ between the two trees are
+// written, rebuilt or deleted, and entries outside the sparse checkout
+// patterns are not written at all.
+func checkoutTree(fromTree, toTree string) error {
+	from, err := flattenTree(fromTree)
+	if err != nil {
+		return err
+	}
+	to, err := flattenTree(toTree)
+	if err != nil {
+		return err
+	}
+
+	for path := range from {
+		if _, ok := to[path]; !ok {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			removeEmptyParents(path)
+		}
+	}
+
+	sparse, err := readSparseCheckout()
+	if err != nil {
+		return err
+	}
+
+	var models []Tree
+	for path, entry := range to {
+		if prev, ok := from[path]; ok && prev == entry {
+			continue
+		}
+		if !sparse.includes(path) {
+			// Outside the sparse checkout: never materialized.
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			removeEmptyParents(path)
+			continue
+		}
+
+		switch entry.Type {
+		case "blob":
+			data, err := readBlob(entry.Hash)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				return err
+			}
+		case "model":
+			models = append(models, entry)
 		}
+	}
+
+	if len(models) == 0 {
+		return nil
+	}
 
-		fmt.Println("Saved in:", model.Path)
+	tmpDir, err := newRebuildDir()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
 
+	for _, model := range models {
+		if err := rebuildModel(model, tmpDir); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func restoreChunks(chunks []string, tmpDir string) string {
+func restoreChunks(chunks []string, tmpDir string) (string, error) {
 	tensorPath := filepath.Join(tmpDir, "weights.safetensors")
-	out, _ := os.Create(tensorPath)
+	out, err := os.Create(tensorPath)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
 
 	for _, chunk := range chunks {
-		chunkFile := fmt.Sprintf(".stk/objects/blobs/%s/%s", chunk[:2], chunk[2:])
-		data, _ := compressor.GetDecompressFile(chunkFile)
+		data, err := readBlob(chunk)
+		if err != nil {
+			return "", err
+		}
 
-		out.Write(data)
+		if _, err := out.Write(data); err != nil {
+			return "", err
+		}
 	}
 
-	return tensorPath
-
+	return tensorPath, nil
 }
 
 func init() {
 	rootCmd.AddCommand(checkoutCmd)
 	checkoutCmd.Flags().BoolVarP(&newBranch, "branch", "b", false, "Create a new branch and switch to it")
+	checkoutCmd.Flags().BoolVarP(&checkoutForce, "force", "f", false, "Discard local changes to files that differ between the two commits")
+	checkoutCmd.Flags().BoolVarP(&checkoutMerge, "merge", "m", false, "Three-way merge local changes into the checked out files")
 }
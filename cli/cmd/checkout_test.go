@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckoutDetached(t *testing.T) {
+	r := newTestRepo(t)
+	r.write("a.txt", "one\n")
+	r.commit("one")
+	first := r.head()
+	r.run("tag", "v1.0")
+	r.write("a.txt", "two\n")
+	r.commit("two")
+	second := r.head()
+
+	for _, target := range []string{"v1.0", first[:8], "main~1", "main^"} {
+		r.run("checkout", "main")
+		out := r.run("checkout", target)
+		if !strings.Contains(out, "detached HEAD") {
+			t.Errorf("checkout %s does not report the detached HEAD:\n%s", target, out)
+		}
+		if got := r.head(); got != first {
+			t.Errorf("checkout %s: HEAD = %s, want %s", target, got, first)
+		}
+		if got := r.read("a.txt"); got != "one\n" {
+			t.Errorf("checkout %s: a.txt = %q", target, got)
+		}
+		if got := strings.TrimSpace(r.read(".stk/HEAD")); got != first {
+			t.Errorf("checkout %s: .stk/HEAD = %q, want the bare hash", target, got)
+		}
+		if got := strings.TrimSpace(r.run("rev-parse", "--abbrev-ref", "HEAD")); got != "HEAD" {
+			t.Errorf("checkout %s: abbrev-ref HEAD = %q, want HEAD", target, got)
+		}
+	}
+
+	// A commit on a detached HEAD moves HEAD and no branch.
+	r.write("b.txt", "b\n")
+	r.commit("detached work")
+	lost := r.head()
+	if main := strings.TrimSpace(r.run("rev-parse", "main")); main != second {
+		t.Errorf("main moved to %s", main)
+	}
+
+	out := r.run("checkout", "main")
+	if !strings.Contains(out, "leaving 1 commit(s) behind") || !strings.Contains(out, shortHash(lost)) {
+		t.Errorf("leaving the detached commit was not reported:\n%s", out)
+	}
+	if got := strings.TrimSpace(r.read(".stk/HEAD")); got != "ref: refs/heads/main" {
+		t.Errorf(".stk/HEAD = %q after switching back", got)
+	}
+
+	if out, err := r.stk("checkout", "nope"); err == nil || !strings.Contains(out, "is not a branch or revision") {
+		t.Errorf("checkout of an unknown name (%v):\n%s", err, out)
+	}
+}
+
+func TestCheckoutKeepsLocalChanges(t *testing.T) {
+	r := newTestRepo(t)
+	r.write("a.txt", "a\n")
+	r.write("same.txt", "same\n")
+	r.commit("one")
+	r.run("checkout", "-b", "feat")
+	r.write("a.txt", "a\nfeat\n")
+	r.write("new.txt", "feat\n")
+	r.commit("two")
+	r.run("checkout", "main")
+
+	// Changes to files both commits agree on are carried along.
+	r.write("same.txt", "edited\n")
+	r.run("checkout", "feat")
+	if got := r.read("same.txt"); got != "edited\n" {
+		t.Errorf("same.txt = %q, want the local edit kept", got)
+	}
+	r.run("checkout", "main")
+
+	r.write("a.txt", "mine\na\n")
+	r.write("new.txt", "untracked\n")
+	out, err := r.stk("checkout", "feat")
+	if err == nil {
+		t.Fatalf("checkout succeeded over local changes:\n%s", out)
+	}
+	for _, path := range []string{"a.txt", "new.txt"} {
+		if !strings.Contains(out, "\t"+path) {
+			t.Errorf("checkout does not name %s:\n%s", path, out)
+		}
+	}
+	if got := r.read("a.txt"); got != "mine\na\n" {
+		t.Errorf("a.txt = %q after the refused checkout", got)
+	}
+	if got := strings.TrimSpace(r.run("rev-parse", "--abbrev-ref", "HEAD")); got != "main" {
+		t.Errorf("HEAD moved to %s", got)
+	}
+
+	// --merge carries the edit over; the untracked file has to go first.
+	if out, err := r.stk("checkout", "--merge", "feat"); err == nil {
+		t.Errorf("--merge replaced an untracked file:\n%s", out)
+	}
+	r.remove("new.txt")
+	r.run("checkout", "--merge", "feat")
+	if got := r.read("a.txt"); got != "mine\na\nfeat\n" {
+		t.Errorf("a.txt = %q after --merge, want both edits", got)
+	}
+
+	r.run("checkout", "--force", "main")
+	if got := r.read("a.txt"); got != "a\n" {
+		t.Errorf("a.txt = %q after --force, want main's version", got)
+	}
+	if r.exists("new.txt") {
+		t.Error("new.txt left behind by --force")
+	}
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The tests of this package drive stk as a user would, in a fresh
+// repository each: the test binary runs itself as stk when STK_TEST_MAIN
+// is set.
+func TestMain(m *testing.M) {
+	if os.Getenv("STK_TEST_MAIN") == "1" {
+		Execute()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+type testRepo struct {
+	t   *testing.T
+	dir string
+}
+
+// newTestRepo initializes a repository in a temporary directory.
+func newTestRepo(t *testing.T) *testRepo {
+	t.Helper()
+	r := &testRepo{t: t, dir: t.TempDir()}
+	r.run("init")
+	return r
+}
+
+// cloneTestRepo clones url into a temporary directory; args go before the
+// URL.
+func cloneTestRepo(t *testing.T, url string, args ...string) *testRepo {
+	t.Helper()
+	r := &testRepo{t: t, dir: filepath.Join(t.TempDir(), "clone")}
+	args = append(append([]string{"clone"}, args...), url, r.dir)
+	if out, err := r.stkIn(filepath.Dir(r.dir), args...); err != nil {
+		t.Fatalf("stk %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return r
+}
+
+// stk runs stk in the repository and returns its combined output.
+func (r *testRepo) stk(args ...string) (string, error) {
+	return r.stkIn(r.dir, args...)
+}
+
+func (r *testRepo) stkIn(dir string, args ...string) (string, error) {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "STK_TEST_MAIN=1", "STK_CACHE_DIR="+filepath.Join(r.dir, ".cache"))
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// run runs stk and fails the test if it fails.
+func (r *testRepo) run(args ...string) string {
+	r.t.Helper()
+	out, err := r.stk(args...)
+	if err != nil {
+		r.t.Fatalf("stk %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return out
+}
+
+func (r *testRepo) write(path, content string) {
+	r.t.Helper()
+	full := filepath.Join(r.dir, path)
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		r.t.Fatal(err)
+	}
+	if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+func (r *testRepo) read(path string) string {
+	r.t.Helper()
+	data, err := os.ReadFile(filepath.Join(r.dir, path))
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return string(data)
+}
+
+func (r *testRepo) remove(path string) {
+	r.t.Helper()
+	if err := os.Remove(filepath.Join(r.dir, path)); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+func (r *testRepo) exists(path string) bool {
+	_, err := os.Stat(filepath.Join(r.dir, path))
+	return err == nil
+}
+
+// commit stages everything and commits it.
+func (r *testRepo) commit(message string) {
+	r.t.Helper()
+	r.run("add", ".")
+	r.run("commit", "-m", message)
+}
+
+func (r *testRepo) head() string {
+	r.t.Helper()
+	return strings.TrimSpace(r.run("rev-parse", "HEAD"))
+}
@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sdk/pkg/refs"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var cloneCmd = &cobra.Command{
+	Use:   "clone <url> [<dir>]",
+	Short: "Copy a remote repository into a new directory",
+	Long: `Creates <dir>, named after the last part of the URL when omitted, with a
+new repository whose origin is <url>. All branches and tags are fetched,
+remote branches are recorded as refs/remotes/origin/<branch>, and the
+default branch (main, else master, else the first branch) is checked out
+and set to track its remote counterpart.
+
+--filter makes a partial clone that leaves model weights on the remote:
+models:none fetches no weight chunks, models:limit=<size> (e.g. 100m) only
+those of models up to that size. History, trees, model architecture and
+metadata are always fetched, so log, ls-tree and diffs of metadata work
+offline; missing chunks are downloaded when a checkout needs them and kept
+in a cache shared by all clones ($STK_CACHE_DIR, by default the user cache
+directory).
+Example:
+  stk clone s3://models/llama-ft
+  stk clone stk+https://app.example.com/alice/llama-ft
+  stk clone /mnt/shared/repos/vision vision-local
+  stk clone --filter=models:none s3://models/llama-ft`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := ""
+		if len(args) == 2 {
+			dir = args[1]
+		}
+		runClone(args[0], dir)
+	},
+}
+
+// cloneDirName derives the directory name for a clone of url.
+func cloneDirName(url string) string {
+	if i := strings.Index(url, "://"); i >= 0 {
+		url = url[i+3:]
+	}
+	name := path.Base(strings.TrimRight(filepath.ToSlash(url), "/"))
+	name = strings.TrimSuffix(name, ".stk")
+	if name == "" || name == "." || name == "/" {
+		return "repo"
+	}
+	return name
+}
+
+var cloneFilter string
+
+func runClone(url, dir string) {
+	if _, err := parseFilter(cloneFilter); err != nil {
+		log.Fatal(err)
+	}
+	if dir == "" {
+		dir = cloneDirName(url)
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		log.Fatalf("Destination path '%s' already exists and is not empty.", dir)
+	}
+	// A relative path to a directory remote must stay valid once we have
+	// changed into the new repository.
+	if !strings.Contains(url, "://") {
+		abs, err := filepath.Abs(url)
+		if err != nil {
+			log.Fatal(err)
+		}
+		url = abs
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Cloning into '%s'...\n", dir)
+	leave, err := enterWorktree(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer leave()
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	initRepository(cwd)
+	if err := saveRemotes(map[string]RemoteConfig{defaultRemoteName: {URL: url}}); err != nil {
+		log.Fatal("Error writing remotes: ", err)
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cloneFilter != "" {
+		config.Partial = &PartialConfig{Remote: defaultRemoteName, Filter: cloneFilter}
+		if err := saveConfig(config); err != nil {
+			log.Fatal("Error writing config: ", err)
+		}
+	}
+
+	remoteRefs, err := fetchRemote(defaultRemoteName, openRemote(defaultRemoteName, false))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	branch, commit := defaultBranch(remoteRefs)
+	if branch == "" {
+		fmt.Println("warning: You appear to have cloned an empty repository.")
+		return
+	}
+
+	store := refStore()
+	if err := store.Write(branch, commit); err != nil {
+		log.Fatal("Error creating branch: ", err)
+	}
+	if err := store.SetSymbolicHead(branch); err != nil {
+		log.Fatal("Error updating HEAD: ", err)
+	}
+	if config.Branches == nil {
+		config.Branches = make(map[string]BranchConfig)
+	}
+	config.Branches[refs.Short(branch)] = BranchConfig{Remote: defaultRemoteName, Merge: branch}
+	if err := saveConfig(config); err != nil {
+		log.Fatal("Error writing config: ", err)
+	}
+
+	treeHash, err := commitTree(commit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := checkoutTree("", treeHash); err != nil {
+		log.Fatal("Error populating working directory: ", err)
+	}
+	if err := writeIndexFromTree(treeHash); err != nil {
+		log.Fatal("Error writing index: ", err)
+	}
+	fmt.Printf("Checked out branch '%s'\n", refs.Short(branch))
+}
+
+// defaultBranch picks the branch a clone checks out: main, else master,
+// else the first branch by name.
+func defaultBranch(remoteRefs []refs.Ref) (string, string) {
+	heads := make(map[string]string)
+	first := ""
+	for _, ref := range remoteRefs {
+		if strings.HasPrefix(ref.Name, refs.HeadsPrefix) {
+			heads[ref.Name] = ref.Hash
+			if first == "" {
+				first = ref.Name
+			}
+		}
+	}
+	for _, name := range []string{refs.BranchRef("main"), refs.BranchRef("master"), first} {
+		if hash, ok := heads[name]; ok {
+			return name, hash
+		}
+	}
+	return "", ""
+}
+
+func init() {
+	rootCmd.AddCommand(cloneCmd)
+	addJobsFlag(cloneCmd)
+	cloneCmd.Flags().StringVar(&cloneFilter, "filter", "", "Leave model weights on the remote: models:none or models:limit=<size>")
+}
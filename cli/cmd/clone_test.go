@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"sdk/pkg/refs"
+	"strings"
+	"testing"
+)
+
+func TestCloneFetchPull(t *testing.T) {
+	url := t.TempDir()
+	a := newTestRepo(t)
+	a.write("a.txt", "a\n")
+	a.writeModel("models/m.safetensors", 1, "w")
+	a.commit("one")
+	a.run("remote", "add", "origin", url)
+	a.run("push", "-u")
+	a.run("checkout", "-b", "feat")
+	a.write("f.txt", "f\n")
+	a.commit("feat")
+	a.run("push", "-u")
+	a.run("checkout", "main")
+	// push sends no tags; put one on the remote directly.
+	if err := refs.New(url).Write(refs.TagsPrefix+"v1", a.head()); err != nil {
+		t.Fatal(err)
+	}
+
+	b := cloneTestRepo(t, url)
+	if got := strings.TrimSpace(b.run("rev-parse", "--abbrev-ref", "HEAD")); got != "main" {
+		t.Errorf("clone checked out %s, want main", got)
+	}
+	if got := b.read("a.txt"); got != "a\n" {
+		t.Errorf("a.txt = %q in the clone", got)
+	}
+	if got := b.modelValue("models/m.safetensors", "w"); got != 1 {
+		t.Errorf("cloned model has w = %g", got)
+	}
+	for rev, want := range map[string]string{"origin/feat": "feat", "v1": "main", "@{u}": "main"} {
+		if got, want := strings.TrimSpace(b.run("rev-parse", rev)), strings.TrimSpace(a.run("rev-parse", want)); got != want {
+			t.Errorf("%s = %s in the clone, want %s", rev, got, want)
+		}
+	}
+	if out := b.run("status"); !strings.Contains(out, "up to date with 'origin/main'") {
+		t.Errorf("status in the clone:\n%s", out)
+	}
+
+	// fetch only moves remote-tracking refs; pull fast-forwards.
+	a.write("a.txt", "a2\n")
+	a.commit("two")
+	a.run("push")
+	before := b.head()
+	b.run("fetch")
+	if b.head() != before || b.read("a.txt") != "a\n" {
+		t.Error("fetch changed the local branch or working directory")
+	}
+	b.run("pull")
+	if b.head() != a.head() || b.read("a.txt") != "a2\n" {
+		t.Errorf("pull did not fast-forward to %s", a.head())
+	}
+
+	// Diverged histories are merged.
+	a.write("a.txt", "a3\n")
+	a.commit("three")
+	a.run("push")
+	b.write("b.txt", "b\n")
+	b.commit("local")
+	local := b.head()
+	b.run("pull")
+	for rev, want := range map[string]string{"HEAD^1": local, "HEAD^2": a.head()} {
+		if got := strings.TrimSpace(b.run("rev-parse", rev)); got != want {
+			t.Errorf("%s after pull = %s, want %s", rev, got, want)
+		}
+	}
+	if b.read("a.txt") != "a3\n" || b.read("b.txt") != "b\n" {
+		t.Error("the pulled merge lost a side")
+	}
+
+	// A conflicting pull leaves the merge in progress and blocks the next.
+	a.write("a.txt", "theirs\n")
+	a.commit("four")
+	a.run("push")
+	b.write("a.txt", "ours\n")
+	b.commit("conflicting")
+	if out, err := b.stk("pull"); err == nil || !strings.Contains(out, "CONFLICT") {
+		t.Fatalf("conflicting pull (%v):\n%s", err, out)
+	}
+	if out, err := b.stk("pull"); err == nil || !strings.Contains(out, "A merge is in progress") {
+		t.Errorf("pull during a merge (%v):\n%s", err, out)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"sdk/pkg/compressor"
 	"sdk/pkg/hasher"
 	"sort"
@@ -22,9 +23,28 @@ type Tree struct {
 }
 
 type Commit struct {
-	Tree    string `json:"tree"`
-	Message string `json:"message"`
-	Parent  string `json:"parent"`
+	Tree    string   `json:"tree"`
+	Message string   `json:"message"`
+	Parents []string `json:"parents"`
+}
+
+// UnmarshalJSON reads the "parents" list and falls back to the single
+// "parent" field written by older versions of stk.
+func (c *Commit) UnmarshalJSON(data []byte) error {
+	type plainCommit Commit
+	var raw struct {
+		plainCommit
+		Parent string `json:"parent"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*c = Commit(raw.plainCommit)
+	if len(c.Parents) == 0 && raw.Parent != "" {
+		c.Parents = []string{raw.Parent}
+	}
+	return nil
 }
 
 var commitCmd = &cobra.Command{
@@ -37,35 +57,45 @@ var commitCmd = &cobra.Command{
 }
 
 func runCommit() {
+	if state, ok := readMergeState(); ok {
+		finishMerge(state, commitMessage)
+		return
+	}
+
+	hash := indexTree()
+
+	currCommit := headCommit()
 
+	parents := []string{}
+	if currCommit != "" {
+		parents = append(parents, currCommit)
+	}
+	commit := Commit{Tree: hash, Message: commitMessage, Parents: parents}
+
+	hash = createCommitFile(commit)
+	fmt.Println(headName())
+	updateHead(hash)
+}
+
+// indexTree writes the tree objects for the staged index and returns the hash
+// of the root tree.
+func indexTree() string {
 	index := make(NestedIndex)
 	modelIndex := make(NestedIndex)
 
-	data, err := os.ReadFile(".stk/index.json")
+	data, err := os.ReadFile(stkPath(indexFile))
 	if err != nil {
 		log.Fatal("Error in reading index", data)
-		return
 	}
 	json.Unmarshal(data, &index)
 
-	data, err = os.ReadFile(".stk/model_index.json")
+	data, err = os.ReadFile(stkPath(modelIndexFile))
 	if err != nil {
 		log.Fatal("Error in reading model index", data)
-		return
 	}
 	json.Unmarshal(data, &modelIndex)
 
-	hash := createTree(index, "", modelIndex)
-
-	branch, _ := os.ReadFile(".stk/HEAD")
-	currCommitBytes, _ := os.ReadFile(fmt.Sprintf(".stk/%s", branch))
-	currCommit := strings.TrimSpace(string(currCommitBytes))
-
-	commit := Commit{Tree: hash, Message: commitMessage, Parent: currCommit}
-
-	hash = createCommitFile(commit)
-	fmt.Println(string(branch))
-	_ = os.WriteFile(fmt.Sprintf(".stk/%s", string(branch)), []byte(hash), 0644)
+	return createTree(index, "", modelIndex)
 }
 
 func createTree(entry NestedIndex, prefix string, modelIndex NestedIndex) string {
@@ -106,7 +136,7 @@ func createTree(entry NestedIndex, prefix string, modelIndex NestedIndex) string
 func createModelTree(path string, modelIndex NestedIndex) string {
 	jsonModelTree, _ := json.MarshalIndent(modelIndex[path], "", "  ")
 	digest := hasher.HashData(jsonModelTree)
-	blobPath := fmt.Sprintf(".stk/models/%s/%s", digest[:2], digest[2:])
+	blobPath := commonPath("models", digest[:2], digest[2:])
 	compressor.CompressData(jsonModelTree, blobPath)
 
 	return digest
@@ -115,16 +145,50 @@ func createModelTree(path string, modelIndex NestedIndex) string {
 func createCommitFile(commitData Commit) string {
 	jsonCommitData, _ := json.MarshalIndent(commitData, "", "  ")
 	digest := hasher.HashData(jsonCommitData)
-	blobPath := fmt.Sprintf(".stk/commits/%s/%s", digest[:2], digest[2:])
+	blobPath := commonPath("commits", digest[:2], digest[2:])
 	compressor.CompressData(jsonCommitData, blobPath)
 	return digest
 }
 
+func readCommit(hash string) (Commit, error) {
+	var commit Commit
+	path, err := objectPath("commits", hash)
+	if err != nil {
+		return commit, err
+	}
+
+	data, err := compressor.GetDecompressFile(path)
+	if err != nil {
+		return commit, fmt.Errorf("failed to read commit %s: %w", hash, err)
+	}
+	if err := json.Unmarshal(data, &commit); err != nil {
+		return commit, fmt.Errorf("failed to parse commit %s: %w", hash, err)
+	}
+	return commit, nil
+}
+
+func readTree(hash string) ([]Tree, error) {
+	var entries []Tree
+	path, err := objectPath(filepath.Join("objects", "trees"), hash)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := compressor.GetDecompressFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tree %s: %w", hash, err)
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse tree %s: %w", hash, err)
+	}
+	return entries, nil
+}
+
 func createTreeFile(treeData []Tree) string {
 	jsonTreeData, _ := json.MarshalIndent(treeData, "", "  ")
-	digest := hasher.HashData(jsonTreeData)                                     // Hash the file
-	blobPath := fmt.Sprintf(".stk/objects/trees/%s/%s", digest[:2], digest[2:]) // Creates a blank file with the hash as filename
-	compressor.CompressData(jsonTreeData, blobPath)                             // stores the compressed file in the blank file
+	digest := hasher.HashData(jsonTreeData)                            // Hash the file
+	blobPath := commonPath("objects", "trees", digest[:2], digest[2:]) // Creates a blank file with the hash as filename
+	compressor.CompressData(jsonTreeData, blobPath)                    // stores the compressed file in the blank file
 	return digest
 }
 
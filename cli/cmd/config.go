@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const configFile = "config.json"
+
+// Config holds repository settings stored in .stk/config.json.
+type Config struct {
+	Merge    map[string]MergeDriver   `json:"merge,omitempty"`
+	Branches map[string]BranchConfig  `json:"branches,omitempty"`
+	Handlers map[string]HandlerConfig `json:"handlers,omitempty"`
+	// Python is the interpreter for the built-in PyTorch, Keras and ONNX
+	// handler. When empty it is discovered, see pyenv.Find.
+	Python string `json:"python,omitempty"`
+	// Partial is set in a partial clone, see partial.go.
+	Partial *PartialConfig `json:"partial,omitempty"`
+	// Transfer tunes pushes and fetches, see transfer.go.
+	Transfer *TransferConfig `json:"transfer,omitempty"`
+}
+
+// HandlerConfig registers an external model handler: a command speaking
+// the JSON protocol of pkg/handlers, the file patterns it extracts and the
+// architecture types it rebuilds.
+type HandlerConfig struct {
+	Command  string   `json:"command"`
+	Patterns []string `json:"patterns,omitempty"`
+	Formats  []string `json:"formats,omitempty"`
+}
+
+// BranchConfig records the upstream of a local branch: the remote it
+// tracks and the branch on that remote, e.g. "origin" and "refs/heads/main".
+type BranchConfig struct {
+	Remote string `json:"remote,omitempty"`
+	Merge  string `json:"merge,omitempty"`
+}
+
+// MergeDriver is an external command that merges one path. The command is
+// run through the shell after expanding %O (base), %A (ours, overwritten
+// with the result), %B (theirs) and %P (the path in the repository).
+type MergeDriver struct {
+	Name   string `json:"name,omitempty"`
+	Driver string `json:"driver"`
+}
+
+// loadConfig reads .stk/config.json. A missing file yields an empty config.
+func loadConfig() (Config, error) {
+	var config Config
+	data, err := os.ReadFile(commonPath(configFile))
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse %s: %w", commonPath(configFile), err)
+	}
+	return config, nil
+}
+
+// saveConfig writes config back to .stk/config.json.
+func saveConfig(config Config) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(commonPath(configFile), append(data, '\n'), 0644)
+}
@@ -0,0 +1,350 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sdk/pkg/pyenv"
+	"sdk/pkg/refs"
+	"sdk/pkg/remote"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/zalando/go-keyring"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the environment and repository for problems",
+	Long: `Checks the Python interpreter used by the model handlers and which ML
+frameworks it can import, the configured external handlers, the login
+token in the system keyring, the remote and its credentials, and the
+health of the repository in the current directory. Every problem comes
+with a suggested fix. Exits with status 1 if any check fails.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		d := &doctor{}
+		d.checkPython()
+		d.checkHandlers()
+		d.checkCredentials()
+		d.checkRepository()
+		if d.failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// doctor prints the outcome of each check and remembers whether any failed.
+type doctor struct {
+	failed bool
+}
+
+func (d *doctor) section(name string) {
+	fmt.Println(color.New(color.Bold).Sprint(name))
+}
+
+func (d *doctor) ok(format string, args ...interface{}) {
+	fmt.Printf("  %s %s\n", color.GreenString("✔"), fmt.Sprintf(format, args...))
+}
+
+// warn reports a problem that only limits what stk can do.
+func (d *doctor) warn(fix, format string, args ...interface{}) {
+	fmt.Printf("  %s %s\n", color.YellowString("!"), fmt.Sprintf(format, args...))
+	d.fix(fix)
+}
+
+// fail reports a problem that breaks stk.
+func (d *doctor) fail(fix, format string, args ...interface{}) {
+	d.failed = true
+	fmt.Printf("  %s %s\n", color.RedString("✘"), fmt.Sprintf(format, args...))
+	d.fix(fix)
+}
+
+func (d *doctor) fix(fix string) {
+	if fix != "" {
+		fmt.Printf("      fix: %s\n", fix)
+	}
+}
+
+// Modules imported by the bundled scripts, and the frameworks they use when
+// installed.
+var (
+	requiredModules  = []string{"numpy", "safetensors"}
+	frameworkModules = []string{"torch", "tensorflow", "onnx"}
+)
+
+func (d *doctor) checkPython() {
+	d.section("Python")
+
+	configured := ""
+	if isRepository() {
+		if config, err := loadConfig(); err == nil {
+			configured = config.Python
+		}
+	}
+	python, err := pyenv.Find(configured)
+	if err != nil {
+		d.warn(fmt.Sprintf("install Python 3, then set %s=/path/to/python or \"python\" in %s", pyenv.EnvVar, commonPath(configFile)),
+			"%v; PyTorch, Keras and ONNX models cannot be added", err)
+		return
+	}
+	version, err := python.Version()
+	if err != nil {
+		d.fail(fmt.Sprintf("point %s at a working interpreter", pyenv.EnvVar), "interpreter %s (%s) does not run: %v", python.Path, python.Source, err)
+		return
+	}
+	d.ok("%s at %s (from %s)", version, python.Path, python.Source)
+
+	versions, err := python.Modules(append(requiredModules, frameworkModules...)...)
+	if err != nil {
+		d.fail("", "cannot check installed modules: %v", err)
+		return
+	}
+	for _, module := range requiredModules {
+		if v, ok := versions[module]; ok {
+			d.ok("%s %s", module, v)
+		} else {
+			d.warn(python.Path+" -m pip install "+module, "%s is not installed; the PyTorch, Keras and ONNX handler needs it", module)
+		}
+	}
+	for _, module := range frameworkModules {
+		if v, ok := versions[module]; ok {
+			d.ok("%s %s", module, v)
+		} else {
+			d.warn(python.Path+" -m pip install "+module, "%s is not installed; its models cannot be added or checked out", module)
+		}
+	}
+}
+
+func (d *doctor) checkHandlers() {
+	if !isRepository() {
+		return
+	}
+	config, err := loadConfig()
+	if err != nil || len(config.Handlers) == 0 {
+		return
+	}
+
+	d.section("Handlers")
+	names := make([]string, 0, len(config.Handlers))
+	for name := range config.Handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		handler := config.Handlers[name]
+		fields := strings.Fields(handler.Command)
+		if len(fields) == 0 {
+			d.fail("set \"command\" for the handler in "+commonPath(configFile), "handler '%s' has no command", name)
+			continue
+		}
+		if _, err := exec.LookPath(fields[0]); err != nil && !strings.ContainsAny(handler.Command, "|&;$") {
+			d.fail("install "+fields[0]+" or fix the handler's command in "+commonPath(configFile), "handler '%s': %s not found", name, fields[0])
+			continue
+		}
+		d.ok("handler '%s' (%s)", name, handler.Command)
+	}
+}
+
+func (d *doctor) checkCredentials() {
+	d.section("Credentials")
+
+	_, err := keyring.Get("stackai", "token")
+	switch {
+	case err == nil:
+		d.ok("login token found in the system keyring")
+	case errors.Is(err, keyring.ErrNotFound):
+		d.warn("run stk login", "not logged in")
+	default:
+		d.warn("start a Secret Service provider (e.g. gnome-keyring) or unlock the keychain, then run stk login",
+			"system keyring unavailable: %v", err)
+	}
+
+	if !isRepository() {
+		return
+	}
+	remotes, err := loadRemotes()
+	if err != nil {
+		d.fail("fix "+commonPath(remoteFile), "%v", err)
+		return
+	}
+	if len(remotes) == 0 {
+		d.warn("stk remote add "+defaultRemoteName+" <url>", "no remote configured; push and fetch are unavailable")
+		return
+	}
+	token, _ := keyring.Get("stackai", "token")
+	checkedAWS := false
+	for _, name := range sortedRemoteNames(remotes) {
+		for _, url := range remoteURLs(remotes[name]) {
+			if strings.HasPrefix(url, "s3://") && !checkedAWS {
+				checkedAWS = true
+				if !haveAWSCredentials() {
+					d.warn("export AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, set AWS_PROFILE or run aws configure",
+						"no AWS credentials found for %s", url)
+				}
+				if os.Getenv("AWS_REGION") == "" && os.Getenv("AWS_DEFAULT_REGION") == "" {
+					d.warn("export AWS_REGION=<bucket region>", "AWS_REGION is not set")
+				}
+			}
+			r, err := remote.Open(url, remote.Options{Token: token})
+			if err != nil {
+				d.fail("stk remote set-url "+name+" <url>", "remote '%s': %v", name, err)
+				continue
+			}
+			all, err := r.ListRefs()
+			if err != nil {
+				d.fail("check the URL, your network and your credentials", "remote '%s' (%s) is unreachable: %v", name, url, err)
+				continue
+			}
+			d.ok("remote '%s' %s (%d refs)", name, url, len(all))
+		}
+	}
+}
+
+// remoteURLs returns the distinct URLs of a remote: the fetch URL and,
+// when it differs, the push URL.
+func remoteURLs(r RemoteConfig) []string {
+	if r.PushURL == "" || r.PushURL == r.URL {
+		return []string{r.URL}
+	}
+	return []string{r.URL, r.PushURL}
+}
+
+func haveAWSCredentials() bool {
+	if os.Getenv("AWS_ACCESS_KEY_ID") != "" || os.Getenv("AWS_PROFILE") != "" {
+		return true
+	}
+	file := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return false
+		}
+		file = filepath.Join(home, ".aws", "credentials")
+	}
+	return fileExists(file)
+}
+
+// isRepository reports whether the current directory is the top of a stk
+// worktree.
+func isRepository() bool {
+	_, err := os.Stat(".stk")
+	return err == nil
+}
+
+func (d *doctor) checkRepository() {
+	d.section("Repository")
+	if !isRepository() {
+		d.warn("run stk init, or run stk doctor from the top of a repository", "not a stk repository")
+		return
+	}
+	if _, err := os.Stat(commonDir()); err != nil {
+		d.fail("remove .stk and add the worktree again", "repository directory %s is missing", commonDir())
+		return
+	}
+
+	if _, err := loadConfig(); err != nil {
+		d.fail("fix or remove "+commonPath(configFile), "%v", err)
+	}
+	if _, _, err := readIndexFiles(); err != nil {
+		d.fail("run stk add . to rebuild the index", "cannot read the index: %v", err)
+	}
+
+	store := refs.NewWorktree(stkDir(), commonDir())
+	head, hash, err := store.Head()
+	if err != nil {
+		d.fail("write 'ref: refs/heads/main' to "+stkPath(headFile), "cannot read HEAD: %v", err)
+		return
+	}
+	all, err := store.List("refs/")
+	if err != nil {
+		d.fail("", "cannot list refs: %v", err)
+		return
+	}
+	broken := 0
+	for _, ref := range all {
+		if _, err := readCommit(ref.Hash); err != nil {
+			broken++
+			d.fail("stk branch -D "+refs.Short(ref.Name)+", or fetch the missing commit", "%s points at a missing commit %s", ref.Name, shortHash(ref.Hash))
+		}
+	}
+	if broken == 0 {
+		d.ok("%d refs", len(all))
+	}
+
+	if hash == "" {
+		d.ok("HEAD is %s with no commits yet", refs.Short(head))
+		return
+	}
+	commit, err := readCommit(hash)
+	if err != nil {
+		if head == "" {
+			d.fail("stk checkout <branch>", "detached HEAD points at a missing commit: %v", err)
+		}
+		return
+	}
+	partial, _ := partialClone()
+	if partial != nil {
+		d.ok("partial clone of %s (filter %s); weight chunks are fetched on demand", partial.Remote, partial.Filter)
+	}
+	missing, err := missingObjects(commit.Tree, partial != nil)
+	if err != nil {
+		d.fail("", "cannot read the HEAD tree: %v", err)
+		return
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		d.fail("restore the missing objects from a clone or a remote", "%d files in HEAD have missing objects: %s", len(missing), strings.Join(missing, ", "))
+		return
+	}
+	d.ok("all objects for HEAD (%s) are present", headName())
+}
+
+// missingObjects lists the paths below treeHash whose blob, model manifest
+// or weight chunks are not in the object store. In a partial clone the
+// chunks are the remote's to keep and are not checked.
+func missingObjects(treeHash string, partial bool) ([]string, error) {
+	entries, err := flattenTree(treeHash)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for path, entry := range entries {
+		if entry.Type != "model" {
+			if !objectExists(filepath.Join("objects", "blobs"), entry.Hash) {
+				missing = append(missing, path)
+			}
+			continue
+		}
+		manifest, err := readModelManifest(entry.Hash)
+		if err != nil {
+			missing = append(missing, path)
+			continue
+		}
+		blobs := []string{manifest.Architecture, manifest.Metadata}
+		if !partial {
+			blobs = append(blobs, manifest.Chunks...)
+		}
+		for _, hash := range blobs {
+			if !objectExists(filepath.Join("objects", "blobs"), hash) {
+				missing = append(missing, path)
+				break
+			}
+		}
+	}
+	return missing, nil
+}
+
+func objectExists(dir, hash string) bool {
+	path, err := objectPath(dir, hash)
+	return err == nil && fileExists(path)
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
@@ -0,0 +1,441 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sdk/pkg/compressor"
+	"sdk/pkg/hasher"
+	"sdk/pkg/refs"
+	"sdk/pkg/remote"
+	"sdk/pkg/transfer"
+	"strings"
+	"sync/atomic"
+
+	"github.com/spf13/cobra"
+)
+
+var fetchCmd = &cobra.Command{
+	Use:   "fetch [<remote>]",
+	Short: "Download branches and tags from a remote",
+	Long: `Downloads the commits, trees, model manifests and chunks of the remote's
+branches and tags that are not in the local repository, then records each
+remote branch as refs/remotes/<remote>/<branch>. The remote defaults to the
+upstream remote of the current branch, else origin. Local branches and the
+working directory are left alone; use stk merge origin/<branch> or stk pull
+to integrate the changes.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := remoteArg(args)
+		if _, err := fetchRemote(name, openRemote(name, false)); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// fetchRemote downloads the missing objects of every branch and tag on r,
+// updates the remote-tracking refs of remoteName and reports what changed.
+// It returns the remote's refs.
+func fetchRemote(remoteName string, r remote.Remote) ([]refs.Ref, error) {
+	remoteRefs, err := r.ListRefs()
+	if err != nil {
+		return nil, fmt.Errorf("listing remote refs: %w", err)
+	}
+
+	var tips []string
+	for _, ref := range remoteRefs {
+		tips = append(tips, ref.Hash)
+	}
+	partial, err := partialClone()
+	if err != nil {
+		return nil, err
+	}
+	received, err := fetchPack(r, tips, partial)
+	if errors.Is(err, errors.ErrUnsupported) {
+		f := newFetcher(r)
+		if partial != nil {
+			if f.modelLimit, err = parseFilter(partial.Filter); err != nil {
+				return nil, err
+			}
+		}
+		err = f.fetchCommits(tips)
+		received = int(f.objects.Load())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recordFetchedRefs(remoteName, r.URL(), remoteRefs); err != nil {
+		return nil, err
+	}
+	if received > 0 {
+		fmt.Printf("Received %d objects\n", received)
+	}
+	return remoteRefs, nil
+}
+
+// recordFetchedRefs points the remote-tracking refs of remoteName at the
+// fetched branches, creates the fetched tags that do not exist locally and
+// reports what changed as coming from source.
+func recordFetchedRefs(remoteName, source string, fetched []refs.Ref) error {
+	store := refStore()
+	var lines []string
+	for _, ref := range fetched {
+		switch {
+		case strings.HasPrefix(ref.Name, refs.HeadsPrefix):
+			local := trackingRef(remoteName, ref.Name)
+			old, _ := store.Read(local)
+			if old == ref.Hash {
+				continue
+			}
+			if err := store.Write(local, ref.Hash); err != nil {
+				return err
+			}
+			lines = append(lines, fetchLine(old, ref.Hash, refs.Short(ref.Name), refs.Short(local)))
+		case strings.HasPrefix(ref.Name, refs.TagsPrefix):
+			// Tags never move, so an existing local tag is kept.
+			if store.Exists(ref.Name) {
+				continue
+			}
+			if err := store.Write(ref.Name, ref.Hash); err != nil {
+				return err
+			}
+			lines = append(lines, fmt.Sprintf(" * %-20s %s -> %s", "[new tag]", refs.Short(ref.Name), refs.Short(ref.Name)))
+		}
+	}
+
+	if len(lines) > 0 {
+		fmt.Println("From " + source)
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+	}
+	return nil
+}
+
+// fetchPack asks a server that supports it for the objects of tips the
+// local repository lacks, naming the commits of every local ref as
+// already held, and stores the pack it answers with. It returns how many
+// objects were new, or errors.ErrUnsupported when r cannot send packs.
+func fetchPack(r remote.Remote, tips []string, partial *PartialConfig) (int, error) {
+	pt, ok := r.(remote.PackTransport)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+
+	var wants []string
+	wanted := make(map[string]bool)
+	for _, tip := range tips {
+		if !wanted[tip] && !objectExists(commitsDir, tip) {
+			wants = append(wants, tip)
+			wanted[tip] = true
+		}
+	}
+	if len(wants) == 0 {
+		return 0, nil
+	}
+	local, err := refStore().List("refs/")
+	if err != nil {
+		return 0, err
+	}
+	var haves []string
+	had := make(map[string]bool)
+	for _, ref := range local {
+		if !had[ref.Hash] && objectExists(commitsDir, ref.Hash) {
+			haves = append(haves, ref.Hash)
+			had[ref.Hash] = true
+		}
+	}
+	filter := ""
+	if partial != nil {
+		filter = partial.Filter
+	}
+
+	body, err := pt.FetchPack(wants, haves, filter)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	progress := transfer.NewProgress("Receiving objects", 0, 0)
+	received, err := storePack(body, progress)
+	progress.Finish()
+	if err != nil {
+		return received, fmt.Errorf("receiving pack: %w", err)
+	}
+	return received, nil
+}
+
+func fetchLine(old, hash, name, local string) string {
+	switch {
+	case old == "":
+		return fmt.Sprintf(" * %-20s %s -> %s", "[new branch]", name, local)
+	case isAncestor(old, hash):
+		return fmt.Sprintf("   %-20s %s -> %s", shortHash(old)+".."+shortHash(hash), name, local)
+	default:
+		return fmt.Sprintf(" + %-20s %s -> %s  (forced update)", shortHash(old)+"..."+shortHash(hash), name, local)
+	}
+}
+
+// fetcher copies objects from a remote into the local repository. Every
+// object is checked against its hash before it is stored. Commits, trees
+// and model manifests are downloaded first to find what they reference,
+// then blobs and chunks several at a time, and only then are the
+// commits, trees and manifests stored, dependencies first, so an
+// interrupted fetch never leaves a commit whose tree is incomplete.
+type fetcher struct {
+	remote  remote.Remote
+	objects atomic.Int64
+	// modelLimit is the filter of a partial clone, see parseFilter.
+	modelLimit int64
+
+	// pending holds downloaded commits, trees and manifests in the order
+	// they are stored.
+	pending []pendingObject
+	// data lists the blobs and chunks to download.
+	data   []transfer.Job
+	queued map[string]bool
+}
+
+type pendingObject struct {
+	dir, hash string
+	raw       []byte
+}
+
+func newFetcher(r remote.Remote) *fetcher {
+	return &fetcher{remote: r, modelLimit: noModelFilter, queued: make(map[string]bool)}
+}
+
+// fetchCommits downloads the commits reachable from tips that are missing
+// locally, along with everything they reference.
+func (f *fetcher) fetchCommits(tips []string) error {
+	type commit struct {
+		hash string
+		raw  []byte
+		tree string
+	}
+	var commits []commit
+	seen := make(map[string]bool)
+	queue := append([]string(nil), tips...)
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if seen[hash] || objectExists(commitsDir, hash) {
+			continue
+		}
+		seen[hash] = true
+
+		raw, data, err := f.get(commitsDir, hash)
+		if err != nil {
+			return err
+		}
+		var c Commit
+		if err := json.Unmarshal(data, &c); err != nil {
+			return fmt.Errorf("failed to parse commit %s: %w", hash, err)
+		}
+		commits = append(commits, commit{hash: hash, raw: raw, tree: c.Tree})
+		queue = append(queue, c.Parents...)
+	}
+
+	// Parents were queued after their children, so walking in reverse
+	// order puts ancestors first.
+	for i := len(commits) - 1; i >= 0; i-- {
+		if err := f.walkTree(commits[i].tree); err != nil {
+			return err
+		}
+	}
+	for i := len(commits) - 1; i >= 0; i-- {
+		f.pending = append(f.pending, pendingObject{commitsDir, commits[i].hash, commits[i].raw})
+	}
+
+	if err := f.fetchData(); err != nil {
+		return err
+	}
+	for _, obj := range f.pending {
+		if err := f.store(obj.dir, obj.hash, obj.raw); err != nil {
+			return err
+		}
+	}
+	f.pending = nil
+	return nil
+}
+
+func (f *fetcher) walkTree(hash string) error {
+	if objectExists(treesDir, hash) || !f.queue(treesDir, hash) {
+		return nil
+	}
+	raw, data, err := f.get(treesDir, hash)
+	if err != nil {
+		return err
+	}
+	var entries []Tree
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("failed to parse tree %s: %w", hash, err)
+	}
+
+	for _, entry := range entries {
+		switch entry.Type {
+		case "tree":
+			err = f.walkTree(entry.Hash)
+		case "model":
+			err = f.walkModel(entry.Hash)
+		default:
+			f.queueBlob(entry.Hash)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	f.pending = append(f.pending, pendingObject{treesDir, hash, raw})
+	return nil
+}
+
+func (f *fetcher) walkModel(hash string) error {
+	if objectExists(modelsDir, hash) || !f.queue(modelsDir, hash) {
+		return nil
+	}
+	raw, data, err := f.get(modelsDir, hash)
+	if err != nil {
+		return err
+	}
+	var manifest ModelManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("failed to parse model manifest %s: %w", hash, err)
+	}
+
+	f.queueBlob(manifest.Architecture)
+	f.queueBlob(manifest.Metadata)
+	if f.modelLimit == noModelFilter {
+		for _, chunk := range manifest.Chunks {
+			f.queueBlob(chunk)
+		}
+	} else if err := f.fetchChunks(manifest.Chunks); err != nil {
+		return err
+	}
+	f.pending = append(f.pending, pendingObject{modelsDir, hash, raw})
+	return nil
+}
+
+// queue reports whether an object is seen for the first time.
+func (f *fetcher) queue(dir, hash string) bool {
+	key := objectKey(dir, hash)
+	if f.queued[key] {
+		return false
+	}
+	f.queued[key] = true
+	return true
+}
+
+func (f *fetcher) queueBlob(hash string) {
+	if !objectExists(blobsDir, hash) && f.queue(blobsDir, hash) {
+		f.data = append(f.data, transfer.Job{Key: hash})
+	}
+}
+
+// fetchChunks downloads the weight chunks of a model in a partial clone,
+// one by one, stopping once modelLimit bytes have been read and leaving
+// the rest of a larger model to be fetched on demand.
+func (f *fetcher) fetchChunks(chunks []string) error {
+	var fetched int64
+	for _, chunk := range chunks {
+		if fetched >= f.modelLimit {
+			return nil
+		}
+		if objectExists(blobsDir, chunk) {
+			continue
+		}
+		raw, data, err := f.get(blobsDir, chunk)
+		if err != nil {
+			return err
+		}
+		fetched += int64(len(data))
+		if err := f.store(blobsDir, chunk, raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchData downloads the queued blobs and chunks, several at a time.
+func (f *fetcher) fetchData() error {
+	if len(f.data) == 0 {
+		return nil
+	}
+	progress := transfer.NewProgress("Receiving objects", len(f.data), 0)
+	defer progress.Finish()
+	err := transfer.Run(f.data, transferOptions(progress), func(job transfer.Job, count func(int64)) error {
+		raw, _, err := f.get(blobsDir, job.Key)
+		if err != nil {
+			return transferError(err)
+		}
+		count(int64(len(raw)))
+		return f.store(blobsDir, job.Key, raw)
+	})
+	f.data = nil
+	return err
+}
+
+// get downloads an object and returns it as stored, compressed, and
+// decompressed after checking it against hash.
+func (f *fetcher) get(dir, hash string) ([]byte, []byte, error) {
+	if !isObjectHash(hash) {
+		return nil, nil, fmt.Errorf("invalid object hash %q", hash)
+	}
+	key := objectKey(dir, hash)
+	body, err := f.remote.Get(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("downloading %s: %w", key, err)
+	}
+	defer body.Close()
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("downloading %s: %w", key, err)
+	}
+
+	data, err := compressor.DecompressData(raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("remote object %s is corrupt: %w", key, err)
+	}
+	if hasher.HashData(data) != hash {
+		return nil, nil, fmt.Errorf("remote object %s does not match its hash", key)
+	}
+	return raw, data, nil
+}
+
+// store writes a downloaded object into place.
+func (f *fetcher) store(dir, hash string, raw []byte) error {
+	if err := storeObject(dir, hash, raw); err != nil {
+		return err
+	}
+	f.objects.Add(1)
+	return nil
+}
+
+// storeObject writes an object as stored, compressed, into the repository
+// through a temporary file.
+func storeObject(dir, hash string, raw []byte) error {
+	path, err := objectPath(dir, hash)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(fetchCmd)
+	addJobsFlag(fetchCmd)
+}
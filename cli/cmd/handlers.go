@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"sdk/pkg/handlers"
+	"sdk/pkg/pyenv"
+	"sort"
+)
+
+// modelHandlers builds the handler registry: external handlers from
+// .stk/config.json first, so they can take over a format, then the
+// built-in ones.
+func modelHandlers() (*handlers.Registry, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(config.Handlers))
+	for name := range config.Handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	registry := handlers.NewRegistry()
+	for _, name := range names {
+		handler := config.Handlers[name]
+		if handler.Command == "" {
+			return nil, fmt.Errorf("handler '%s' in %s has no command", name, commonPath(configFile))
+		}
+		registry.Register(&handlers.External{
+			HandlerName: name,
+			Command:     handler.Command,
+			Patterns:    handler.Patterns,
+			Types:       handler.Formats,
+		})
+	}
+	registry.Register(handlers.Safetensors{})
+	// Without an interpreter the Python handler still claims its formats,
+	// so the user is told what is missing instead of "unsupported".
+	python, err := pyenv.Find(config.Python)
+	registry.Register(handlers.Python{Interpreter: python.Path, Unavailable: err})
+	return registry, nil
+}
+
+func printHandlerProgress(p handlers.Progress) {
+	line := p.Handler + ": " + p.Stage
+	if p.Total > 0 {
+		line += fmt.Sprintf(" %d/%d", p.Done, p.Total)
+	}
+	if p.Message != "" {
+		line += " " + p.Message
+	}
+	fmt.Println(line)
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"log"
+	"sdk/pkg/refs"
+)
+
+// refStore opens the ref store of the current repository, upgrading the
+// old .stk/branches layout on first use.
+func refStore() *refs.Store {
+	store := refs.NewWorktree(stkDir(), commonDir())
+	if err := store.Migrate(); err != nil {
+		log.Fatal("Error migrating branches to refs/heads: ", err)
+	}
+	return store
+}
+
+// currentBranch returns the full ref name HEAD points to, e.g.
+// refs/heads/main, or an empty string when HEAD is detached.
+func currentBranch() string {
+	head, _, err := refStore().Head()
+	if err != nil {
+		log.Fatal("Error reading HEAD: ", err)
+	}
+	return head
+}
+
+// headCommit returns the commit HEAD points to, or an empty string on an
+// unborn branch.
+func headCommit() string {
+	_, hash, err := refStore().Head()
+	if err != nil {
+		log.Fatal("Error reading HEAD: ", err)
+	}
+	return hash
+}
+
+// headName describes HEAD for messages: the short branch name, or
+// "HEAD detached at <hash>".
+func headName() string {
+	head, hash, err := refStore().Head()
+	if err != nil {
+		log.Fatal("Error reading HEAD: ", err)
+	}
+	if head == "" {
+		return "HEAD detached at " + shortHash(hash)
+	}
+	return refs.Short(head)
+}
+
+// updateHead moves the branch HEAD points to onto commit, or HEAD itself
+// when it is detached.
+func updateHead(commit string) {
+	store := refStore()
+	head := currentBranch()
+	if head == "" {
+		if err := store.SetDetachedHead(commit); err != nil {
+			log.Fatal("Error updating HEAD: ", err)
+		}
+		return
+	}
+	if err := store.Write(head, commit); err != nil {
+		log.Fatal("Error updating ", head, ": ", err)
+	}
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
@@ -19,41 +19,45 @@ var initCmd = &cobra.Command{
 			fmt.Println("Error:", err)
 			return
 		}
+		initRepository(dir)
+	},
+}
 
-		dirs := []string{
-			filepath.Join(dir, ".stk", "branches"),
-			filepath.Join(dir, ".stk", "commits"),
-			filepath.Join(dir, ".stk", "objects"),
-			filepath.Join(dir, ".stk", "objects", "blobs"),
-			filepath.Join(dir, ".stk", "objects", "trees"),
-			filepath.Join(dir, ".stk", "objects", "models"),
-		}
+// initRepository creates an empty repository in dir.
+func initRepository(dir string) {
+	dirs := []string{
+		filepath.Join(dir, ".stk", "refs", "heads"),
+		filepath.Join(dir, ".stk", "refs", "tags"),
+		filepath.Join(dir, ".stk", "refs", "remotes"),
+		filepath.Join(dir, ".stk", "commits"),
+		filepath.Join(dir, ".stk", "objects"),
+		filepath.Join(dir, ".stk", "objects", "blobs"),
+		filepath.Join(dir, ".stk", "objects", "trees"),
+		filepath.Join(dir, ".stk", "objects", "models"),
+	}
 
-		// Create directories
-		for _, dir := range dirs {
-			if err := os.MkdirAll(dir, 0755); err != nil {
-				helpers.PrintError("failed to create dir %s: %w", dir, err)
-			}
+	// Create directories
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			helpers.PrintError("failed to create dir %s: %v", dir, err)
 		}
+	}
 
-		// Create files
-		files := map[string]string{
-			filepath.Join(dir, ".stk", "HEAD"):             "branches/main",
-			filepath.Join(dir, ".stk", "branches", "main"): "",
-			filepath.Join(dir, ".stk", "index.json"):       "",
-			filepath.Join(dir, ".stk", "model_index.json"): "",
-			filepath.Join(dir, ".stk", "remote.json"):      `{"origin": ""}`,
-		}
+	// Create files
+	files := map[string]string{
+		filepath.Join(dir, ".stk", "HEAD"):             "ref: refs/heads/main\n",
+		filepath.Join(dir, ".stk", "index.json"):       "",
+		filepath.Join(dir, ".stk", "model_index.json"): "",
+		filepath.Join(dir, ".stk", "remote.json"):      `{}`,
+	}
 
-		for path, content := range files {
-			if _, err := os.Stat(path); os.IsNotExist(err) {
-				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
-					helpers.PrintError("failed to create dir %s: %w", dir, err)
-				}
+	for path, content := range files {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				helpers.PrintError("failed to create dir %s: %v", dir, err)
 			}
 		}
-
-	},
+	}
 }
 
 func init() {
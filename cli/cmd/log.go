@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var logOneline bool
+
+var logCmd = &cobra.Command{
+	Use:   "log",
+	Short: "Show commit history",
+	Long: `Show the commits reachable from HEAD, newest first.
+Merge commits list all of their parents and every parent's history is shown.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runLog()
+	},
+}
+
+func runLog() {
+	currCommit := headCommit()
+
+	if currCommit == "" {
+		fmt.Println("No commits yet.")
+		return
+	}
+
+	for _, hash := range topoOrder(currCommit) {
+		commit, err := readCommit(hash)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if logOneline {
+			fmt.Printf("%s %s\n", shortHash(hash), firstLine(commit.Message))
+			continue
+		}
+
+		fmt.Println("commit", hash)
+		if len(commit.Parents) > 1 {
+			short := make([]string, len(commit.Parents))
+			for i, parent := range commit.Parents {
+				short[i] = shortHash(parent)
+			}
+			fmt.Println("Merge:", strings.Join(short, " "))
+		}
+		fmt.Println()
+		for _, line := range strings.Split(commit.Message, "\n") {
+			fmt.Println("    " + line)
+		}
+		fmt.Println()
+	}
+}
+
+// topoOrder lists the commits reachable from start so that every commit is
+// printed before any of its parents. First parents are preferred, which keeps
+// the mainline together.
+func topoOrder(start string) []string {
+	parents := make(map[string][]string)
+	children := make(map[string]int)
+
+	stack := []string{start}
+	for len(stack) > 0 {
+		curr := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := parents[curr]; ok {
+			continue
+		}
+
+		commit, err := readCommit(curr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		parents[curr] = commit.Parents
+		for _, parent := range commit.Parents {
+			children[parent]++
+			stack = append(stack, parent)
+		}
+	}
+
+	var order []string
+	ready := []string{start}
+	for len(ready) > 0 {
+		curr := ready[len(ready)-1]
+		ready = ready[:len(ready)-1]
+		order = append(order, curr)
+
+		commitParents := parents[curr]
+		for i := len(commitParents) - 1; i >= 0; i-- {
+			parent := commitParents[i]
+			children[parent]--
+			if children[parent] == 0 {
+				ready = append(ready, parent)
+			}
+		}
+	}
+	return order
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+func init() {
+	rootCmd.AddCommand(logCmd)
+	logCmd.Flags().BoolVar(&logOneline, "oneline", false, "Show each commit on a single line")
+}
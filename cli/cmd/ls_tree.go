@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var lsTreeRecursive bool
+
+var lsTreeCmd = &cobra.Command{
+	Use:   "ls-tree [-r] <tree-ish>",
+	Short: "List the contents of a tree",
+	Long: `Lists the entries of the tree of a commit, or of <rev>:<path>, as
+"<type> <hash>\t<path>". Types are blob, tree and model. -r lists every
+file below the tree instead of descending one level.
+Example:
+  stk ls-tree HEAD
+  stk ls-tree -r main:models`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runLsTree(args[0])
+	},
+}
+
+func runLsTree(expr string) {
+	entry, err := resolveObject(expr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if entry.Type == "commit" {
+		treeHash, err := commitTree(entry.Hash)
+		if err != nil {
+			log.Fatal(err)
+		}
+		entry = Tree{Type: "tree", Hash: treeHash}
+	}
+	if entry.Type != "tree" {
+		printTreeEntry(entry)
+		return
+	}
+
+	var entries []Tree
+	if lsTreeRecursive {
+		flat, err := flattenTree(entry.Hash)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, e := range flat {
+			entries = append(entries, e)
+		}
+	} else if entries, err = readTree(entry.Hash); err != nil {
+		log.Fatal(err)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	for _, e := range entries {
+		printTreeEntry(e)
+	}
+}
+
+func printTreeEntry(entry Tree) {
+	fmt.Printf("%s %s\t%s\n", entry.Type, entry.Hash, strings.TrimPrefix(entry.Path, "/"))
+}
+
+func init() {
+	rootCmd.AddCommand(lsTreeCmd)
+	lsTreeCmd.Flags().BoolVarP(&lsTreeRecursive, "recursive", "r", false, "List files in subtrees too")
+}
@@ -3,100 +3,631 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
-	"sdk/pkg/compressor"
+	"sdk/pkg/diff"
+	"sort"
 	"strings"
 
 	"github.com/spf13/cobra"
 )
 
+var (
+	mergeAbort    bool
+	mergeContinue bool
+	mergeFFOnly   bool
+	mergeNoFF     bool
+)
+
+const mergeStateFile = "merge_state.json"
+
+// MergeState records an interrupted merge so it can be continued or aborted.
+type MergeState struct {
+	Head      string          `json:"head"`
+	MergeHead string          `json:"merge_head"`
+	Message   string          `json:"message"`
+	Tree      string          `json:"tree"`
+	Conflicts []MergeConflict `json:"conflicts"`
+}
+
+// MergeConflict is one path the merge could not resolve. Resolved is set
+// once 'stk add' stages the path again.
+type MergeConflict struct {
+	Path     string   `json:"path"`
+	Type     string   `json:"type"`
+	Reason   string   `json:"reason"`
+	Details  []string `json:"details,omitempty"`
+	Resolved bool     `json:"resolved,omitempty"`
+}
+
 // mergeCmd represents the hello command
 var mergeCmd = &cobra.Command{
-	Use:   "merge",
+	Use:   "merge [branch]",
 	Short: "Merge 2 branches",
-	Long:  `Used to merge 2 branches`,
-	Args:  cobra.ExactArgs(1),
+	Long: `Used to merge 2 branches.
+Example:
+  stk merge feature-x    # merge feature-x into the current branch
+  stk merge -s weights --method=ties feature-x
+                         # combine models changed on both sides
+  stk merge --rule "fc3.* theirs" --rule "*.bias avg" feature-x
+                         # pick or merge tensors by name
+  stk merge --continue   # commit a merge after resolving conflicts
+  stk merge --abort      # give up on a conflicted merge
+
+Tensor rules can also be listed one "<pattern> <rule>" pair per line in a
+.stkmerge file committed at the top of the repository; the one in the
+current branch applies. Rules are ours, theirs, base, avg, task-arith, ties
+or slerp; the first matching pattern wins, starting with --rule.
+
+Paths given a merge=<name> attribute in the .stkattributes committed in the
+current branch are merged by the external driver defined under "merge" in
+.stk/config.json:
+  {"merge": {"<name>": {"driver": "python merge.py %O %A %B %P"}}}
+%O, %A and %B are replaced by files holding the base, our and their
+version, and %P by the path. The driver writes its result to %A; a non-zero
+exit status leaves the path in conflict.`,
+	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		otherBranch := args[0]
-		runMerge(otherBranch)
+		mergeAlphaSet = cmd.Flags().Changed("alpha")
+		if mergeFFOnly && mergeNoFF {
+			log.Fatal("--ff-only and --no-ff cannot be used together.")
+		}
+		switch {
+		case mergeAbort:
+			runMergeAbort()
+		case mergeContinue:
+			runMergeContinue()
+		case len(args) == 1:
+			otherBranch := args[0]
+			runMerge(otherBranch)
+		default:
+			log.Fatal("Usage: stk merge <branch> | --continue | --abort")
+		}
 	},
 }
 
 func runMerge(otherBranch string) {
-	head, _ := os.ReadFile(".stk/HEAD")
-	baseCommitBytes, _ := os.ReadFile(fmt.Sprintf(".stk/%s", head))
-	baseCommit := strings.TrimSpace(string(baseCommitBytes))
+	if _, ok := readMergeState(); ok {
+		log.Fatal("A merge is already in progress. Use 'stk merge --continue' or 'stk merge --abort'.")
+	}
 
-	otherCommitBytes, _ := os.ReadFile(fmt.Sprintf(".stk/branches/%s", otherBranch))
-	otherCommit := strings.TrimSpace(string(otherCommitBytes))
+	baseCommit := headCommit()
 
-	baseCommitDataBytes, _ := compressor.GetDecompressFile(filepath.Join(".stk", "commits", baseCommit[:2], baseCommit[2:]))
-	var baseCommitData Commit
-	json.Unmarshal(baseCommitDataBytes, &baseCommitData)
+	_, otherCommit, err := refStore().Resolve(otherBranch)
+	if err != nil {
+		log.Fatalf("Branch '%s' does not exist.", otherBranch)
+	}
 
-	otherCommitDataBytes, _ := compressor.GetDecompressFile(filepath.Join(".stk", "commits", otherCommit[:2], otherCommit[2:]))
-	var otherCommitData Commit
-	json.Unmarshal(otherCommitDataBytes, &otherCommitData)
+	mergingWithChild := baseCommit == "" || isAncestor(baseCommit, otherCommit)
+	mergingWithAncestor := isAncestor(otherCommit, baseCommit)
 
-	baseTree := baseCommitData.Tree
-	otherTree := otherCommitData.Tree
+	if mergingWithAncestor {
+		fmt.Println("Already up to date.")
+		return
+	} else if mergingWithChild && (!mergeNoFF || baseCommit == "") {
+		fastForward(baseCommit, otherCommit)
+		return
+	} else if mergeFFOnly {
+		log.Fatal("Not possible to fast-forward, aborting.")
+	}
 
-	mergingWithChild := isAncestor(baseCommit, otherCommit)
-	mergingWithAncestor := isAncestor(otherCommit, baseCommit)
+	mergeBaseCommit, err := mergeBase(baseCommit, otherCommit)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	if mergingWithChild {
-		_ = os.WriteFile(fmt.Sprintf(".stk/%s", head), []byte(otherCommit), 0644)
-	} else if mergingWithAncestor {
+	oursTree, err := commitTree(baseCommit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	theirsTree, err := commitTree(otherCommit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ancestorTree, err := commitTree(mergeBaseCommit)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	merger := &treeMerger{oursLabel: "HEAD", theirsLabel: otherBranch}
+	if mergeStrategy != "recursive" && mergeStrategy != "weights" {
+		log.Fatalf("Unknown merge strategy '%s' (expected recursive or weights).", mergeStrategy)
+	}
+	merger.weights, err = weightsMergeFromFlags(mergeStrategy, oursTree)
+	if err != nil {
+		log.Fatal(err)
+	}
+	merger.attributes, err = readAttributes(oursTree)
+	if err != nil {
+		log.Fatal(err)
+	}
+	merger.config, err = loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mergedTree, err := merger.mergeRoot(ancestorTree, oursTree, theirsTree)
+	if err != nil {
+		log.Fatal(err)
+	}
+	refuseOverwrite(oursTree, mergedTree)
+
+	if err := checkoutTree(oursTree, mergedTree); err != nil {
+		log.Fatal("Error updating working tree: ", err)
+	}
+	if err := writeIndexFromTree(mergedTree); err != nil {
+		log.Fatal("Error updating index: ", err)
+	}
+
+	state := MergeState{
+		Head:      baseCommit,
+		MergeHead: otherCommit,
+		Message:   fmt.Sprintf("Merge branch '%s'", otherBranch),
+		Tree:      mergedTree,
+		Conflicts: merger.conflicts,
+	}
+
+	if len(state.Conflicts) == 0 {
+		finishMerge(state, "")
 		return
-	} else {
-		mergeTrees(baseTree, otherTree)
 	}
+
+	if err := writeMergeState(state); err != nil {
+		log.Fatal(err)
+	}
+	for _, conflict := range state.Conflicts {
+		fmt.Printf("CONFLICT (%s): %s\n", conflict.Reason, conflict.Path)
+		for _, detail := range conflict.Details {
+			fmt.Println("    " + detail)
+		}
+	}
+	fmt.Println("Automatic merge failed; fix conflicts, run 'stk add', then 'stk merge --continue'.")
+	os.Exit(1)
+}
+
+// fastForward moves the current branch from baseCommit to its descendant
+// otherCommit, updating the working tree and index to match.
+func fastForward(baseCommit, otherCommit string) {
+	oursTree, err := commitTree(baseCommit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	theirsTree, err := commitTree(otherCommit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	refuseOverwrite(oursTree, theirsTree)
+
+	if err := checkoutTree(oursTree, theirsTree); err != nil {
+		log.Fatal("Error updating working tree: ", err)
+	}
+	if err := writeIndexFromTree(theirsTree); err != nil {
+		log.Fatal("Error updating index: ", err)
+	}
+	updateHead(otherCommit)
+
+	if baseCommit != "" {
+		fmt.Printf("Updating %s..%s\n", shortHash(baseCommit), shortHash(otherCommit))
+	}
+	fmt.Println("Fast-forward")
+	if err := printDiffStat(oursTree, theirsTree); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// refuseOverwrite stops a merge before anything is written when moving
+// the working tree from fromTree to toTree would lose local changes or
+// untracked files.
+func refuseOverwrite(fromTree, toTree string) {
+	changed, err := localChanges(fromTree, toTree)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(changed) == 0 {
+		return
+	}
+	printOverwritten("merge", changed)
+	fmt.Println("Please commit your changes or move them away before you merge.")
+	os.Exit(1)
+}
+
+func runMergeContinue() {
+	state, ok := readMergeState()
+	if !ok {
+		log.Fatal("There is no merge in progress.")
+	}
+	finishMerge(state, "")
+}
+
+func runMergeAbort() {
+	state, ok := readMergeState()
+	if !ok {
+		log.Fatal("There is no merge in progress.")
+	}
+
+	oursTree, err := commitTree(state.Head)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := checkoutTree(state.Tree, oursTree); err != nil {
+		log.Fatal("Error restoring working tree: ", err)
+	}
+	if err := writeIndexFromTree(oursTree); err != nil {
+		log.Fatal("Error restoring index: ", err)
+	}
+	if err := os.Remove(stkPath(mergeStateFile)); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Merge aborted.")
+}
+
+// finishMerge records the staged index as a merge commit of the current
+// branch and state.MergeHead. Conflicts that were not staged again with
+// 'stk add', and text files that still contain conflict markers, block the
+// commit.
+func finishMerge(state MergeState, message string) {
+	var unresolved []string
+	for _, conflict := range state.Conflicts {
+		if !conflict.Resolved {
+			unresolved = append(unresolved, conflict.Path)
+		}
+	}
+	if len(unresolved) > 0 {
+		for _, path := range unresolved {
+			fmt.Println("unresolved:", path)
+		}
+		log.Fatal("Resolve the conflicts and run 'stk add' before continuing.")
+	}
+
+	tree := indexTree()
+
+	staged, err := flattenTree(tree)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, conflict := range state.Conflicts {
+		entry, ok := staged[conflict.Path]
+		if !ok || entry.Type != "blob" {
+			continue
+		}
+		data, err := readBlob(entry.Hash)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if diff.HasConflictMarkers(data) {
+			unresolved = append(unresolved, conflict.Path)
+		}
+	}
+	if len(unresolved) > 0 {
+		for _, path := range unresolved {
+			fmt.Println("unresolved:", path)
+		}
+		log.Fatal("Resolve the conflict markers and run 'stk add' before continuing.")
+	}
+
+	if message == "" {
+		message = state.Message
+	}
+
+	commit := Commit{
+		Tree:    tree,
+		Message: message,
+		Parents: []string{headCommit(), state.MergeHead},
+	}
+	hash := createCommitFile(commit)
+	updateHead(hash)
+
+	if err := os.Remove(stkPath(mergeStateFile)); err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+	fmt.Printf("Merge made by commit %s\n", hash)
+}
+
+func readMergeState() (MergeState, bool) {
+	var state MergeState
+	data, err := os.ReadFile(stkPath(mergeStateFile))
+	if err != nil {
+		return state, false
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Fatal("Error reading merge state: ", err)
+	}
+	return state, true
+}
+
+// markResolved records the conflicts under rootPath as resolved after
+// 'stk add' staged it. Outside a merge it does nothing.
+func markResolved(rootPath string) error {
+	state, ok := readMergeState()
+	if !ok {
+		return nil
+	}
+	root := filepath.ToSlash(filepath.Clean(rootPath))
+	for i, conflict := range state.Conflicts {
+		if root == "." || conflict.Path == root || strings.HasPrefix(conflict.Path, root+"/") {
+			state.Conflicts[i].Resolved = true
+		}
+	}
+	return writeMergeState(state)
+}
+
+func writeMergeState(state MergeState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(stkPath(mergeStateFile), data, 0644)
 }
 
-func mergeTrees(baseTreeId string, otherTreeId string) {
-	currTreeData := []Tree{}
+// mergeBase returns the best common ancestor of two commits: a common
+// ancestor that is not itself an ancestor of another common ancestor. When
+// several exist (criss-cross history) the first one found is used. An empty
+// string means the histories are unrelated.
+func mergeBase(a, b string) (string, error) {
+	ancestorsOfA := reachableCommits(a)
 
-	var baseTree, otherTree []Tree
-	baseHashTable := make(map[string]string)
-	otherHashTable := make(map[string]string)
+	var candidates []string
+	seen := make(map[string]bool)
+	queue := []string{b}
+	for len(queue) > 0 {
+		curr := queue[0]
+		queue = queue[1:]
+		if curr == "" || seen[curr] {
+			continue
+		}
+		seen[curr] = true
 
-	baseTreeDataBytes, _ := compressor.GetDecompressFile(filepath.Join(".stk", "objects", "trees", baseTreeId[:2], baseTreeId[2:]))
-	json.Unmarshal(baseTreeDataBytes, &baseTree)
-	otherTreeDataBytes, _ := compressor.GetDecompressFile(filepath.Join(".stk", "objects", "trees", otherTreeId[:2], otherTreeId[2:]))
-	json.Unmarshal(otherTreeDataBytes, &otherTree)
+		if ancestorsOfA[curr] {
+			candidates = append(candidates, curr)
+			continue
+		}
 
-	for _, tree := range baseTree {
-		baseHashTable[tree.Path] = tree.Hash
+		commitData, err := readCommit(curr)
+		if err != nil {
+			return "", err
+		}
+		queue = append(queue, commitData.Parents...)
 	}
 
-	for _, tree := range otherTree {
-		otherHashTable[tree.Path] = tree.Hash
-		v, ok := baseHashTable[tree.Path]
-		if ok && v != tree.Hash {
-			fmt.Println("exists:", tree.Path, v, tree.Hash)
-		} else {
-			currTreeData = append(currTreeData, Tree{Type: tree.Type, Hash: tree.Hash, Path: tree.Path})
+	for _, candidate := range candidates {
+		best := true
+		for _, other := range candidates {
+			if other != candidate && isAncestor(candidate, other) {
+				best = false
+				break
+			}
+		}
+		if best {
+			return candidate, nil
 		}
 	}
+	return "", nil
+}
+
+// treeMerger performs a recursive three-way merge of trees, collecting the
+// conflicts it cannot resolve on its own.
+type treeMerger struct {
+	oursLabel   string
+	theirsLabel string
+	weights     *weightsMerge
+	attributes  []attributeRule
+	config      Config
+	conflicts   []MergeConflict
+}
 
-	fmt.Println(baseHashTable)
-	fmt.Println(otherHashTable)
+// mergeRoot merges three root trees. Any of them may be empty.
+func (m *treeMerger) mergeRoot(base, ours, theirs string) (string, error) {
+	hash, err := m.mergeTree(base, ours, theirs)
+	if err != nil {
+		return "", err
+	}
+	if hash == "" {
+		hash = createTreeFile([]Tree{})
+	}
+	return hash, nil
 }
 
+// mergeTree merges one level of the three trees and returns the hash of the
+// merged tree, or an empty string when nothing is left at this level.
+func (m *treeMerger) mergeTree(base, ours, theirs string) (string, error) {
+	baseEntries, err := treeEntries(base)
+	if err != nil {
+		return "", err
+	}
+	oursEntries, err := treeEntries(ours)
+	if err != nil {
+		return "", err
+	}
+	theirsEntries, err := treeEntries(theirs)
+	if err != nil {
+		return "", err
+	}
+
+	pathSet := make(map[string]bool)
+	for _, entries := range []map[string]*Tree{baseEntries, oursEntries, theirsEntries} {
+		for path := range entries {
+			pathSet[path] = true
+		}
+	}
+	paths := make([]string, 0, len(pathSet))
+	for path := range pathSet {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	merged := []Tree{}
+	for _, path := range paths {
+		entry, err := m.mergeEntry(baseEntries[path], oursEntries[path], theirsEntries[path])
+		if err != nil {
+			return "", err
+		}
+		if entry != nil {
+			merged = append(merged, *entry)
+		}
+	}
+
+	if len(merged) == 0 {
+		return "", nil
+	}
+	return createTreeFile(merged), nil
+}
+
+func (m *treeMerger) mergeEntry(base, ours, theirs *Tree) (*Tree, error) {
+	switch {
+	case sameEntry(ours, theirs):
+		return ours, nil
+	case sameEntry(ours, base):
+		return theirs, nil
+	case sameEntry(theirs, base):
+		return ours, nil
+	}
+
+	if ours == nil || theirs == nil {
+		kept, reason := ours, "deleted by them"
+		if ours == nil {
+			kept, reason = theirs, "deleted by us"
+		}
+		m.addConflict(kept, reason)
+		return kept, nil
+	}
+
+	if ours.Type != theirs.Type {
+		m.addConflict(ours, "type changed")
+		return ours, nil
+	}
+
+	if ours.Type != "tree" {
+		name, driver, err := m.mergeDriverFor(ours.Path)
+		if err != nil {
+			return nil, err
+		}
+		if driver != nil {
+			return m.runMergeDriver(name, driver, base, ours, theirs)
+		}
+	}
+
+	switch ours.Type {
+	case "tree":
+		baseHash := ""
+		if base != nil && base.Type == "tree" {
+			baseHash = base.Hash
+		}
+		hash, err := m.mergeTree(baseHash, ours.Hash, theirs.Hash)
+		if err != nil || hash == "" {
+			return nil, err
+		}
+		return &Tree{Type: "tree", Path: ours.Path, Hash: hash}, nil
+
+	case "blob":
+		return m.mergeBlob(base, ours, theirs)
+
+	case "model":
+		if m.weights != nil {
+			return m.mergeModel(base, ours, theirs)
+		}
+		m.addConflict(ours, "both modified")
+		return ours, nil
+
+	default:
+		m.addConflict(ours, "both modified")
+		return ours, nil
+	}
+}
+
+// mergeBlob merges the contents of two blobs line by line. Binary blobs are
+// not merged and keep our version.
+func (m *treeMerger) mergeBlob(base, ours, theirs *Tree) (*Tree, error) {
+	var baseData []byte
+	if base != nil && base.Type == "blob" {
+		data, err := readBlob(base.Hash)
+		if err != nil {
+			return nil, err
+		}
+		baseData = data
+	}
+	oursData, err := readBlob(ours.Hash)
+	if err != nil {
+		return nil, err
+	}
+	theirsData, err := readBlob(theirs.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if diff.IsBinary(baseData) || diff.IsBinary(oursData) || diff.IsBinary(theirsData) {
+		m.addConflict(ours, "binary content")
+		return ours, nil
+	}
+
+	mergedData, conflict := diff.Merge3(baseData, oursData, theirsData, m.oursLabel, m.theirsLabel)
+	hash, err := createBlob(mergedData)
+	if err != nil {
+		return nil, err
+	}
+	if conflict {
+		m.addConflict(ours, "content")
+	}
+	return &Tree{Type: "blob", Path: ours.Path, Hash: hash}, nil
+}
+
+func (m *treeMerger) addConflict(entry *Tree, reason string, details ...string) {
+	m.conflicts = append(m.conflicts, MergeConflict{Path: entry.Path, Type: entry.Type, Reason: reason, Details: details})
+}
+
+func sameEntry(a, b *Tree) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Type == b.Type && a.Hash == b.Hash
+}
+
+// treeEntries loads one level of a tree keyed by path. An empty hash yields
+// an empty map.
+func treeEntries(hash string) (map[string]*Tree, error) {
+	entries := make(map[string]*Tree)
+	if hash == "" {
+		return entries, nil
+	}
+
+	tree, err := readTree(hash)
+	if err != nil {
+		return nil, err
+	}
+	for i := range tree {
+		entries[tree[i].Path] = &tree[i]
+	}
+	return entries, nil
+}
+
+// isAncestor reports whether baseCommit is reachable from otherCommit by
+// following parent links, including every parent of a merge commit.
 func isAncestor(baseCommit, otherCommit string) bool {
-	if otherCommit == "" {
+	if baseCommit == "" || otherCommit == "" {
 		return false
 	}
 
-	if baseCommit == otherCommit {
-		return true
-	}
+	seen := make(map[string]bool)
+	queue := []string{otherCommit}
+	for len(queue) > 0 {
+		commit := queue[0]
+		queue = queue[1:]
 
-	otherCommitDataBytes, _ := compressor.GetDecompressFile(filepath.Join(".stk", "commits", otherCommit[:2], otherCommit[2:]))
-	var otherCommitData Commit
-	json.Unmarshal(otherCommitDataBytes, &otherCommitData)
-	return isAncestor(baseCommit, otherCommitData.Parent)
+		if commit == baseCommit {
+			return true
+		}
+		if seen[commit] {
+			continue
+		}
+		seen[commit] = true
+
+		commitData, err := readCommit(commit)
+		if err != nil {
+			log.Fatal(err)
+		}
+		queue = append(queue, commitData.Parents...)
+	}
+	return false
 }
 
 // func createMergedTree(entry NestedIndex, prefix string) string {
@@ -108,6 +639,15 @@ func isAncestor(baseCommit, otherCommit string) bool {
 
 func init() {
 	rootCmd.AddCommand(mergeCmd)
+	mergeCmd.Flags().BoolVar(&mergeAbort, "abort", false, "Abort the current conflicted merge")
+	mergeCmd.Flags().BoolVar(&mergeContinue, "continue", false, "Commit the current merge once conflicts are resolved")
+	mergeCmd.Flags().BoolVar(&mergeFFOnly, "ff-only", false, "Refuse to merge unless the current branch can be fast-forwarded")
+	mergeCmd.Flags().BoolVar(&mergeNoFF, "no-ff", false, "Create a merge commit even when a fast-forward is possible")
+	mergeCmd.Flags().StringVarP(&mergeStrategy, "strategy", "s", "recursive", "Merge strategy: recursive or weights")
+	mergeCmd.Flags().StringVar(&mergeMethod, "method", "avg", "Weight merge method for -s weights: avg, task-arith, ties or slerp")
+	mergeCmd.Flags().Float64Var(&mergeAlpha, "alpha", 0, "Interpolation weight (avg, slerp) or task vector scale (task-arith, ties)")
+	mergeCmd.Flags().StringArrayVar(&mergeRules, "rule", nil, "Tensor rule \"<pattern> <rule>\", checked before .stkmerge")
+	mergeCmd.Flags().Float64Var(&mergeDensity, "density", 0.2, "Fraction of each task vector kept by ties")
 }
 
 // d7c74f36ecc1bfd2f6737abfceea3ee509e9cbca907f49c4ea03935992a4ccca
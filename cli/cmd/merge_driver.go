@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const attributesFile = ".stkattributes"
+
+// attributeRule is one line of .stkattributes: a path pattern followed by
+// key=value attributes, e.g. "models/*.gguf merge=gguf".
+type attributeRule struct {
+	pattern string
+	attrs   map[string]string
+}
+
+// readAttributes parses the .stkattributes committed in tree, so that a
+// merge picks the same drivers in every clone and worktree. A missing file
+// yields no rules.
+func readAttributes(tree string) ([]attributeRule, error) {
+	data, err := readTreeFile(tree, attributesFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []attributeRule
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		rule := attributeRule{pattern: fields[0], attrs: make(map[string]string)}
+		for _, field := range fields[1:] {
+			key, value, _ := strings.Cut(field, "=")
+			rule.attrs[key] = value
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// attribute returns the value of key for path. Later lines override earlier
+// ones, and patterns without a slash match the file name in any directory.
+func attribute(rules []attributeRule, filePath, key string) string {
+	value := ""
+	for _, rule := range rules {
+		target := filePath
+		if !strings.Contains(rule.pattern, "/") {
+			target = path.Base(filePath)
+		}
+		if ok, _ := path.Match(rule.pattern, target); !ok {
+			continue
+		}
+		if v, ok := rule.attrs[key]; ok {
+			value = v
+		}
+	}
+	return value
+}
+
+// mergeDriverFor returns the driver configured for path through the merge
+// attribute, if any.
+func (m *treeMerger) mergeDriverFor(filePath string) (string, *MergeDriver, error) {
+	name := attribute(m.attributes, filePath, "merge")
+	if name == "" {
+		return "", nil, nil
+	}
+	driver, ok := m.config.Merge[name]
+	if !ok || driver.Driver == "" {
+		return "", nil, fmt.Errorf("%s: merge driver '%s' is not defined in %s", filePath, name, commonPath(configFile))
+	}
+	return name, &driver, nil
+}
+
+// runMergeDriver materializes base, ours and theirs to temporary files, runs
+// the driver on them and ingests the file it leaves at %A. A non-zero exit
+// status keeps our version and records a conflict.
+func (m *treeMerger) runMergeDriver(name string, driver *MergeDriver, base, ours, theirs *Tree) (*Tree, error) {
+	tmpDir, err := newRebuildDir()
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	sidesDir := filepath.Join(tmpDir, "sides")
+	if err := os.MkdirAll(sidesDir, 0755); err != nil {
+		return nil, err
+	}
+	ext := filepath.Ext(ours.Path)
+	basePath := filepath.Join(sidesDir, "base"+ext)
+	oursPath := filepath.Join(sidesDir, "ours"+ext)
+	theirsPath := filepath.Join(sidesDir, "theirs"+ext)
+
+	if base != nil && base.Type == ours.Type {
+		if err := materializeEntry(*base, basePath, tmpDir); err != nil {
+			return nil, err
+		}
+	} else if err := os.WriteFile(basePath, nil, 0644); err != nil {
+		return nil, err
+	}
+	if err := materializeEntry(*ours, oursPath, tmpDir); err != nil {
+		return nil, err
+	}
+	if err := materializeEntry(*theirs, theirsPath, tmpDir); err != nil {
+		return nil, err
+	}
+
+	command := strings.NewReplacer(
+		"%O", shellQuote(basePath),
+		"%A", shellQuote(oursPath),
+		"%B", shellQuote(theirsPath),
+		"%P", shellQuote(ours.Path),
+	).Replace(driver.Driver)
+
+	fmt.Printf("Auto-merging %s with driver '%s'\n", ours.Path, name)
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return nil, fmt.Errorf("failed to run merge driver '%s': %w", name, err)
+		}
+		m.addConflict(ours, "merge driver "+name, err.Error())
+		return ours, nil
+	}
+
+	switch ours.Type {
+	case "model":
+		manifest, err := extractModel(oursPath, tmpDir, ".")
+		if err != nil {
+			return nil, err
+		}
+		hash, err := createModelManifest(manifest)
+		if err != nil {
+			return nil, err
+		}
+		return &Tree{Type: "model", Path: ours.Path, Hash: hash}, nil
+
+	default:
+		data, err := os.ReadFile(oursPath)
+		if err != nil {
+			return nil, err
+		}
+		hash, err := createBlob(data)
+		if err != nil {
+			return nil, err
+		}
+		return &Tree{Type: "blob", Path: ours.Path, Hash: hash}, nil
+	}
+}
+
+// materializeEntry writes the content of a blob or model entry to outPath.
+// Models are rebuilt from their chunks inside tmpDir.
+func materializeEntry(entry Tree, outPath string, tmpDir string) error {
+	if entry.Type == "model" {
+		return rebuildModel(Tree{Type: "model", Hash: entry.Hash, Path: outPath}, tmpDir)
+	}
+
+	data, err := readBlob(entry.Hash)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outPath, data, 0644)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
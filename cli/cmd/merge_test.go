@@ -0,0 +1,396 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sdk/pkg/safetensors"
+	"strings"
+	"testing"
+)
+
+// writeModel writes a safetensors model holding one F32 tensor per name,
+// each set to value.
+func (r *testRepo) writeModel(path string, value float64, names ...string) {
+	r.t.Helper()
+	f := safetensors.New()
+	for _, name := range names {
+		tensor, err := safetensors.FromFloats("F32", []int64{2}, []float64{value, value})
+		if err != nil {
+			r.t.Fatal(err)
+		}
+		f.Set(name, tensor)
+	}
+	data, err := f.Bytes()
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	r.write(path, string(data))
+}
+
+func TestFastForwardKeepsLocalChanges(t *testing.T) {
+	r := newTestRepo(t)
+	r.write("a.txt", "a\n")
+	r.commit("one")
+	r.run("checkout", "-b", "feat")
+	r.write("a.txt", "feat\n")
+	r.commit("two")
+	r.run("checkout", "main")
+	before := r.head()
+
+	r.write("a.txt", "mine\n")
+	out, err := r.stk("merge", "feat")
+	if err == nil {
+		t.Fatalf("merge succeeded over a local change:\n%s", out)
+	}
+	if !strings.Contains(out, "would be overwritten by merge") || !strings.Contains(out, "a.txt") {
+		t.Errorf("merge output does not name the change:\n%s", out)
+	}
+	if got := r.read("a.txt"); got != "mine\n" {
+		t.Errorf("a.txt = %q, want the local edit", got)
+	}
+	if got := r.head(); got != before {
+		t.Errorf("HEAD moved to %s", got)
+	}
+}
+
+func TestMergeKeepsUntrackedFiles(t *testing.T) {
+	r := newTestRepo(t)
+	r.write("a.txt", "a\n")
+	r.commit("one")
+	r.run("checkout", "-b", "feat")
+	r.write("z.txt", "z\n")
+	r.commit("add z")
+	r.run("checkout", "main")
+	r.write("b.txt", "b\n")
+	r.commit("add b")
+	before := r.head()
+
+	r.write("z.txt", "untracked\n")
+	out, err := r.stk("merge", "feat")
+	if err == nil {
+		t.Fatalf("merge succeeded over an untracked file:\n%s", out)
+	}
+	if !strings.Contains(out, "would be overwritten by merge") || !strings.Contains(out, "z.txt") {
+		t.Errorf("merge output does not name the file:\n%s", out)
+	}
+	if got := r.read("z.txt"); got != "untracked\n" {
+		t.Errorf("z.txt = %q, want the untracked content", got)
+	}
+	if got := r.head(); got != before {
+		t.Errorf("HEAD moved to %s", got)
+	}
+	if out, err := r.stk("merge", "--abort"); err == nil {
+		t.Errorf("a merge was left in progress:\n%s", out)
+	}
+}
+
+func TestMergeWithoutLocalChanges(t *testing.T) {
+	r := newTestRepo(t)
+	r.write("a.txt", "a\n")
+	r.commit("one")
+	r.run("checkout", "-b", "feat")
+	r.write("z.txt", "z\n")
+	r.commit("add z")
+	r.run("checkout", "main")
+	r.write("b.txt", "b\n")
+	r.commit("add b")
+
+	r.run("merge", "feat")
+	if got := r.read("z.txt"); got != "z\n" {
+		t.Errorf("z.txt = %q after the merge", got)
+	}
+}
+
+func TestMergeDriverFromLinkedWorktree(t *testing.T) {
+	r := newTestRepo(t)
+	r.write(".stk/config.json", `{"merge": {"union": {"driver": "cat %B >> %A"}}}`)
+	r.write(".stkattributes", "*.txt merge=union\n")
+	r.write("a.txt", "base\n")
+	r.commit("one")
+	wt := filepath.Join(t.TempDir(), "wt")
+	r.run("worktree", "add", "-b", "feat", wt)
+	r.write("a.txt", "main\n")
+	r.commit("main edit")
+
+	// The worktree gets the attributes from the commit it checked out.
+	if err := os.WriteFile(filepath.Join(wt, "a.txt"), []byte("feat\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{{"add", "."}, {"commit", "-m", "feat edit"}, {"merge", "main"}} {
+		if out, err := r.stkIn(wt, args...); err != nil {
+			t.Fatalf("stk %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(wt, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "feat\nmain\n" {
+		t.Errorf("a.txt = %q, want the driver's union of both sides", got)
+	}
+}
+
+func TestMergeContinueRefusesUnresolvedModel(t *testing.T) {
+	r := newTestRepo(t)
+	r.writeModel("models/m.safetensors", 1, "w")
+	r.commit("one")
+	r.run("checkout", "-b", "feat")
+	r.writeModel("models/m.safetensors", 2, "w")
+	r.commit("theirs")
+	theirs := r.head()
+	r.run("checkout", "main")
+	r.writeModel("models/m.safetensors", 3, "w")
+	r.commit("ours")
+	before := r.head()
+
+	if out, err := r.stk("merge", "feat"); err == nil || !strings.Contains(out, "CONFLICT (both modified): models/m.safetensors") {
+		t.Fatalf("merge did not report the model conflict (%v):\n%s", err, out)
+	}
+	for _, args := range [][]string{{"merge", "--continue"}, {"commit", "-m", "merged"}} {
+		out, err := r.stk(args...)
+		if err == nil {
+			t.Fatalf("stk %s committed an unresolved model:\n%s", strings.Join(args, " "), out)
+		}
+		if !strings.Contains(out, "unresolved: models/m.safetensors") {
+			t.Errorf("stk %s does not name the conflict:\n%s", strings.Join(args, " "), out)
+		}
+	}
+	if got := r.head(); got != before {
+		t.Fatalf("HEAD moved to %s", got)
+	}
+
+	r.run("add", ".")
+	r.run("merge", "--continue")
+	if got := strings.TrimSpace(r.run("rev-parse", "HEAD^2")); got != theirs {
+		t.Errorf("HEAD^2 = %s, want %s", got, theirs)
+	}
+}
+
+// fakeHandler configures an external handler for *.pt files that stores
+// them as their safetensors weights with the given architecture document
+// and rebuilds them as a copy of the stored architecture, so a checkout
+// shows which architecture a model entry points to.
+func (r *testRepo) fakeHandler(architecture string) {
+	r.t.Helper()
+	r.write(".handler.sh", `read req
+field() { printf '%s' "$req" | sed -n "s/.*\"$1\":\"\([^\"]*\)\".*/\1/p"; }
+case $(field op) in
+extract)
+	cp "$(field path)" weights.safetensors
+	echo '{}' > metadata.json
+	echo '`+architecture+`' > architecture.json ;;
+rebuild)
+	cp "$(field architecture)" "$(field output)" ;;
+esac
+echo '{"type": "result"}'
+`)
+	config := map[string]interface{}{
+		"handlers": map[string]interface{}{
+			"fake": map[string]interface{}{
+				"command":  "sh " + filepath.Join(r.dir, ".handler.sh"),
+				"patterns": []string{"*.pt"},
+				"formats":  []string{"pytorch", "keras"},
+			},
+		},
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	r.write(".stk/config.json", string(data))
+}
+
+func TestMergeRegeneratesArchitecture(t *testing.T) {
+	tests := []struct {
+		name, architecture, want string
+	}{
+		{"pytorch", `{"type": "pytorch", "tensor_keys": ["w"]}`, `"tensor_keys": [
+    "w",
+    "b"
+  ]`},
+		{"keras", `{"type": "keras", "weight_keys": ["w"]}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepo(t)
+			r.fakeHandler(tt.architecture)
+			r.writeModel("models/m.pt", 1, "w")
+			r.commit("one")
+			r.run("checkout", "-b", "feat")
+			r.writeModel("models/m.pt", 2, "w", "b")
+			r.commit("add b")
+			r.run("checkout", "main")
+			r.writeModel("models/m.pt", 3, "w")
+			r.commit("ours")
+
+			out, err := r.stk("merge", "feat", "--rule", "w ours", "--rule", "b theirs")
+			if tt.want == "" {
+				if err == nil || !strings.Contains(out, "CONFLICT (tensor set changed): models/m.pt") || !strings.Contains(out, "added b") {
+					t.Fatalf("merge did not refuse to keep a stale architecture (%v):\n%s", err, out)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("merge failed: %v\n%s", err, out)
+			}
+			if got := r.read("models/m.pt"); !strings.Contains(got, tt.want) {
+				t.Errorf("merged architecture = %s, want tensor_keys w, b", got)
+			}
+		})
+	}
+}
+
+// modelValue returns the first value of the tensor name in the model at path.
+func (r *testRepo) modelValue(path, name string) float64 {
+	r.t.Helper()
+	f, err := safetensors.Parse([]byte(r.read(path)))
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	tensor, ok := f.Tensors[name]
+	if !ok {
+		r.t.Fatalf("%s has no tensor %s", path, name)
+	}
+	values, err := tensor.Floats()
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return values[0]
+}
+
+func TestMergeTensorRules(t *testing.T) {
+	setup := func(t *testing.T) *testRepo {
+		r := newTestRepo(t)
+		r.writeModel("models/m.safetensors", 1, "w", "b", "c")
+		r.commit("one")
+		r.run("checkout", "-b", "feat")
+		r.writeModel("models/m.safetensors", 2, "w", "b", "c")
+		r.commit("theirs")
+		r.run("checkout", "main")
+		r.writeModel("models/m.safetensors", 4, "w", "b", "c")
+		r.write(".stkmerge", "# committed with the branch\nw ours\nb theirs\nc avg\n")
+		r.commit("ours")
+		return r
+	}
+
+	t.Run("committed rules", func(t *testing.T) {
+		r := setup(t)
+		// The rules are read from the commit, not the working tree.
+		if err := os.Remove(filepath.Join(r.dir, ".stkmerge")); err != nil {
+			t.Fatal(err)
+		}
+		r.run("merge", "feat")
+		for name, want := range map[string]float64{"w": 4, "b": 2, "c": 3} {
+			if got := r.modelValue("models/m.safetensors", name); got != want {
+				t.Errorf("%s = %g, want %g", name, got, want)
+			}
+		}
+	})
+
+	t.Run("flags first", func(t *testing.T) {
+		r := setup(t)
+		r.run("merge", "feat", "--rule", "b ours", "--rule", "c base")
+		for name, want := range map[string]float64{"w": 4, "b": 4, "c": 1} {
+			if got := r.modelValue("models/m.safetensors", name); got != want {
+				t.Errorf("%s = %g, want %g", name, got, want)
+			}
+		}
+	})
+
+	t.Run("unmatched tensor", func(t *testing.T) {
+		r := setup(t)
+		r.write(".stkmerge", "w ours\n")
+		r.commit("fewer rules")
+		out, err := r.stk("merge", "feat")
+		if err == nil || !strings.Contains(out, "b: changed on both sides and no rule matched") {
+			t.Errorf("merge did not report the unmatched tensor (%v):\n%s", err, out)
+		}
+	})
+
+	t.Run("bad rule", func(t *testing.T) {
+		r := setup(t)
+		out, err := r.stk("merge", "feat", "--rule", "w mean")
+		if err == nil || !strings.Contains(out, `unknown rule "mean"`) {
+			t.Errorf("merge accepted an unknown rule (%v):\n%s", err, out)
+		}
+	})
+}
+
+func TestMergeAttributesFromCommit(t *testing.T) {
+	r := newTestRepo(t)
+	r.write(".stk/config.json", `{"merge": {"union": {"driver": "cat %B >> %A"}}}`)
+	r.write("a.txt", "base\n")
+	r.commit("one")
+	r.run("checkout", "-b", "feat")
+	r.write("a.txt", "feat\n")
+	r.commit("feat edit")
+	r.run("checkout", "main")
+	r.write("a.txt", "main\n")
+	r.write(".stkattributes", "*.txt merge=union\n")
+	r.commit("main edit")
+	if out := r.run("ls-tree", "-r", "HEAD"); !strings.Contains(out, ".stkattributes") {
+		t.Fatalf(".stkattributes was not committed:\n%s", out)
+	}
+
+	// An uncommitted edit does not change how the merge is done.
+	r.write(".stkattributes", "")
+	r.run("merge", "feat")
+	if got := r.read("a.txt"); got != "main\nfeat\n" {
+		t.Errorf("a.txt = %q, want the driver's union of both sides", got)
+	}
+}
+
+func TestMergeCommitParents(t *testing.T) {
+	r := newTestRepo(t)
+	r.write("a.txt", "a\n")
+	r.commit("one")
+	r.run("checkout", "-b", "feat")
+	r.write("b.txt", "b\n")
+	r.commit("two")
+	feat := r.head()
+	r.run("checkout", "main")
+
+	// --no-ff records a fast-forward as a merge.
+	r.run("merge", "--no-ff", "feat")
+	noFF := r.head()
+	if got := strings.TrimSpace(r.run("rev-parse", "HEAD^2")); got != feat {
+		t.Errorf("HEAD^2 after --no-ff = %s, want %s", got, feat)
+	}
+	r.run("checkout", "feat")
+	r.write("c.txt", "c\n")
+	r.commit("three")
+	feat = r.head()
+	r.run("checkout", "main")
+	r.write("d.txt", "d\n")
+	r.commit("four")
+	ours := r.head()
+	if out, err := r.stk("merge", "--ff-only", "feat"); err == nil {
+		t.Fatalf("--ff-only merged diverged branches:\n%s", out)
+	}
+
+	r.run("merge", "feat")
+	for rev, want := range map[string]string{"HEAD^1": ours, "HEAD^2": feat, "HEAD~2": noFF} {
+		if got := strings.TrimSpace(r.run("rev-parse", rev)); got != want {
+			t.Errorf("%s = %s, want %s", rev, got, want)
+		}
+	}
+	if got := r.read("c.txt") + r.read("d.txt"); got != "c\nd\n" {
+		t.Errorf("merged files = %q, want both sides", got)
+	}
+
+	out := r.run("log")
+	if !strings.Contains(out, "Merge: "+shortHash(ours)+" "+shortHash(feat)) {
+		t.Errorf("log does not list both parents:\n%s", out)
+	}
+	// Every commit comes before its parents, first parents first.
+	var subjects []string
+	for _, line := range strings.Split(strings.TrimSpace(r.run("log", "--oneline")), "\n") {
+		_, subject, _ := strings.Cut(line, " ")
+		subjects = append(subjects, subject)
+	}
+	want := "Merge branch 'feat',four,Merge branch 'feat',three,two,one"
+	if got := strings.Join(subjects, ","); got != want {
+		t.Errorf("log --oneline = %s, want %s", got, want)
+	}
+}
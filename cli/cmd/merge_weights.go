@@ -0,0 +1,430 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sdk/pkg/safetensors"
+	"sdk/pkg/weights"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+var (
+	mergeStrategy string
+	mergeMethod   string
+	mergeAlpha    float64
+	mergeDensity  float64
+	mergeAlphaSet bool
+	mergeRules    []string
+)
+
+const mergeRulesFile = ".stkmerge"
+
+// weightsMerge holds the settings used to combine models that changed on
+// both sides: the default method from "-s weights" and the per-tensor rules
+// from --rule and .stkmerge.
+type weightsMerge struct {
+	method *weights.Method
+	opts   weights.Options
+	rules  []tensorRule
+}
+
+// tensorRule maps a tensor name pattern to ours, theirs, base or a weight
+// merge method.
+type tensorRule struct {
+	pattern string
+	action  string
+	source  string
+}
+
+type tensorDecision struct {
+	name   string
+	action string
+	source string
+}
+
+// weightsMergeFromFlags returns nil when neither the weights strategy nor any
+// tensor rule is in effect. File rules come from the .stkmerge committed in
+// oursTree, so every clone merges a branch the same way.
+func weightsMergeFromFlags(strategy, oursTree string) (*weightsMerge, error) {
+	w := &weightsMerge{opts: weights.Options{Density: mergeDensity}}
+
+	if strategy == "weights" {
+		method, err := weights.ParseMethod(mergeMethod)
+		if err != nil {
+			return nil, err
+		}
+		w.method = &method
+	}
+	if mergeDensity <= 0 || mergeDensity > 1 {
+		return nil, fmt.Errorf("--density must be in (0, 1], got %g", mergeDensity)
+	}
+
+	for _, value := range mergeRules {
+		rule, err := parseTensorRule(value, "--rule")
+		if err != nil {
+			return nil, err
+		}
+		w.rules = append(w.rules, rule)
+	}
+	fileRules, err := readTensorRules(oursTree)
+	if err != nil {
+		return nil, err
+	}
+	w.rules = append(w.rules, fileRules...)
+
+	if w.method == nil && len(w.rules) == 0 {
+		return nil, nil
+	}
+	return w, nil
+}
+
+// readTensorRules parses the .stkmerge of tree, which has one
+// "<pattern> <rule>" pair per line. Blank lines and lines starting with #
+// are ignored and a missing file yields no rules.
+func readTensorRules(tree string) ([]tensorRule, error) {
+	data, err := readTreeFile(tree, mergeRulesFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []tensorRule
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseTensorRule(line, fmt.Sprintf("%s:%d", mergeRulesFile, i+1))
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseTensorRule(value, source string) (tensorRule, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return tensorRule{}, fmt.Errorf("%s: expected \"<pattern> <rule>\", got %q", source, value)
+	}
+	pattern, action := fields[0], fields[1]
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return tensorRule{}, fmt.Errorf("%s: bad pattern %q: %w", source, pattern, err)
+	}
+	switch action {
+	case "ours", "theirs", "base":
+	default:
+		if _, err := weights.ParseMethod(action); err != nil {
+			return tensorRule{}, fmt.Errorf("%s: unknown rule %q (expected ours, theirs, base, avg, task-arith, ties or slerp)", source, action)
+		}
+	}
+	return tensorRule{pattern: pattern, action: action, source: source}, nil
+}
+
+// match returns the first rule whose pattern matches name.
+func (w *weightsMerge) match(name string) (tensorRule, bool) {
+	for _, rule := range w.rules {
+		if ok, _ := path.Match(rule.pattern, name); ok {
+			return rule, true
+		}
+	}
+	return tensorRule{}, false
+}
+
+// mergeModel assembles a model changed on both sides tensor by tensor and
+// stores the result as a new model manifest. Tensors that cannot be resolved
+// are reported and leave the model in conflict with our version.
+func (m *treeMerger) mergeModel(base, ours, theirs *Tree) (*Tree, error) {
+	oursManifest, oursFile, err := loadModelTensors(ours.Hash)
+	if err != nil {
+		return nil, err
+	}
+	_, theirsFile, err := loadModelTensors(theirs.Hash)
+	if err != nil {
+		return nil, err
+	}
+	var baseFile *safetensors.File
+	if base != nil && base.Type == "model" {
+		_, baseFile, err = loadModelTensors(base.Hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := safetensors.New()
+	result.Metadata = oursFile.Metadata
+	var problems []string
+	var decisions []tensorDecision
+
+	names := oursFile.Names()
+	for _, name := range theirsFile.Names() {
+		if _, ok := oursFile.Tensors[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range names {
+		merged, decision, problem := m.weights.mergeTensor(name, baseFile, oursFile, theirsFile)
+		if problem != "" {
+			problems = append(problems, fmt.Sprintf("%s: %s", name, problem))
+			continue
+		}
+		decisions = append(decisions, decision)
+		if merged != nil {
+			result.Set(name, *merged)
+		}
+	}
+
+	printTensorDecisions(ours.Path, decisions)
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		m.addConflict(ours, "tensor mismatch", problems...)
+		return ours, nil
+	}
+
+	architecture, problem, err := mergedArchitecture(oursManifest.Architecture, oursFile, result)
+	if err != nil {
+		return nil, err
+	}
+	if problem != "" {
+		m.addConflict(ours, "tensor set changed", problem)
+		return ours, nil
+	}
+
+	data, err := result.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	manifest := ModelManifest{
+		Architecture: architecture,
+		Chunks:       chunkAndStoreData(data, "."),
+		Metadata:     oursManifest.Metadata,
+	}
+	hash, err := createModelManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Merged %s (%d tensors)\n", ours.Path, len(result.Tensors))
+	return &Tree{Type: "model", Path: ours.Path, Hash: hash}, nil
+}
+
+// mergedArchitecture returns the architecture blob for the merged tensors
+// of a model. Ours is kept while the merge leaves its tensor names as they
+// were. When rules added or dropped tensors, an architecture listing them
+// (the tensor_keys of PyTorch models) is rewritten with the merged names and
+// a safetensors architecture, which lists none, is kept. Other formats
+// cannot be regenerated here, so a problem describing the change is
+// returned instead. The metadata of the built-in formats does not name
+// tensors and is always kept from ours.
+func mergedArchitecture(hash string, oursFile, result *safetensors.File) (string, string, error) {
+	var added, removed []string
+	for _, name := range result.Names() {
+		if _, ok := oursFile.Tensors[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	for _, name := range oursFile.Names() {
+		if _, ok := result.Tensors[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return hash, "", nil
+	}
+
+	data, err := readBlob(hash)
+	if err != nil {
+		return "", "", err
+	}
+	var architecture map[string]interface{}
+	if err := json.Unmarshal(data, &architecture); err != nil {
+		return "", "", fmt.Errorf("failed to parse architecture %s: %w", hash, err)
+	}
+
+	if _, ok := architecture["tensor_keys"]; ok {
+		architecture["tensor_keys"] = result.Names()
+		data, err := json.MarshalIndent(architecture, "", "  ")
+		if err != nil {
+			return "", "", err
+		}
+		blob, err := createBlob(data)
+		return blob, "", err
+	}
+	if architecture["type"] == "safetensors" {
+		return hash, "", nil
+	}
+
+	var changes []string
+	if len(added) > 0 {
+		changes = append(changes, "added "+strings.Join(added, ", "))
+	}
+	if len(removed) > 0 {
+		changes = append(changes, "removed "+strings.Join(removed, ", "))
+	}
+	return "", fmt.Sprintf("%s; the %v architecture cannot be regenerated", strings.Join(changes, ", "), architecture["type"]), nil
+}
+
+// mergeTensor resolves one tensor from the three sides. A nil tensor with no
+// problem means the tensor is dropped from the result. A non-empty problem
+// explains why the tensor could not be resolved.
+func (w *weightsMerge) mergeTensor(name string, baseFile, oursFile, theirsFile *safetensors.File) (*safetensors.Tensor, tensorDecision, string) {
+	decision := tensorDecision{name: name, source: "default"}
+	rule, matched := w.match(name)
+	if matched {
+		decision.source = fmt.Sprintf("%s (%s)", rule.pattern, rule.source)
+	}
+
+	o, hasOurs := oursFile.Tensors[name]
+	t, hasTheirs := theirsFile.Tensors[name]
+	var b *safetensors.Tensor
+	if baseFile != nil {
+		if bt, ok := baseFile.Tensors[name]; ok {
+			b = &bt
+		}
+	}
+
+	switch rule.action {
+	case "ours":
+		decision.action = "ours"
+		if !hasOurs {
+			return nil, decision, ""
+		}
+		return &o, decision, ""
+	case "theirs":
+		decision.action = "theirs"
+		if !hasTheirs {
+			return nil, decision, ""
+		}
+		return &t, decision, ""
+	case "base":
+		decision.action = "base"
+		if b == nil {
+			return nil, decision, "missing in merge base"
+		}
+		return b, decision, ""
+	}
+
+	if !hasOurs {
+		return nil, decision, "missing in ours"
+	}
+	if !hasTheirs {
+		return nil, decision, "missing in theirs"
+	}
+	if problem := compareTensors(o, t); problem != "" {
+		return nil, decision, problem
+	}
+	if b != nil && compareTensors(o, *b) != "" {
+		b = nil
+	}
+
+	method := w.method
+	if matched {
+		ruleMethod := weights.Method(rule.action)
+		method = &ruleMethod
+	}
+
+	if method == nil || !safetensors.IsFloat(o.DType) {
+		// Without a method, and for integer and boolean tensors (step
+		// counters, masks) that cannot be interpolated, take whichever
+		// side changed the tensor.
+		switch {
+		case bytes.Equal(o.Data, t.Data):
+			decision.action = "unchanged"
+			return &o, decision, ""
+		case b != nil && bytes.Equal(b.Data, o.Data):
+			decision.action = "theirs"
+			return &t, decision, ""
+		case b != nil && bytes.Equal(b.Data, t.Data):
+			decision.action = "ours"
+			return &o, decision, ""
+		case method == nil:
+			return nil, decision, "changed on both sides and no rule matched"
+		}
+		return nil, decision, fmt.Sprintf("changed on both sides and dtype %s cannot be merged", o.DType)
+	}
+
+	decision.action = string(*method)
+	if method.NeedsBase() && b == nil {
+		return nil, decision, fmt.Sprintf("missing or mismatched in merge base, required by %s", *method)
+	}
+
+	opts := w.opts
+	opts.Alpha = method.DefaultAlpha()
+	if mergeAlphaSet {
+		opts.Alpha = mergeAlpha
+	}
+
+	oursValues, err := o.Floats()
+	if err != nil {
+		return nil, decision, err.Error()
+	}
+	theirsValues, err := t.Floats()
+	if err != nil {
+		return nil, decision, err.Error()
+	}
+	var baseValues []float64
+	if b != nil {
+		baseValues, err = b.Floats()
+		if err != nil {
+			return nil, decision, err.Error()
+		}
+	}
+
+	values, err := weights.Merge(*method, baseValues, oursValues, theirsValues, opts)
+	if err != nil {
+		return nil, decision, err.Error()
+	}
+	merged, err := safetensors.FromFloats(o.DType, o.Shape, values)
+	if err != nil {
+		return nil, decision, err.Error()
+	}
+	return &merged, decision, ""
+}
+
+func printTensorDecisions(modelPath string, decisions []tensorDecision) {
+	if len(decisions) == 0 {
+		return
+	}
+
+	fmt.Println(modelPath)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "  TENSOR\tRULE\tDECIDED BY")
+	for _, d := range decisions {
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", d.name, d.action, d.source)
+	}
+	w.Flush()
+}
+
+func compareTensors(a, b safetensors.Tensor) string {
+	if a.DType != b.DType {
+		return fmt.Sprintf("dtype %s vs %s", a.DType, b.DType)
+	}
+	if fmt.Sprint(a.Shape) != fmt.Sprint(b.Shape) {
+		return fmt.Sprintf("shape %v vs %v", a.Shape, b.Shape)
+	}
+	return ""
+}
+
+func loadModelTensors(hash string) (ModelManifest, *safetensors.File, error) {
+	manifest, err := readModelManifest(hash)
+	if err != nil {
+		return manifest, nil, err
+	}
+	data, err := readChunks(manifest.Chunks)
+	if err != nil {
+		return manifest, nil, err
+	}
+	file, err := safetensors.Parse(data)
+	if err != nil {
+		return manifest, nil, fmt.Errorf("model %s: %w", hash, err)
+	}
+	return manifest, file, nil
+}
@@ -0,0 +1,488 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sdk/pkg/compressor"
+	"sdk/pkg/diff"
+	"sdk/pkg/hasher"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// objectHashLen is the length of a hex-encoded blake3 object hash.
+const objectHashLen = 64
+
+// isObjectHash reports whether hash is a full hex object hash.
+func isObjectHash(hash string) bool {
+	return len(hash) == objectHashLen && isHex(hash)
+}
+
+func isHex(s string) bool {
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Directories of the common .stk holding each kind of object, written with
+// slashes as they also name the objects on a remote.
+const (
+	commitsDir = "commits"
+	treesDir   = "objects/trees"
+	blobsDir   = "objects/blobs"
+	modelsDir  = "models"
+)
+
+// objectKey names an object on a remote: its path below .stk with slashes.
+func objectKey(dir, hash string) string {
+	return dir + "/" + hash[:2] + "/" + hash[2:]
+}
+
+// objectPath returns where the object with the given hash is stored below
+// .stk/<dir>, sharded by the first two digits of the hash. Anything but a
+// full hash is rejected instead of being sliced.
+func objectPath(dir, hash string) (string, error) {
+	if !isObjectHash(hash) {
+		return "", fmt.Errorf("invalid object hash %q", hash)
+	}
+	return commonPath(dir, hash[:2], hash[2:]), nil
+}
+
+func readBlob(hash string) ([]byte, error) {
+	path, err := objectPath(filepath.Join("objects", "blobs"), hash)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := compressor.GetDecompressFile(path)
+	if isMissingObject(err) {
+		// A partial clone may have left the blob with its remote.
+		if partial, _ := partialClone(); partial != nil {
+			return readPromisedBlob(hash)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", hash, err)
+	}
+	return data, nil
+}
+
+func createBlob(data []byte) (string, error) {
+	digest := hasher.HashData(data)
+	blobPath := commonPath("objects", "blobs", digest[:2], digest[2:])
+	if err := compressor.CompressData(data, blobPath); err != nil {
+		return "", err
+	}
+	return digest, nil
+}
+
+func readModelManifest(hash string) (ModelManifest, error) {
+	var manifest ModelManifest
+	path, err := objectPath("models", hash)
+	if err != nil {
+		return manifest, err
+	}
+
+	data, err := compressor.GetDecompressFile(path)
+	if err != nil {
+		return manifest, fmt.Errorf("failed to read model manifest %s: %w", hash, err)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("failed to parse model manifest %s: %w", hash, err)
+	}
+	return manifest, nil
+}
+
+// createModelManifest stores manifest alongside the manifests written by
+// commit and returns its hash.
+func createModelManifest(manifest ModelManifest) (string, error) {
+	jsonManifest, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	digest := hasher.HashData(jsonManifest)
+	if err := compressor.CompressData(jsonManifest, commonPath("models", digest[:2], digest[2:])); err != nil {
+		return "", err
+	}
+	return digest, nil
+}
+
+// readChunks reassembles the safetensors bytes of a model from its chunks.
+func readChunks(chunks []string) ([]byte, error) {
+	var data []byte
+	for _, chunk := range chunks {
+		part, err := readBlob(chunk)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, part...)
+	}
+	return data, nil
+}
+
+// readTreeFile returns the content of the blob at path in tree, or nil when
+// the tree has no such blob.
+func readTreeFile(tree, path string) ([]byte, error) {
+	entries, err := flattenTree(tree)
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := entries[path]
+	if !ok || entry.Type != "blob" {
+		return nil, nil
+	}
+	return readBlob(entry.Hash)
+}
+
+// flattenTree returns every blob and model entry below treeHash keyed by its
+// path. An empty hash yields an empty map.
+func flattenTree(treeHash string) (map[string]Tree, error) {
+	flat := make(map[string]Tree)
+	if treeHash == "" {
+		return flat, nil
+	}
+
+	var walk func(hash string) error
+	walk = func(hash string) error {
+		entries, err := readTree(hash)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.Type == "tree" {
+				if err := walk(entry.Hash); err != nil {
+					return err
+				}
+				continue
+			}
+			flat[entry.Path] = entry
+		}
+		return nil
+	}
+
+	return flat, walk(treeHash)
+}
+
+// lookupPath finds the entry for path below treeHash, descending through
+// subtrees. An empty path names the tree itself.
+func lookupPath(treeHash, path string) (Tree, error) {
+	path = strings.Trim(filepath.ToSlash(filepath.Clean(path)), "/")
+	if path == "" || path == "." {
+		return Tree{Type: "tree", Hash: treeHash}, nil
+	}
+
+	hash := treeHash
+	for {
+		entries, err := readTree(hash)
+		if err != nil {
+			return Tree{}, err
+		}
+
+		next := ""
+		for _, entry := range entries {
+			entryPath := strings.TrimPrefix(entry.Path, "/")
+			if entryPath == path {
+				return entry, nil
+			}
+			if entry.Type == "tree" && strings.HasPrefix(path, entryPath+"/") {
+				next = entry.Hash
+				break
+			}
+		}
+		if next == "" {
+			return Tree{}, fmt.Errorf("path '%s' does not exist", path)
+		}
+		hash = next
+	}
+}
+
+// commitTree returns the tree of commit, or an empty string for an unborn
+// branch.
+func commitTree(commit string) (string, error) {
+	if commit == "" {
+		return "", nil
+	}
+	commitData, err := readCommit(commit)
+	if err != nil {
+		return "", err
+	}
+	return commitData.Tree, nil
+}
+
+// writeIndexFromTree replaces .stk/index.json and .stk/model_index.json with
+// the contents of treeHash. Paths outside the sparse checkout patterns that
+// are not on disk are marked skip-worktree.
+func writeIndexFromTree(treeHash string) error {
+	flat, err := flattenTree(treeHash)
+	if err != nil {
+		return err
+	}
+	sparse, err := readSparseCheckout()
+	if err != nil {
+		return err
+	}
+
+	index := make(NestedIndex)
+	modelIndex := make(NestedIndex)
+	for path, entry := range flat {
+		skip := !sparse.includes(path) && !fileExists(path)
+		switch entry.Type {
+		case "blob":
+			setIndexEntry(index, FileData{Hash: entry.Hash, Path: path, SkipWorktree: skip})
+		case "model":
+			// Models record the hash of the file on disk so local edits
+			// can be detected without extracting the model again.
+			fileHash := ""
+			if !skip && fileExists(path) {
+				fileHash = hasher.HashFile(path)
+			}
+			setIndexEntry(index, FileData{Hash: fileHash, Path: path, SkipWorktree: skip})
+			manifestPath, err := objectPath("models", entry.Hash)
+			if err != nil {
+				return err
+			}
+			manifest, err := compressor.GetDecompressFile(manifestPath)
+			if err != nil {
+				return fmt.Errorf("failed to read model manifest %s: %w", entry.Hash, err)
+			}
+			var raw interface{}
+			if err := json.Unmarshal(manifest, &raw); err != nil {
+				return fmt.Errorf("failed to parse model manifest %s: %w", entry.Hash, err)
+			}
+			modelIndex[path] = raw
+		}
+	}
+
+	return writeIndexFiles(index, modelIndex)
+}
+
+// readIndexEntries returns the entries of .stk/index.json keyed by path.
+// Model entries carry the hash of the model file, or none when unknown.
+func readIndexEntries() (map[string]FileData, error) {
+	entries := make(map[string]FileData)
+	data, err := os.ReadFile(stkPath(indexFile))
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return entries, nil
+	}
+
+	var index map[string]interface{}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse index: %w", err)
+	}
+
+	var walk func(node map[string]interface{})
+	walk = func(node map[string]interface{}) {
+		for _, val := range node {
+			m, ok := val.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if _, hasHash := m["hash"]; !hasHash {
+				walk(m)
+				continue
+			}
+			hash, _ := m["hash"].(string)
+			path, _ := m["path"].(string)
+			skip, _ := m["skip_worktree"].(bool)
+			entries[path] = FileData{Hash: hash, Path: path, SkipWorktree: skip}
+		}
+	}
+	walk(index)
+	return entries, nil
+}
+
+// stagedModels returns the manifest hash of every model in
+// .stk/model_index.json, as commit would store it.
+func stagedModels() (map[string]string, error) {
+	staged := make(map[string]string)
+	data, err := os.ReadFile(stkPath(modelIndexFile))
+	if os.IsNotExist(err) {
+		return staged, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return staged, nil
+	}
+
+	var modelIndex map[string]interface{}
+	if err := json.Unmarshal(data, &modelIndex); err != nil {
+		return nil, fmt.Errorf("failed to parse model index: %w", err)
+	}
+	for path, manifest := range modelIndex {
+		jsonManifest, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		staged[path] = hasher.HashData(jsonManifest)
+	}
+	return staged, nil
+}
+
+// readIndexFiles loads .stk/index.json and .stk/model_index.json. Missing
+// files yield empty indexes.
+func readIndexFiles() (NestedIndex, NestedIndex, error) {
+	index := make(NestedIndex)
+	modelIndex := make(NestedIndex)
+	for file, target := range map[string]*NestedIndex{
+		stkPath(indexFile):      &index,
+		stkPath(modelIndexFile): &modelIndex,
+	} {
+		data, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(data, target); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+	}
+	return index, modelIndex, nil
+}
+
+func writeIndexFiles(index, modelIndex NestedIndex) error {
+	jsonIndex, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(stkPath(indexFile), jsonIndex, 0644); err != nil {
+		return err
+	}
+
+	jsonModelIndex, err := json.MarshalIndent(modelIndex, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(stkPath(modelIndexFile), jsonModelIndex, 0644)
+}
+
+// removeEmptyParents deletes the now empty directories above path, stopping
+// at the repository root.
+func removeEmptyParents(path string) {
+	dir := filepath.Dir(path)
+	for dir != "." && dir != "/" && !strings.HasPrefix(dir, "..") {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// printDiffStat summarises the files and models that differ between two
+// trees, one line per path followed by a total.
+func printDiffStat(fromTree, toTree string) error {
+	from, err := flattenTree(fromTree)
+	if err != nil {
+		return err
+	}
+	to, err := flattenTree(toTree)
+	if err != nil {
+		return err
+	}
+
+	pathSet := make(map[string]bool)
+	for path, entry := range from {
+		if to[path] != entry {
+			pathSet[path] = true
+		}
+	}
+	for path, entry := range to {
+		if from[path] != entry {
+			pathSet[path] = true
+		}
+	}
+	paths := make([]string, 0, len(pathSet))
+	for path := range pathSet {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	files, models, insertions, deletions := 0, 0, 0, 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 1, ' ', 0)
+	for _, path := range paths {
+		before, hadBefore := from[path]
+		after, hasAfter := to[path]
+
+		if before.Type == "model" || after.Type == "model" {
+			models++
+			fmt.Fprintf(w, " %s\t| %s\n", path, modelChangeSummary(before, hadBefore, after, hasAfter))
+			continue
+		}
+
+		files++
+		var oldData, newData []byte
+		if hadBefore {
+			if oldData, err = readBlob(before.Hash); err != nil {
+				return err
+			}
+		}
+		if hasAfter {
+			if newData, err = readBlob(after.Hash); err != nil {
+				return err
+			}
+		}
+		if diff.IsBinary(oldData) || diff.IsBinary(newData) {
+			fmt.Fprintf(w, " %s\t| Bin %d -> %d bytes\n", path, len(oldData), len(newData))
+			continue
+		}
+		added, removed := diff.LineStat(oldData, newData)
+		insertions += added
+		deletions += removed
+		fmt.Fprintf(w, " %s\t| %d %s%s\n", path, added+removed, strings.Repeat("+", min(added, 40)), strings.Repeat("-", min(removed, 40)))
+	}
+	w.Flush()
+
+	fmt.Printf(" %d files changed, %d insertions(+), %d deletions(-), %d models changed\n", files, insertions, deletions, models)
+	return nil
+}
+
+func modelChangeSummary(before Tree, hadBefore bool, after Tree, hasAfter bool) string {
+	switch {
+	case !hadBefore:
+		return "model added"
+	case !hasAfter:
+		return "model deleted"
+	case before.Type != after.Type:
+		return "type changed"
+	}
+
+	oldManifest, err := readModelManifest(before.Hash)
+	if err != nil {
+		return "model changed"
+	}
+	newManifest, err := readModelManifest(after.Hash)
+	if err != nil {
+		return "model changed"
+	}
+
+	known := make(map[string]bool, len(oldManifest.Chunks))
+	for _, chunk := range oldManifest.Chunks {
+		known[chunk] = true
+	}
+	changed := 0
+	for _, chunk := range newManifest.Chunks {
+		if !known[chunk] {
+			changed++
+		}
+	}
+	return fmt.Sprintf("model, %d of %d chunks changed", changed, len(newManifest.Chunks))
+}
@@ -0,0 +1,298 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sdk/pkg/compressor"
+	"sdk/pkg/hasher"
+	"sdk/pkg/pack"
+	"sdk/pkg/transfer"
+	"slices"
+	"strings"
+)
+
+// objectSource reads decompressed objects by directory and hash, from the
+// local repository or from one that stk serve hosts.
+type objectSource interface {
+	readObject(dir, hash string) ([]byte, error)
+}
+
+// localObjects reads the objects of the current repository.
+type localObjects struct{}
+
+func (localObjects) readObject(dir, hash string) ([]byte, error) {
+	path, err := objectPath(dir, hash)
+	if err != nil {
+		return nil, err
+	}
+	return compressor.GetDecompressFile(path)
+}
+
+func sourceCommit(src objectSource, hash string) (Commit, error) {
+	var commit Commit
+	data, err := src.readObject(commitsDir, hash)
+	if err != nil {
+		return commit, fmt.Errorf("failed to read commit %s: %w", hash, err)
+	}
+	if err := json.Unmarshal(data, &commit); err != nil {
+		return commit, fmt.Errorf("failed to parse commit %s: %w", hash, err)
+	}
+	return commit, nil
+}
+
+func sourceTree(src objectSource, hash string) ([]Tree, error) {
+	var entries []Tree
+	data, err := src.readObject(treesDir, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tree %s: %w", hash, err)
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse tree %s: %w", hash, err)
+	}
+	return entries, nil
+}
+
+func sourceManifest(src objectSource, hash string) (ModelManifest, error) {
+	var manifest ModelManifest
+	data, err := src.readObject(modelsDir, hash)
+	if err != nil {
+		return manifest, fmt.Errorf("failed to read model manifest %s: %w", hash, err)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("failed to parse model manifest %s: %w", hash, err)
+	}
+	return manifest, nil
+}
+
+// objectKeysFrom lists the keys of commits and of every tree, blob, model
+// manifest and chunk they reference, leaving out the keys in exclude and
+// anything below an excluded tree or manifest. Under a partial clone
+// filter (see parseFilter) the chunks of a model stop once modelLimit
+// bytes are listed. Commits are given children first, as a walk of the
+// history finds them; the keys come out in the order objects are stored:
+// data, then manifests, then trees with subtrees first, then commits with
+// parents first.
+func objectKeysFrom(src objectSource, commits []string, exclude map[string]bool, modelLimit int64) ([]string, error) {
+	var data, trees, models, commitKeys []string
+	seen := make(map[string]bool)
+	add := func(list *[]string, key string) bool {
+		if seen[key] || exclude[key] {
+			return false
+		}
+		seen[key] = true
+		*list = append(*list, key)
+		return true
+	}
+
+	addChunks := func(chunks []string) error {
+		var listed int64
+		for _, chunk := range chunks {
+			if modelLimit != noModelFilter && listed >= modelLimit {
+				return nil
+			}
+			if modelLimit != noModelFilter {
+				content, err := src.readObject(blobsDir, chunk)
+				if err != nil {
+					return err
+				}
+				listed += int64(len(content))
+			}
+			add(&data, objectKey(blobsDir, chunk))
+		}
+		return nil
+	}
+
+	var walk func(hash string) error
+	walk = func(hash string) error {
+		if !add(&trees, objectKey(treesDir, hash)) {
+			return nil
+		}
+		entries, err := sourceTree(src, hash)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			switch entry.Type {
+			case "tree":
+				if err := walk(entry.Hash); err != nil {
+					return err
+				}
+			case "model":
+				if !add(&models, objectKey(modelsDir, entry.Hash)) {
+					continue
+				}
+				manifest, err := sourceManifest(src, entry.Hash)
+				if err != nil {
+					return err
+				}
+				add(&data, objectKey(blobsDir, manifest.Architecture))
+				add(&data, objectKey(blobsDir, manifest.Metadata))
+				if err := addChunks(manifest.Chunks); err != nil {
+					return err
+				}
+			default:
+				add(&data, objectKey(blobsDir, entry.Hash))
+			}
+		}
+		return nil
+	}
+
+	for _, commit := range commits {
+		c, err := sourceCommit(src, commit)
+		if err != nil {
+			return nil, err
+		}
+		if err := walk(c.Tree); err != nil {
+			return nil, err
+		}
+		add(&commitKeys, objectKey(commitsDir, commit))
+	}
+
+	// Trees were added before their subtrees and commits before their
+	// parents.
+	slices.Reverse(trees)
+	slices.Reverse(commitKeys)
+	keys := append(data, models...)
+	keys = append(keys, trees...)
+	return append(keys, commitKeys...), nil
+}
+
+// treeObjects returns the keys of everything the trees of commits
+// reference, which a receiver holding those commits already has.
+func treeObjects(src objectSource, commits []string) (map[string]bool, error) {
+	keys, err := objectKeysFrom(src, commits, nil, noModelFilter)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		set[key] = true
+	}
+	return set, nil
+}
+
+// parseObjectKey splits a key such as "objects/trees/ab/cdef…" into its
+// object directory and hash.
+func parseObjectKey(key string) (string, string, bool) {
+	i := strings.LastIndex(key, "/")
+	if i < 0 {
+		return "", "", false
+	}
+	j := strings.LastIndex(key[:i], "/")
+	if j < 0 {
+		return "", "", false
+	}
+	dir, hash := key[:j], key[j+1:i]+key[i+1:]
+	switch dir {
+	case commitsDir, treesDir, blobsDir, modelsDir:
+	default:
+		return "", "", false
+	}
+	if !isObjectHash(hash) || objectKey(dir, hash) != key {
+		return "", "", false
+	}
+	return dir, hash, true
+}
+
+// verifyObject checks that raw, an object as stored, decompresses to
+// content matching the hash in key.
+func verifyObject(key string, raw []byte) (string, string, error) {
+	dir, hash, ok := parseObjectKey(key)
+	if !ok {
+		return "", "", fmt.Errorf("invalid object key %q", key)
+	}
+	data, err := compressor.DecompressData(raw)
+	if err != nil {
+		return "", "", fmt.Errorf("object %s is corrupt: %w", key, err)
+	}
+	if hasher.HashData(data) != hash {
+		return "", "", fmt.Errorf("object %s does not match its hash", key)
+	}
+	return dir, hash, nil
+}
+
+// writeLocalPack writes the local objects under keys as a pack.
+func writeLocalPack(w io.Writer, keys []string, progress *transfer.Progress) error {
+	pw, err := pack.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		raw, err := readStoredObject(key)
+		if err != nil {
+			return err
+		}
+		if err := pw.Add(key, raw); err != nil {
+			return err
+		}
+		progress.Add(int64(len(raw)))
+		progress.Done(1)
+	}
+	return pw.Close()
+}
+
+// readStoredObject returns the local object under key as stored. In a
+// partial clone a chunk that was left out comes from the chunk cache and
+// is downloaded first if need be, so a pack is always complete.
+func readStoredObject(key string) ([]byte, error) {
+	path, err := storedObjectPath(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// storedObjectPath returns the file that holds the object under key: the
+// local object, or in a partial clone the cached copy of a chunk that was
+// left out, downloaded first if need be.
+func storedObjectPath(key string) (string, error) {
+	local := commonPath(filepath.FromSlash(key))
+	_, err := os.Stat(local)
+	if !isMissingObject(err) {
+		return local, err
+	}
+	dir, hash, ok := parseObjectKey(key)
+	if partial, _ := partialClone(); !ok || dir != blobsDir || partial == nil {
+		return "", err
+	}
+	if _, err := readPromisedBlob(hash); err != nil {
+		return "", err
+	}
+	return cachedBlobPath(hash)
+}
+
+// storePack verifies and stores every object of a pack in the local
+// repository, in the order the pack gives them, which puts everything an
+// object references before it. It returns how many objects were new.
+func storePack(r io.Reader, progress *transfer.Progress) (int, error) {
+	pr, err := pack.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	stored := 0
+	for {
+		key, raw, err := pr.Next()
+		if err == io.EOF {
+			return stored, nil
+		}
+		if err != nil {
+			return stored, err
+		}
+		dir, hash, err := verifyObject(key, raw)
+		if err != nil {
+			return stored, err
+		}
+		progress.Add(int64(len(raw)))
+		progress.Done(1)
+		if objectExists(dir, hash) {
+			continue
+		}
+		if err := storeObject(dir, hash, raw); err != nil {
+			return stored, err
+		}
+		stored++
+	}
+}
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+)
+
+var packRefsCmd = &cobra.Command{
+	Use:   "pack-refs",
+	Short: "Pack loose refs into a single file",
+	Long: `Moves every branch, tag and remote-tracking ref from its own file under
+.stk/refs into .stk/packed-refs. Repositories with many refs stay fast and
+small; refs updated later are written as loose files again.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		count, err := refStore().Pack()
+		if err != nil {
+			log.Fatal("Error packing refs: ", err)
+		}
+		fmt.Printf("Packed %d refs\n", count)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(packRefsCmd)
+}
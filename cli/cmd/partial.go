@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sdk/pkg/compressor"
+	"sdk/pkg/hasher"
+	"sdk/pkg/remote"
+	"sdk/pkg/transfer"
+	"strconv"
+	"strings"
+)
+
+// A partial clone fetches commits, trees, model manifests and the
+// architecture and metadata of every model, but leaves out weight chunks
+// selected by a filter. The remote it was cloned from promises to keep
+// them; they are downloaded when a checkout or merge first needs them and
+// kept in a chunk cache outside the repository, shared by every clone.
+
+// PartialConfig records the filter of a partial clone and the remote that
+// holds what it left out.
+type PartialConfig struct {
+	Remote string `json:"remote"`
+	Filter string `json:"filter"`
+}
+
+// noModelFilter means every chunk is fetched.
+const noModelFilter = -1
+
+// parseFilter turns a filter spec into how many bytes of each model's
+// weights are fetched eagerly: "models:none" fetches no chunks and
+// "models:limit=<size>" fetches models up to size completely, given in
+// bytes or with a k, m or g suffix. Larger models stop after size bytes.
+func parseFilter(spec string) (int64, error) {
+	if spec == "" {
+		return noModelFilter, nil
+	}
+	if spec == "models:none" {
+		return 0, nil
+	}
+	size, ok := strings.CutPrefix(spec, "models:limit=")
+	if !ok {
+		return 0, fmt.Errorf("unknown filter '%s' (expected models:none or models:limit=<size>)", spec)
+	}
+
+	multiplier := int64(1)
+	if n := len(size); n > 0 {
+		switch size[n-1] {
+		case 'k', 'K':
+			multiplier = 1 << 10
+		case 'm', 'M':
+			multiplier = 1 << 20
+		case 'g', 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier > 1 {
+			size = size[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size in filter '%s'", spec)
+	}
+	return n * multiplier, nil
+}
+
+// partialClone returns the promisor settings when the repository is a
+// partial clone.
+func partialClone() (*PartialConfig, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.Partial, nil
+}
+
+// chunkCacheDir is where chunks downloaded on demand are kept:
+// $STK_CACHE_DIR, or stk in the user's cache directory.
+func chunkCacheDir() (string, error) {
+	if dir := os.Getenv("STK_CACHE_DIR"); dir != "" {
+		return dir, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "stk"), nil
+}
+
+func cachedBlobPath(hash string) (string, error) {
+	dir, err := chunkCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.FromSlash(objectKey(blobsDir, hash))), nil
+}
+
+// promisor is the remote of a partial clone, opened on first use.
+var promisor remote.Remote
+
+// openPromisor opens the remote of a partial clone once. Callers that fetch
+// in parallel open it before starting.
+func openPromisor() (remote.Remote, error) {
+	if promisor != nil {
+		return promisor, nil
+	}
+	partial, err := partialClone()
+	if err != nil {
+		return nil, err
+	}
+	if partial == nil {
+		return nil, errors.New("not a partial clone")
+	}
+	promisor = openRemote(partial.Remote, false)
+	return promisor, nil
+}
+
+// readPromisedBlob returns a blob left out of a partial clone, from the
+// chunk cache or else from the promisor remote.
+func readPromisedBlob(hash string) ([]byte, error) {
+	path, err := cachedBlobPath(hash)
+	if err != nil {
+		return nil, err
+	}
+	if data, err := compressor.GetDecompressFile(path); err == nil && hasher.HashData(data) == hash {
+		return data, nil
+	}
+
+	r, err := openPromisor()
+	if err != nil {
+		return nil, err
+	}
+	f := newFetcher(r)
+	raw, data, err := f.get(blobsDir, hash)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return data, nil
+}
+
+// prefetchChunks downloads the chunks of a model that are neither in the
+// repository nor in the cache, reporting how many it fetches.
+func prefetchChunks(name string, chunks []string) error {
+	partial, err := partialClone()
+	if err != nil || partial == nil {
+		return err
+	}
+
+	var missing []string
+	for _, chunk := range chunks {
+		if objectExists(blobsDir, chunk) {
+			continue
+		}
+		if path, err := cachedBlobPath(chunk); err == nil && fileExists(path) {
+			continue
+		}
+		missing = append(missing, chunk)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	fmt.Printf("Fetching %d of %d chunks of %s from %s\n", len(missing), len(chunks), name, partial.Remote)
+	if _, err := openPromisor(); err != nil {
+		return err
+	}
+	jobs := make([]transfer.Job, len(missing))
+	for i, chunk := range missing {
+		jobs[i] = transfer.Job{Key: chunk}
+	}
+	progress := transfer.NewProgress("Receiving chunks", len(jobs), 0)
+	defer progress.Finish()
+	return transfer.Run(jobs, transferOptions(progress), func(job transfer.Job, count func(int64)) error {
+		data, err := readPromisedBlob(job.Key)
+		if err != nil {
+			return transferError(err)
+		}
+		count(int64(len(data)))
+		return nil
+	})
+}
+
+// isMissingObject reports whether err means an object file is absent.
+func isMissingObject(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// storedChunks counts the blobs below dir, an objects directory or a cache.
+func storedChunks(t *testing.T, dir string) int {
+	t.Helper()
+	n := 0
+	err := filepath.WalkDir(filepath.Join(dir, "objects", "blobs"), func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestPartialCloneFetchesChunksOnDemand(t *testing.T) {
+	url := t.TempDir()
+	a := newTestRepo(t)
+	a.write("a.txt", "a\n")
+	a.writeModel("models/m.safetensors", 1, "w")
+	a.commit("one")
+	a.run("remote", "add", "origin", url)
+	a.run("push", "-u")
+
+	if out, err := a.stkIn(t.TempDir(), "clone", "--filter=models:some", url, "x"); err == nil || !strings.Contains(out, "unknown filter") {
+		t.Errorf("clone with a bad filter (%v):\n%s", err, out)
+	}
+
+	b := cloneTestRepo(t, url, "--filter=models:none")
+	if got := b.modelValue("models/m.safetensors", "w"); got != 1 {
+		t.Errorf("model in the partial clone has w = %g", got)
+	}
+	// The repository holds the text file and the model's architecture and
+	// metadata; the chunk went to the cache.
+	local := storedChunks(t, filepath.Join(b.dir, ".stk"))
+	cached := storedChunks(t, filepath.Join(b.dir, ".cache"))
+	if local != 3 || cached == 0 {
+		t.Errorf("partial clone stored %d blobs and cached %d chunks", local, cached)
+	}
+
+	a.writeModel("models/m.safetensors", 2, "w", "b")
+	a.commit("two")
+	a.run("push")
+	out := b.run("pull")
+	if !strings.Contains(out, "Fetching") || !strings.Contains(out, "chunks of models/m.safetensors") {
+		t.Errorf("pull did not fetch the new chunks on demand:\n%s", out)
+	}
+	if got := b.modelValue("models/m.safetensors", "b"); got != 2 {
+		t.Errorf("pulled model has b = %g", got)
+	}
+	if got := storedChunks(t, filepath.Join(b.dir, ".stk")); got != local {
+		t.Errorf("the pull stored %d chunks in the repository", got-local)
+	}
+	if got := storedChunks(t, filepath.Join(b.dir, ".cache")); got <= cached {
+		t.Errorf("cache holds %d chunks after the pull, had %d", got, cached)
+	}
+
+	// Checking out the first commit again needs nothing new.
+	if out := b.run("checkout", "HEAD~1"); strings.Contains(out, "Fetching") {
+		t.Errorf("checkout fetched cached chunks again:\n%s", out)
+	}
+
+	// A full clone fetches every chunk up front.
+	c := cloneTestRepo(t, url, "--filter=models:limit=1g")
+	if got := storedChunks(t, filepath.Join(c.dir, ".cache")); got != 0 {
+		t.Errorf("a clone within the size limit cached %d chunks", got)
+	}
+}
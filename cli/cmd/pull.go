@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"errors"
+	"log"
+	"sdk/pkg/refs"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var pullCmd = &cobra.Command{
+	Use:   "pull [<remote>]",
+	Short: "Fetch from a remote and integrate the current branch's upstream",
+	Long: `Runs stk fetch, then merges the upstream of the current branch into it,
+fast-forwarding when the branch has no commits of its own. The upstream is
+the one configured for the branch, or <remote>/<branch> when none is or
+when a different remote is named.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runPull(args)
+	},
+}
+
+func runPull(args []string) {
+	if _, ok := readMergeState(); ok {
+		log.Fatal("A merge is in progress. Use 'stk merge --continue' or 'stk merge --abort' first.")
+	}
+	branch := currentBranch()
+	if branch == "" {
+		log.Fatal("You are not currently on a branch; check out a branch to pull into.")
+	}
+
+	name := remoteArg(args)
+	if _, err := fetchRemote(name, openRemote(name, false)); err != nil {
+		log.Fatal(err)
+	}
+
+	upstream, err := upstreamRef(refs.Short(branch))
+	if err != nil || !strings.HasPrefix(upstream, refs.RemotesPrefix+name+"/") {
+		upstream = trackingRef(name, branch)
+	}
+	if _, err := refStore().Read(upstream); err != nil {
+		if errors.Is(err, refs.ErrNotFound) {
+			log.Fatalf("There is no tracking information for the current branch: '%s' does not exist on the remote.", refs.Short(branch))
+		}
+		log.Fatal(err)
+	}
+	runMerge(refs.Short(upstream))
+}
+
+func init() {
+	rootCmd.AddCommand(pullCmd)
+	addJobsFlag(pullCmd)
+	pullCmd.Flags().BoolVar(&mergeFFOnly, "ff-only", false, "Refuse to pull unless the current branch can be fast-forwarded")
+}
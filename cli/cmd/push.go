@@ -1,71 +1,351 @@
 package cmd
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"os"
-	"sdk/pkg/aws"
-	"sdk/pkg/compressor"
+	"sdk/pkg/refs"
+	"sdk/pkg/remote"
+	"sdk/pkg/transfer"
 	"strings"
 
 	"github.com/spf13/cobra"
 )
 
-// helloCmd represents the hello command
+// leaseFromTracking is the value of a bare --force-with-lease: expect the
+// remote branch where the remote-tracking ref says it is.
+const leaseFromTracking = "\x00tracking"
+
+var (
+	pushLease       string
+	pushSetUpstream bool
+)
+
 var pushCmd = &cobra.Command{
-	Use:   "push",
-	Short: "push",
-	Long:  `push`,
+	Short: "Upload the current branch to the remote",
+	Use:   "push [<remote>]",
+	Long: `Uploads the commits of the current branch that the remote does not have,
+together with only those trees, blobs, model manifests and chunks it is
+missing, then points the remote branch at the local one. The remote
+defaults to the upstream remote of the branch, else origin. Its URL may be
+a directory (file:///srv/models/repo or a plain path), an S3 location
+(s3://bucket/prefix) or an HTTP server (https://host/repo).
+
+The push is rejected unless it fast-forwards the remote branch.
+--force-with-lease overwrites it anyway, but only if it is still where the
+last push or fetch left it, or at the commit given as
+--force-with-lease=<branch>:<expected>.
+
+Objects are uploaded several at a time (--jobs, or "jobs" under
+"transfer" in .stk/config.json) and failed uploads are retried. Large
+objects go to S3 in parts. An interrupted push continues where it stopped
+when run again.
+
+-u records the remote branch as the upstream of the local one, so that
+later pushes, pulls and stk status use it without naming the remote.`,
+	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		pushChanges()
+		pushChanges(remoteArg(args))
 	},
 }
 
-func getParentCommit(commit string) string {
-	var parentCommit Commit
-	commitPath := fmt.Sprintf(".stk/commits/%s/%s", commit[:2], commit[2:])
-	byteCommit, _ := compressor.DecompressFile(commitPath, "")
-	json.Unmarshal(byteCommit, &parentCommit)
-	return parentCommit.Parent
+// missingCommits returns the commits reachable from commit that are not
+// reachable from remoteCommit, walking every parent of merge commits.
+func missingCommits(commit, remoteCommit string) []string {
+	remoteHas := make(map[string]bool)
+	if remoteCommit != "" {
+		remoteHas = reachableCommits(remoteCommit)
+	}
+	return missingCommitsFrom(commit, remoteHas)
 }
 
-func pushChanges() {
-	if !aws.RepoExists("testRepo2/") {
-		pushAll()
-	} else {
-		branch, _ := os.ReadFile(".stk/HEAD")
-		currCommitBytes, _ := os.ReadFile(fmt.Sprintf(".stk/%s", branch))
-		currCommit := strings.TrimSpace(string(currCommitBytes))
-		remoteCommit := aws.GetRemoteCommit(string(branch))
-		var prevCommit string
-		var remainingCommits []string
-
-		if remoteCommit != currCommit {
-			parentCommit := getParentCommit(currCommit)
-
-			for {
-				if remoteCommit == parentCommit {
-					break
-				} else {
-					prevCommit = parentCommit
-					_ = append(remainingCommits, prevCommit)
-					parentCommit = getParentCommit(parentCommit)
-				}
-			}
+// missingCommitsFrom returns the commits reachable from commit that are not
+// in have.
+func missingCommitsFrom(commit string, have map[string]bool) []string {
+	var missing []string
+	seen := make(map[string]bool)
+	queue := []string{commit}
+	for len(queue) > 0 {
+		curr := queue[0]
+		queue = queue[1:]
+		if curr == "" || seen[curr] || have[curr] {
+			continue
+		}
+		seen[curr] = true
+		missing = append(missing, curr)
+
+		commitData, err := readCommit(curr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		queue = append(queue, commitData.Parents...)
+	}
+	return missing
+}
+
+func reachableCommits(commit string) map[string]bool {
+	seen := make(map[string]bool)
+	queue := []string{commit}
+	for len(queue) > 0 {
+		curr := queue[0]
+		queue = queue[1:]
+		if curr == "" || seen[curr] {
+			continue
+		}
+		seen[curr] = true
+
+		commitData, err := readCommit(curr)
+		if err != nil {
+			// The remote may know commits we have never fetched.
+			continue
+		}
+		queue = append(queue, commitData.Parents...)
+	}
+	return seen
+}
+
+func pushChanges(remoteName string) {
+	branch := currentBranch()
+	if branch == "" {
+		log.Fatal("You are not currently on a branch; check out a branch to push.")
+	}
+	currCommit := headCommit()
+	if currCommit == "" {
+		log.Fatalf("Branch '%s' has no commits to push.", refs.Short(branch))
+	}
+
+	r := openRemote(remoteName, true)
+	remoteRefs, err := r.ListRefs()
+	if err != nil {
+		log.Fatal("Error listing remote refs: ", err)
+	}
+	remoteCommit := ""
+	for _, ref := range remoteRefs {
+		if ref.Name == branch {
+			remoteCommit = ref.Hash
+		}
+	}
+
+	expected := remoteCommit
+	if pushLease != "" {
+		expected = leaseValue(remoteName, branch)
+		if remoteCommit != expected {
+			fmt.Printf(" ! [rejected]  %s -> %s (stale info)\n", refs.Short(branch), refs.Short(branch))
+			fmt.Printf("error: %s on the remote is at %s, not %s as expected.\n", refs.Short(branch), describeCommit(remoteCommit), describeCommit(expected))
+			os.Exit(1)
+		}
+	} else if remoteCommit != "" && !reachableCommits(currCommit)[remoteCommit] {
+		fmt.Printf(" ! [rejected]  %s -> %s (non-fast-forward)\n", refs.Short(branch), refs.Short(branch))
+		fmt.Println("error: the remote branch contains commits that are not in your branch.")
+		fmt.Println("hint: integrate the remote changes before pushing again,")
+		fmt.Println("hint: or overwrite them with 'stk push --force-with-lease'.")
+		os.Exit(1)
+	}
+
+	if remoteCommit == currCommit {
+		fmt.Println("Everything up-to-date")
+		if err := refStore().Write(trackingRef(remoteName, branch), currCommit); err != nil {
+			log.Fatal("Error updating remote-tracking ref: ", err)
+		}
+		setPushUpstream(remoteName, branch)
+		return
+	}
+
+	// Everything reachable from a remote ref the remote already has, along
+	// with the trees and models of those commits.
+	have := make(map[string]bool)
+	for _, ref := range remoteRefs {
+		for hash := range reachableCommits(ref.Hash) {
+			have[hash] = true
+		}
+	}
+	commits := missingCommitsFrom(currCommit, have)
+	keys, err := objectKeys(commits)
+	if err != nil {
+		log.Fatal("Error collecting objects: ", err)
+	}
+
+	update := remote.RefUpdate{Name: branch, Old: expected, New: currCommit}
+	err = pushPack(r, keys, len(commits), update)
+	if errors.Is(err, errors.ErrUnsupported) {
+		err = pushObjects(r, keys, len(commits), update)
+	}
+	if err != nil {
+		if errors.Is(err, remote.ErrStale) {
+			log.Fatalf("Rejected: %s changed on the remote while pushing; try again.", refs.Short(branch))
+		}
+		log.Fatal("Error updating remote ref: ", err)
+	}
+	if err := refStore().Write(trackingRef(remoteName, branch), currCommit); err != nil {
+		log.Fatal("Error updating remote-tracking ref: ", err)
+	}
+
+	fmt.Println("To " + r.URL())
+	switch {
+	case remoteCommit == "":
+		fmt.Printf(" * [new branch]  %s -> %s\n", refs.Short(branch), refs.Short(branch))
+	case reachableCommits(currCommit)[remoteCommit]:
+		fmt.Printf("   %s..%s  %s -> %s\n", shortHash(remoteCommit), shortHash(currCommit), refs.Short(branch), refs.Short(branch))
+	default:
+		fmt.Printf(" + %s...%s  %s -> %s (forced update)\n", shortHash(remoteCommit), shortHash(currCommit), refs.Short(branch), refs.Short(branch))
+	}
+	setPushUpstream(remoteName, branch)
+}
+
+// pushPack sends the objects under keys that the remote lacks as one pack
+// and has the server apply update once it holds them. It returns
+// errors.ErrUnsupported when r cannot receive packs.
+func pushPack(r remote.Remote, keys []string, commits int, update remote.RefUpdate) error {
+	pt, ok := r.(remote.PackTransport)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	missing, err := missingOnRemote(r, keys)
+	if err != nil {
+		log.Fatal("Error checking remote objects: ", err)
+	}
+
+	fmt.Printf("Sending %d of %d objects for %d commits\n", len(missing), len(keys), commits)
+	var progress *transfer.Progress
+	err = pt.PushPack([]remote.RefUpdate{update}, func(w io.Writer) error {
+		// Only started once the server accepted the request, so a
+		// fallback to pushObjects draws no bar.
+		progress = transfer.NewProgress("Writing objects", len(missing), 0)
+		return writeLocalPack(w, missing, progress)
+	})
+	progress.Finish()
+	return err
+}
+
+// pushObjects uploads the objects under keys that the remote lacks one by
+// one, then moves the ref with update. A journal lets an interrupted push
+// resume without checking what it already uploaded.
+func pushObjects(r remote.Remote, keys []string, commits int, update remote.RefUpdate) error {
+	journal, err := transfer.OpenJournal(commonPath(pushJournalFile), r.URL())
+	if err != nil {
+		log.Fatal("Error opening push journal: ", err)
+	}
+	var pending []string
+	for _, key := range keys {
+		if !journal.Done(key) {
+			pending = append(pending, key)
+		}
+	}
+	missing, err := missingOnRemote(r, pending)
+	if err != nil {
+		log.Fatal("Error checking remote objects: ", err)
+	}
+
+	if resumed := len(keys) - len(pending); resumed > 0 {
+		fmt.Printf("Resuming an interrupted push; %d objects were already uploaded\n", resumed)
+	}
+	fmt.Printf("Uploading %d of %d objects for %d commits\n", len(missing), len(keys), commits)
+	if err := uploadObjects(r, missing, journal); err != nil {
+		journal.Close()
+		log.Fatal("Error uploading objects: ", err, "\nRun stk push again to resume.")
+	}
+
+	if err := r.UpdateRef(update.Name, update.Old, update.New); err != nil {
+		journal.Close()
+		return err
+	}
+	if err := journal.Remove(); err != nil {
+		log.Fatal("Error removing push journal: ", err)
+	}
+	return nil
+}
+
+// setPushUpstream makes the pushed branch the upstream of the local one
+// when -u was given.
+func setPushUpstream(remoteName, branch string) {
+	if !pushSetUpstream {
+		return
+	}
+	if err := setUpstream(branch, remoteName, branch); err != nil {
+		log.Fatal("Error writing config: ", err)
+	}
+	fmt.Printf("branch '%s' set up to track '%s'.\n", refs.Short(branch), refs.Short(trackingRef(remoteName, branch)))
+}
+
+// trackingRef is where the last known value of branch on the named remote
+// is kept, e.g. refs/remotes/origin/main.
+func trackingRef(remoteName, branch string) string {
+	return refs.RemotesPrefix + remoteName + "/" + refs.Short(branch)
+}
+
+// leaseValue returns the value --force-with-lease expects the remote
+// branch to have: the revision given as --force-with-lease=<branch>:<rev>,
+// or the remote-tracking ref recorded by the last push.
+func leaseValue(remoteName, branch string) string {
+	if pushLease == leaseFromTracking {
+		hash, err := refStore().Read(trackingRef(remoteName, branch))
+		if err != nil {
+			log.Fatalf("No remote-tracking ref %s to take the lease from; use --force-with-lease=%s:<expected>.", trackingRef(remoteName, branch), refs.Short(branch))
 		}
+		return hash
+	}
 
-		pushCommit(prevCommit)
+	name, rev, ok := strings.Cut(pushLease, ":")
+	if !ok {
+		log.Fatal("Usage: --force-with-lease[=<branch>:<expected>]")
+	}
+	if name != refs.Short(branch) && name != branch {
+		log.Fatalf("The lease names '%s' but the branch being pushed is '%s'.", name, refs.Short(branch))
+	}
+	if rev == "" {
+		// An empty expectation means the branch must not exist yet.
+		return ""
+	}
+	if isObjectHash(rev) {
+		// The expected commit may exist only on the remote.
+		return rev
+	}
+	hash, err := resolveRevision(rev)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return hash
+}
 
-		// prev
+func describeCommit(hash string) string {
+	if hash == "" {
+		return "nothing"
 	}
+	return shortHash(hash)
 }
 
-func pushCommit(commit string) {}
+// objectKeys lists the remote keys of commits and of every tree, blob,
+// model manifest and chunk they reference, ordered so that an object comes
+// before anything pointing at it.
+func objectKeys(commits []string) ([]string, error) {
+	return objectKeysFrom(localObjects{}, commits, nil, noModelFilter)
+}
 
-func pushAll() {
-	aws.PushFolder(".stk")
+// missingOnRemote returns the keys the remote does not have, asking in
+// batches and keeping the order of keys.
+func missingOnRemote(r remote.Remote, keys []string) ([]string, error) {
+	var missing []string
+	for start := 0; start < len(keys); start += remote.BatchSize {
+		batch := keys[start:min(start+remote.BatchSize, len(keys))]
+		found, err := r.Has(batch)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range batch {
+			if !found[key] {
+				missing = append(missing, key)
+			}
+		}
+	}
+	return missing, nil
 }
 
 func init() {
 	rootCmd.AddCommand(pushCmd)
+	pushCmd.Flags().StringVar(&pushLease, "force-with-lease", "", "Overwrite the remote branch if it is still at the expected commit")
+	pushCmd.Flags().Lookup("force-with-lease").NoOptDefVal = leaseFromTracking
+	addJobsFlag(pushCmd)
+	pushCmd.Flags().BoolVarP(&pushSetUpstream, "set-upstream", "u", false, "Make the remote branch the upstream of the current branch")
 }
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPushOnlyFastForwards(t *testing.T) {
+	url := t.TempDir()
+	a := newTestRepo(t)
+	a.write("a.txt", "a\n")
+	a.commit("one")
+	a.run("remote", "add", "origin", url)
+	a.run("push", "-u")
+	b := cloneTestRepo(t, url)
+
+	a.write("a.txt", "a2\n")
+	a.commit("from a")
+	out := a.run("push")
+	if !strings.Contains(out, "Uploading 3 of 3 objects for 1 commits") {
+		t.Errorf("push did not upload only the new commit:\n%s", out)
+	}
+	fromA := a.head()
+
+	b.write("b.txt", "b\n")
+	b.commit("from b")
+	fromB := b.head()
+	out, err := b.stk("push")
+	if err == nil || !strings.Contains(out, "(non-fast-forward)") {
+		t.Fatalf("push of a diverged branch was not rejected (%v):\n%s", err, out)
+	}
+	// b last saw the remote before a's push, so its lease is stale.
+	out, err = b.stk("push", "--force-with-lease")
+	if err == nil || !strings.Contains(out, "(stale info)") {
+		t.Fatalf("--force-with-lease overwrote an unseen commit (%v):\n%s", err, out)
+	}
+	if out, err := b.stk("push", "--force-with-lease=main:"+fromB); err == nil {
+		t.Fatalf("--force-with-lease with the wrong expected commit succeeded:\n%s", out)
+	}
+	remoteMain := func() string {
+		a.run("fetch")
+		return strings.TrimSpace(a.run("rev-parse", "origin/main"))
+	}
+	if got := remoteMain(); got != fromA {
+		t.Fatalf("remote main = %s after rejected pushes, want %s", got, fromA)
+	}
+
+	out = b.run("push", "--force-with-lease=main:"+fromA)
+	if !strings.Contains(out, "(forced update)") {
+		t.Errorf("push output does not report the forced update:\n%s", out)
+	}
+	if got := remoteMain(); got != fromB {
+		t.Errorf("remote main = %s, want %s", got, fromB)
+	}
+
+	// After a fetch the bare lease is current again.
+	a.write("a.txt", "a3\n")
+	a.commit("replaced")
+	a.run("push", "--force-with-lease")
+	if got := remoteMain(); got != a.head() {
+		t.Errorf("remote main = %s after --force-with-lease, want %s", got, a.head())
+	}
+	if out := a.run("push"); !strings.Contains(out, "Everything up-to-date") {
+		t.Errorf("second push:\n%s", out)
+	}
+}
@@ -1,38 +1,370 @@
 package cmd
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"log"
 	"os"
+	"sdk/pkg/refs"
+	"sdk/pkg/remote"
+	"sort"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/spf13/cobra"
+	"github.com/zalando/go-keyring"
 )
 
-type Remote struct {
-	Origin string `json:"origin"`
+const remoteFile = "remote.json"
+
+// defaultRemoteName is the remote clone creates and push, fetch and pull
+// fall back to.
+const defaultRemoteName = "origin"
+
+// RemoteConfig is one named remote in .stk/remote.json. Pushes go to
+// PushURL when it is set and to URL otherwise.
+type RemoteConfig struct {
+	URL     string `json:"url"`
+	PushURL string `json:"push_url,omitempty"`
 }
 
-// helloCmd represents the hello command
+// UnmarshalJSON also accepts the older form where a remote is just its URL,
+// as in {"origin": "s3://bucket/repo"}.
+func (r *RemoteConfig) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		*r = RemoteConfig{}
+		return json.Unmarshal(data, &r.URL)
+	}
+	type plain RemoteConfig
+	return json.Unmarshal(data, (*plain)(r))
+}
+
+var remoteVerbose bool
+
 var remoteCmd = &cobra.Command{
-	Use:   "hello",
-	Short: "Calls hello API with your saved token",
-	Long:  `Calls a protected hello API endpoint using the bearer token saved in the system keyring.`,
+	Use:   "remote",
+	Short: "Manage the remotes of the repository",
+	Long: `Without a subcommand, lists the configured remotes; -v adds their URLs.
+Remotes are stored in .stk/remote.json. Each has a URL used for fetching
+and, optionally, a different URL used for pushing.
+Example:
+  stk remote add origin s3://models/llama-ft
+  stk remote add backup /mnt/nas/llama-ft
+  stk remote set-url --push origin https://models.internal/llama-ft
+  stk remote rename backup nas
+  stk remote -v`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		listRemotes()
+	},
+}
+
+var remoteAddCmd = &cobra.Command{
+	Use:   "add <name> <url>",
+	Short: "Add a remote",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		addRemote(args[0], args[1])
+	},
+}
+
+var remoteRemoveCmd = &cobra.Command{
+	Use:     "remove <name>",
+	Aliases: []string{"rm"},
+	Short:   "Remove a remote and its remote-tracking branches",
+	Args:    cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		origin := args[1]
-		setOrigin(origin)
+		removeRemote(args[0])
 	},
 }
 
-func setOrigin(origin string) {
+var remoteRenameCmd = &cobra.Command{
+	Use:   "rename <old> <new>",
+	Short: "Rename a remote and its remote-tracking branches",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		renameRemote(args[0], args[1])
+	},
+}
+
+var remoteSetURLPush bool
+
+var remoteSetURLCmd = &cobra.Command{
+	Use:   "set-url [--push] <name> <url>",
+	Short: "Change the URL of a remote",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		setRemoteURL(args[0], args[1], remoteSetURLPush)
+	},
+}
+
+// loadRemotes reads .stk/remote.json, leaving out remotes without a URL.
+func loadRemotes() (map[string]RemoteConfig, error) {
+	remotes := make(map[string]RemoteConfig)
+	data, err := os.ReadFile(commonPath(remoteFile))
+	if os.IsNotExist(err) {
+		return remotes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &remotes); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", commonPath(remoteFile), err)
+	}
+	for name, r := range remotes {
+		if r.URL == "" {
+			delete(remotes, name)
+		}
+	}
+	return remotes, nil
+}
+
+func saveRemotes(remotes map[string]RemoteConfig) error {
+	data, err := json.MarshalIndent(remotes, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(commonPath(remoteFile), append(data, '\n'), 0644)
+}
+
+func mustLoadRemotes() map[string]RemoteConfig {
+	remotes, err := loadRemotes()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return remotes
+}
+
+func sortedRemoteNames(remotes map[string]RemoteConfig) []string {
+	names := make([]string, 0, len(remotes))
+	for name := range remotes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// remoteName picks the remote for push, fetch and pull when none is given:
+// the upstream remote of the current branch, else origin, else the only
+// remote there is.
+func remoteName() (string, error) {
+	remotes, err := loadRemotes()
+	if err != nil {
+		return "", err
+	}
+	if head, _, err := refStore().Head(); err == nil && head != "" {
+		config, err := loadConfig()
+		if err != nil {
+			return "", err
+		}
+		if upstream, ok := config.Branches[refs.Short(head)]; ok && upstream.Remote != "" {
+			return upstream.Remote, nil
+		}
+	}
+	if _, ok := remotes[defaultRemoteName]; ok {
+		return defaultRemoteName, nil
+	}
+	switch len(remotes) {
+	case 0:
+		return "", fmt.Errorf("no remote configured; add one with 'stk remote add %s <url>'", defaultRemoteName)
+	case 1:
+		return sortedRemoteNames(remotes)[0], nil
+	}
+	return "", fmt.Errorf("no remote named %s; choose one of %s", defaultRemoteName, strings.Join(sortedRemoteNames(remotes), ", "))
+}
+
+// remoteURL returns the URL of the named remote, or its push URL.
+func remoteURL(name string, push bool) (string, error) {
+	remotes, err := loadRemotes()
+	if err != nil {
+		return "", err
+	}
+	r, ok := remotes[name]
+	if !ok {
+		return "", fmt.Errorf("'%s' does not appear to be a remote; see 'stk remote -v'", name)
+	}
+	if push && r.PushURL != "" {
+		return r.PushURL, nil
+	}
+	return r.URL, nil
+}
+
+// openRemote connects to the named remote with the transport its URL
+// names, using the push URL for pushes. HTTP remotes authenticate with the
+// token saved by stk login.
+func openRemote(name string, push bool) remote.Remote {
+	url, err := remoteURL(name, push)
+	if err != nil {
+		log.Fatal(err)
+	}
+	token, _ := keyring.Get("stackai", "token")
+	r, err := remote.Open(url, remote.Options{Token: token})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return r
+}
+
+// remoteArg returns the remote named on the command line, or the default.
+func remoteArg(args []string) string {
+	if len(args) > 0 {
+		return args[0]
+	}
+	name, err := remoteName()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return name
+}
+
+func listRemotes() {
+	remotes := mustLoadRemotes()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	for _, name := range sortedRemoteNames(remotes) {
+		if !remoteVerbose {
+			fmt.Fprintln(w, name)
+			continue
+		}
+		r := remotes[name]
+		push := r.PushURL
+		if push == "" {
+			push = r.URL
+		}
+		fmt.Fprintf(w, "%s\t%s (fetch)\n", name, r.URL)
+		fmt.Fprintf(w, "%s\t%s (push)\n", name, push)
+	}
+	w.Flush()
+}
 
-	remote := Remote{Origin: origin}
-	remoteJson, _ := json.MarshalIndent(remote, "", "  ")
+func validateRemoteName(name string) {
+	if err := refs.ValidateName(name); err != nil || strings.Contains(name, "/") {
+		log.Fatalf("'%s' is not a valid remote name.", name)
+	}
+}
+
+func addRemote(name, url string) {
+	validateRemoteName(name)
+	if err := remote.ValidateURL(url); err != nil {
+		log.Fatal(err)
+	}
+	remotes := mustLoadRemotes()
+	if _, ok := remotes[name]; ok {
+		log.Fatalf("Remote '%s' already exists.", name)
+	}
+	remotes[name] = RemoteConfig{URL: url}
+	if err := saveRemotes(remotes); err != nil {
+		log.Fatal("Error writing remotes: ", err)
+	}
+}
+
+func setRemoteURL(name, url string, push bool) {
+	if err := remote.ValidateURL(url); err != nil {
+		log.Fatal(err)
+	}
+	remotes := mustLoadRemotes()
+	r, ok := remotes[name]
+	if !ok {
+		log.Fatalf("No such remote '%s'.", name)
+	}
+	if push {
+		r.PushURL = url
+	} else {
+		r.URL = url
+	}
+	remotes[name] = r
+	if err := saveRemotes(remotes); err != nil {
+		log.Fatal("Error writing remotes: ", err)
+	}
+}
 
-	err := os.WriteFile(".stk/remote.json", remoteJson, 0644)
+func removeRemote(name string) {
+	remotes := mustLoadRemotes()
+	if _, ok := remotes[name]; !ok {
+		log.Fatalf("No such remote '%s'.", name)
+	}
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if config.Partial != nil && config.Partial.Remote == name {
+		log.Fatalf("Remote '%s' holds the model weights left out of this partial clone and cannot be removed.", name)
+	}
+
+	store := refStore()
+	tracking, err := store.List(refs.RemotesPrefix + name + "/")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, ref := range tracking {
+		if err := store.Delete(ref.Name); err != nil {
+			log.Fatal("Error deleting ", ref.Name, ": ", err)
+		}
+	}
+	for branch, upstream := range config.Branches {
+		if upstream.Remote == name {
+			delete(config.Branches, branch)
+		}
+	}
+	if err := saveConfig(config); err != nil {
+		log.Fatal("Error writing config: ", err)
+	}
+
+	delete(remotes, name)
+	if err := saveRemotes(remotes); err != nil {
+		log.Fatal("Error writing remotes: ", err)
+	}
+}
+
+func renameRemote(oldName, newName string) {
+	validateRemoteName(newName)
+	remotes := mustLoadRemotes()
+	if _, ok := remotes[oldName]; !ok {
+		log.Fatalf("No such remote '%s'.", oldName)
+	}
+	if _, ok := remotes[newName]; ok {
+		log.Fatalf("Remote '%s' already exists.", newName)
+	}
+
+	store := refStore()
+	tracking, err := store.List(refs.RemotesPrefix + oldName + "/")
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
+	}
+	for _, ref := range tracking {
+		renamed := refs.RemotesPrefix + newName + "/" + strings.TrimPrefix(ref.Name, refs.RemotesPrefix+oldName+"/")
+		if err := store.Rename(ref.Name, renamed); err != nil {
+			log.Fatal("Error renaming ", ref.Name, ": ", err)
+		}
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for branch, upstream := range config.Branches {
+		if upstream.Remote == oldName {
+			upstream.Remote = newName
+			config.Branches[branch] = upstream
+		}
+	}
+	if config.Partial != nil && config.Partial.Remote == oldName {
+		config.Partial.Remote = newName
+	}
+	if err := saveConfig(config); err != nil {
+		log.Fatal("Error writing config: ", err)
+	}
+
+	remotes[newName] = remotes[oldName]
+	delete(remotes, oldName)
+	if err := saveRemotes(remotes); err != nil {
+		log.Fatal("Error writing remotes: ", err)
 	}
 }
 
 func init() {
 	rootCmd.AddCommand(remoteCmd)
+	remoteCmd.AddCommand(remoteAddCmd, remoteRemoveCmd, remoteRenameCmd, remoteSetURLCmd)
+	remoteCmd.Flags().BoolVarP(&remoteVerbose, "verbose", "v", false, "Show the fetch and push URL of each remote")
+	remoteSetURLCmd.Flags().BoolVar(&remoteSetURLPush, "push", false, "Set the URL used for pushing")
 }
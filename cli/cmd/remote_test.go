@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"sdk/pkg/refs"
+	"strings"
+	"testing"
+)
+
+func TestRemoteCommands(t *testing.T) {
+	origin, pushURL, backup := t.TempDir(), t.TempDir(), t.TempDir()
+	r := newTestRepo(t)
+	r.write("a.txt", "a\n")
+	r.commit("one")
+
+	r.run("remote", "add", "origin", origin)
+	r.run("remote", "add", "backup", backup)
+	for _, args := range [][]string{
+		{"add", "origin", backup},
+		{"add", "a/b", backup},
+		{"add", "-x", backup},
+		{"add", "bad", "s3://"},
+		{"remove", "nope"},
+		{"rename", "nope", "other"},
+		{"rename", "backup", "origin"},
+		{"set-url", "nope", backup},
+	} {
+		if out, err := r.stk(append([]string{"remote"}, args...)...); err == nil {
+			t.Errorf("stk remote %s succeeded:\n%s", strings.Join(args, " "), out)
+		}
+	}
+	if got := r.run("remote"); got != "backup\norigin\n" {
+		t.Errorf("remote lists %q", got)
+	}
+
+	r.run("remote", "set-url", "--push", "origin", pushURL)
+	out := r.run("remote", "-v")
+	for _, want := range []string{
+		"origin " + origin + " (fetch)",
+		"origin " + pushURL + " (push)",
+		"backup " + backup + " (fetch)",
+		"backup " + backup + " (push)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("remote -v does not show %q:\n%s", want, out)
+		}
+	}
+
+	// Pushes go to the push URL; a named remote overrides the default.
+	remoteMain := func(url string) string {
+		hash, _ := refs.New(url).Read(refs.BranchRef("main"))
+		return hash
+	}
+	r.run("push", "origin")
+	if got := remoteMain(pushURL); got != r.head() {
+		t.Errorf("push to origin left its push URL at %q", got)
+	}
+	if got := remoteMain(origin); got != "" {
+		t.Errorf("push to origin wrote its fetch URL: %s", got)
+	}
+	r.run("push", "-u", "backup")
+	r.run("fetch", "backup")
+	if got := strings.TrimSpace(r.run("rev-parse", "backup/main")); got != r.head() {
+		t.Errorf("backup/main = %s after fetch, want %s", got, r.head())
+	}
+
+	// A rename carries the remote-tracking branches and the upstream along.
+	r.run("remote", "rename", "backup", "nas")
+	if got := r.run("remote"); got != "nas\norigin\n" {
+		t.Errorf("remote lists %q after the rename", got)
+	}
+	if out, err := r.stk("rev-parse", "backup/main"); err == nil {
+		t.Errorf("backup/main still resolves after the rename: %s", out)
+	}
+	if got := strings.TrimSpace(r.run("rev-parse", "nas/main")); got != r.head() {
+		t.Errorf("nas/main = %s after the rename", got)
+	}
+	r.write("a.txt", "a2\n")
+	r.commit("two")
+	r.run("push")
+	if got := remoteMain(backup); got != r.head() {
+		t.Errorf("push without a remote did not follow the renamed upstream: %q", got)
+	}
+
+	r.run("remote", "remove", "nas")
+	if got := r.run("remote"); got != "origin\n" {
+		t.Errorf("remote lists %q after the removal", got)
+	}
+	if out, err := r.stk("rev-parse", "nas/main"); err == nil {
+		t.Errorf("nas/main still resolves after the removal: %s", out)
+	}
+	if out, err := r.stk("push", "nas"); err == nil {
+		t.Errorf("push to a removed remote succeeded:\n%s", out)
+	}
+}
+
+func TestRemoteOldConfig(t *testing.T) {
+	url := t.TempDir()
+	r := newTestRepo(t)
+	r.write(".stk/remote.json", `{"origin": "`+url+`"}`)
+	if out := r.run("remote", "-v"); !strings.Contains(out, "origin "+url+" (push)") {
+		t.Errorf("remote -v with a plain URL entry:\n%s", out)
+	}
+}
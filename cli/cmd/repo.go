@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Files that belong to a single worktree. Everything else under .stk, the
+// objects, refs and configuration, is shared by all worktrees.
+const (
+	headFile       = "HEAD"
+	indexFile      = "index.json"
+	modelIndexFile = "model_index.json"
+	commonDirFile  = "commondir"
+	stkDirPrefix   = "stkdir: "
+)
+
+var (
+	repoDirsLoaded bool
+	worktreeDir    string
+	sharedDir      string
+)
+
+// stkDir returns the directory holding the HEAD, index and merge state of
+// the current worktree. It is .stk itself in the main worktree; in a linked
+// worktree .stk is a file whose "stkdir: <path>" line names the directory.
+func stkDir() string {
+	loadRepoDirs()
+	return worktreeDir
+}
+
+// commonDir returns the directory shared by every worktree of the
+// repository, holding objects, refs and configuration.
+func commonDir() string {
+	loadRepoDirs()
+	return sharedDir
+}
+
+// stkPath joins elem onto the current worktree's state directory.
+func stkPath(elem ...string) string {
+	return filepath.Join(append([]string{stkDir()}, elem...)...)
+}
+
+// commonPath joins elem onto the shared repository directory.
+func commonPath(elem ...string) string {
+	return filepath.Join(append([]string{commonDir()}, elem...)...)
+}
+
+func loadRepoDirs() {
+	if repoDirsLoaded {
+		return
+	}
+	repoDirsLoaded = true
+	worktreeDir = ".stk"
+
+	if data, err := os.ReadFile(".stk"); err == nil {
+		if dir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), stkDirPrefix); ok {
+			worktreeDir = dir
+		}
+	}
+
+	sharedDir = worktreeDir
+	if data, err := os.ReadFile(filepath.Join(worktreeDir, commonDirFile)); err == nil {
+		common := strings.TrimSpace(string(data))
+		if !filepath.IsAbs(common) {
+			common = filepath.Join(worktreeDir, common)
+		}
+		sharedDir = common
+	}
+}
+
+// enterWorktree changes into dir and forgets the repository directories of
+// the previous working directory. It returns a function that changes back.
+func enterWorktree(dir string) (func(), error) {
+	prev, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chdir(dir); err != nil {
+		return nil, err
+	}
+	repoDirsLoaded = false
+	return func() {
+		os.Chdir(prev)
+		repoDirsLoaded = false
+	}, nil
+}
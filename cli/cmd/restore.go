@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sdk/pkg/hasher"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+var restoreSource string
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore [--source <revision>] <path>...",
+	Short: "Restore files or models from a revision",
+	Long: `Restore the given files, models or directories from a revision without
+switching branches. Only the requested models are rebuilt, and only the
+index entries of the restored paths are updated.
+Example:
+  stk restore models/encoder.pt                       # from HEAD
+  stk restore --source main~3 models/encoder.pt
+  stk restore --source v1.0 configs/`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runRestore(restoreSource, args)
+	},
+}
+
+func runRestore(source string, paths []string) {
+	commit, err := resolveRevision(source)
+	if err != nil {
+		log.Fatal(err)
+	}
+	treeHash, err := commitTree(commit)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	entries, err := pathEntries(treeHash, paths)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := restoreEntries(entries); err != nil {
+		log.Fatal("Error restoring files: ", err)
+	}
+	if err := updateIndexEntries(entries); err != nil {
+		log.Fatal("Error updating index: ", err)
+	}
+
+	fmt.Printf("Restored %d path(s) from %s\n", len(entries), shortHash(commit))
+}
+
+// pathEntries resolves each path in treeHash to the blob and model entries
+// it names; a directory stands for everything below it.
+func pathEntries(treeHash string, paths []string) ([]Tree, error) {
+	seen := make(map[string]bool)
+	var entries []Tree
+	for _, path := range paths {
+		entry, err := lookupPath(treeHash, path)
+		if err != nil {
+			return nil, fmt.Errorf("pathspec '%s' did not match any file known to stk", path)
+		}
+
+		found := []Tree{entry}
+		if entry.Type == "tree" {
+			flat, err := flattenTree(entry.Hash)
+			if err != nil {
+				return nil, err
+			}
+			found = found[:0]
+			for _, child := range flat {
+				found = append(found, child)
+			}
+		}
+
+		for _, child := range found {
+			if !seen[child.Path] {
+				seen[child.Path] = true
+				entries = append(entries, child)
+			}
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, nil
+}
+
+// restoreEntries writes blob entries and rebuilds model entries into the
+// working directory, overwriting whatever is there.
+func restoreEntries(entries []Tree) error {
+	var models []Tree
+	for _, entry := range entries {
+		switch entry.Type {
+		case "blob":
+			data, err := readBlob(entry.Hash)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(entry.Path), 0755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(entry.Path, data, 0644); err != nil {
+				return err
+			}
+		case "model":
+			models = append(models, entry)
+		}
+	}
+
+	if len(models) == 0 {
+		return nil
+	}
+
+	tmpDir, err := newRebuildDir()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, model := range models {
+		if err := rebuildModel(model, tmpDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateIndexEntries stages entries as they were restored, leaving every
+// other index entry alone.
+func updateIndexEntries(entries []Tree) error {
+	index, modelIndex, err := readIndexFiles()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		switch entry.Type {
+		case "blob":
+			updateIndex(index, entry.Path, entry.Hash)
+			delete(modelIndex, entry.Path)
+		case "model":
+			manifest, err := readModelManifest(entry.Hash)
+			if err != nil {
+				return err
+			}
+			updateIndex(index, entry.Path, hasher.HashFile(entry.Path))
+			modelIndex[entry.Path] = manifest
+		}
+	}
+
+	return writeIndexFiles(index, modelIndex)
+}
+
+func init() {
+	rootCmd.AddCommand(restoreCmd)
+	restoreCmd.Flags().StringVarP(&restoreSource, "source", "s", "HEAD", "Revision to restore from")
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRestorePaths(t *testing.T) {
+	r := newTestRepo(t)
+	r.write("a.txt", "a1\n")
+	r.write("b.txt", "b1\n")
+	r.write("configs/x.json", "x1\n")
+	r.write("configs/y.json", "y1\n")
+	r.writeModel("models/m.safetensors", 1, "w")
+	r.commit("one")
+	r.run("tag", "v1")
+	r.write("a.txt", "a2\n")
+	r.write("b.txt", "b2\n")
+	r.write("configs/x.json", "x2\n")
+	r.write("configs/y.json", "y2\n")
+	r.writeModel("models/m.safetensors", 2, "w")
+	r.commit("two")
+	head := r.head()
+
+	r.run("restore", "--source", "v1", "a.txt", "configs")
+	r.run("checkout", "HEAD~1", "--", "models/m.safetensors")
+	for path, want := range map[string]string{"a.txt": "a1\n", "b.txt": "b2\n", "configs/x.json": "x1\n", "configs/y.json": "y1\n"} {
+		if got := r.read(path); got != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+	if got := r.modelValue("models/m.safetensors", "w"); got != 1 {
+		t.Errorf("restored model has w = %g, want 1", got)
+	}
+	if got := r.head(); got != head {
+		t.Errorf("HEAD moved to %s", got)
+	}
+	if got := strings.TrimSpace(r.run("rev-parse", "--abbrev-ref", "HEAD")); got != "main" {
+		t.Errorf("HEAD detached at %s", got)
+	}
+
+	// Only the restored paths are staged.
+	out := r.run("status")
+	staged, _, _ := strings.Cut(out, "Changes not staged")
+	for _, path := range []string{"a.txt", "configs/x.json", "configs/y.json", "models/m.safetensors"} {
+		if !strings.Contains(staged, "modified:  "+path) {
+			t.Errorf("%s is not staged:\n%s", path, out)
+		}
+	}
+	if strings.Contains(out, "b.txt") {
+		t.Errorf("b.txt changed:\n%s", out)
+	}
+
+	// Without a revision, checkout -- restores from HEAD.
+	r.write("b.txt", "local\n")
+	r.run("checkout", "--", "b.txt")
+	if got := r.read("b.txt"); got != "b2\n" {
+		t.Errorf("b.txt = %q after checkout -- b.txt", got)
+	}
+
+	if out, err := r.stk("restore", "missing.txt"); err == nil || !strings.Contains(out, "did not match any file") {
+		t.Errorf("restore of an unknown path (%v):\n%s", err, out)
+	}
+}
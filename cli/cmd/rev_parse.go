@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"sdk/pkg/refs"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	revParseShort     bool
+	revParseAbbrevRef bool
+	revParseVerify    bool
+)
+
+var revParseCmd = &cobra.Command{
+	Use:   "rev-parse <revision>...",
+	Short: "Resolve revisions to object hashes",
+	Long: `Print the object hash each revision resolves to, one per line.
+Revisions may be HEAD (or @), branch, tag and remote-tracking names, unique
+hash prefixes, <rev>@{upstream}, <rev>~<n> and <rev>^<n>. A range A..B
+prints B followed by ^A, and <rev>:<path> prints the hash of the blob, tree
+or model at path.
+Example:
+  stk rev-parse HEAD
+  stk rev-parse --short main~2
+  stk rev-parse --abbrev-ref HEAD
+  stk rev-parse v1.0..main
+  stk rev-parse HEAD:models/encoder.safetensors`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if revParseVerify && len(args) != 1 {
+			log.Fatal("--verify takes exactly one revision")
+		}
+		for _, arg := range args {
+			runRevParse(arg)
+		}
+	},
+}
+
+func runRevParse(expr string) {
+	if revParseAbbrevRef {
+		fmt.Println(abbrevRef(expr))
+		return
+	}
+
+	if strings.Contains(expr, "..") && !strings.Contains(expr, ":") {
+		if revParseVerify {
+			log.Fatalf("'%s' is a range, not a single revision", expr)
+		}
+		exclude, include, err := resolveRange(expr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		printRevision(include, "")
+		printRevision(exclude, "^")
+		return
+	}
+
+	if rev, ok := strings.CutPrefix(expr, "^"); ok && !revParseVerify {
+		commit, err := resolveRevision(rev)
+		if err != nil {
+			log.Fatal(err)
+		}
+		printRevision(commit, "^")
+		return
+	}
+
+	entry, err := resolveObject(expr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	printRevision(entry.Hash, "")
+}
+
+// abbrevRef returns the short ref name expr resolves to, or HEAD when it
+// names a detached HEAD or a plain commit.
+func abbrevRef(expr string) string {
+	if expr == "HEAD" || expr == "@" {
+		if head := currentBranch(); head != "" {
+			return refs.Short(head)
+		}
+		return "HEAD"
+	}
+
+	if branch, _, ok := strings.Cut(expr, "@{"); ok {
+		upstream, err := upstreamRef(branch)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return refs.Short(upstream)
+	}
+
+	name, _, err := refStore().Resolve(expr)
+	if err != nil {
+		if _, revErr := resolveRevision(expr); revErr != nil {
+			log.Fatal(revErr)
+		}
+		return expr
+	}
+	return refs.Short(name)
+}
+
+func printRevision(hash, prefix string) {
+	if revParseShort {
+		hash = shortHash(hash)
+	}
+	fmt.Println(prefix + hash)
+}
+
+func init() {
+	rootCmd.AddCommand(revParseCmd)
+	revParseCmd.Flags().BoolVar(&revParseShort, "short", false, "Abbreviate hashes to 12 characters")
+	revParseCmd.Flags().BoolVar(&revParseAbbrevRef, "abbrev-ref", false, "Print the short ref name instead of a hash")
+	revParseCmd.Flags().BoolVar(&revParseVerify, "verify", false, "Require exactly one revision that names a single object")
+}
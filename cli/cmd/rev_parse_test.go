@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRevParse(t *testing.T) {
+	r := newTestRepo(t)
+	r.write("a.txt", "one\n")
+	r.commit("one")
+	one := r.head()
+	r.run("tag", "v1.0")
+	r.run("checkout", "-b", "feat")
+	r.write("b.txt", "feat\n")
+	r.commit("feat")
+	feat := r.head()
+	r.run("checkout", "main")
+	r.write("a.txt", "two\n")
+	r.commit("two")
+	two := r.head()
+	r.run("merge", "feat")
+	merge := r.head()
+
+	r.write(".stk/refs/remotes/origin/main", two+"\n")
+	r.write(".stk/config.json", `{"branches": {"main": {"remote": "origin", "merge": "refs/heads/main"}}}`)
+
+	tests := map[string]string{
+		"HEAD":                              merge,
+		"@":                                 merge,
+		"main":                              merge,
+		"refs/heads/feat":                   feat,
+		"v1.0":                              one,
+		one:                                 one,
+		one[:6]:                             one,
+		strings.ToUpper(one[:6]):            one,
+		strings.ToUpper(one):                one,
+		"HEAD^":                             two,
+		"HEAD^1":                            two,
+		"HEAD^2":                            feat,
+		"HEAD~1":                            two,
+		"HEAD~2":                            one,
+		"HEAD^^":                            one,
+		"HEAD^2~1":                          one,
+		"main~0":                            merge,
+		"@{u}":                              two,
+		"main@{upstream}":                   two,
+		"main@{u}~1":                        one,
+		"feat..main":                        merge + "\n^" + feat,
+		"v1.0..":                            merge + "\n^" + one,
+		strings.ToUpper(one[:8]) + "..feat": feat + "\n^" + one,
+	}
+	for rev, want := range tests {
+		out, err := r.stk("rev-parse", rev)
+		if err != nil {
+			t.Errorf("rev-parse %s: %v\n%s", rev, err, out)
+			continue
+		}
+		if got := strings.TrimSpace(out); got != want {
+			t.Errorf("rev-parse %s = %s, want %s", rev, got, want)
+		}
+	}
+
+	for _, rev := range []string{"nope", "abc", "HEAD^3", "HEAD~5", "feat@{u}", "main@{1}", one[:6] + "x"} {
+		if out, err := r.stk("rev-parse", rev); err == nil {
+			t.Errorf("rev-parse %s succeeded:\n%s", rev, out)
+		}
+	}
+
+	if got := strings.TrimSpace(r.run("rev-parse", "--abbrev-ref", "HEAD")); got != "main" {
+		t.Errorf("--abbrev-ref HEAD = %q, want main", got)
+	}
+	if got := strings.TrimSpace(r.run("rev-parse", "--short", "HEAD")); got != shortHash(merge) {
+		t.Errorf("--short HEAD = %q, want %q", got, shortHash(merge))
+	}
+}
@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sdk/pkg/refs"
+	"strconv"
+	"strings"
+)
+
+// minHashPrefix is the shortest abbreviated hash accepted as a revision.
+const minHashPrefix = 4
+
+// resolveRevision turns a revision expression into a commit hash. The
+// expression starts with HEAD (or @), a branch, tag or remote-tracking
+// name, or a full or abbreviated commit hash, optionally followed by
+// @{upstream} (or @{u}) and any number of ~<n> (n-th first-parent
+// ancestor) and ^<n> (n-th parent) suffixes, e.g. main~3, feature^2 or
+// @{u}~1.
+func resolveRevision(expr string) (string, error) {
+	if strings.Contains(expr, "..") {
+		return "", fmt.Errorf("'%s' is a range, not a single revision", expr)
+	}
+	if strings.Contains(expr, ":") {
+		return "", fmt.Errorf("'%s' names a path, not a commit", expr)
+	}
+
+	base := expr
+	suffix := ""
+	if i := strings.IndexAny(expr, "~^"); i >= 0 {
+		base, suffix = expr[:i], expr[i:]
+	}
+
+	commit, err := resolveRevisionBase(base)
+	if err != nil {
+		return "", err
+	}
+
+	for suffix != "" {
+		op := suffix[0]
+		suffix = suffix[1:]
+
+		digits := 0
+		for digits < len(suffix) && suffix[digits] >= '0' && suffix[digits] <= '9' {
+			digits++
+		}
+		n := 1
+		if digits > 0 {
+			n, _ = strconv.Atoi(suffix[:digits])
+		}
+		suffix = suffix[digits:]
+
+		if op == '~' {
+			for i := 0; i < n; i++ {
+				if commit, err = nthParent(commit, 1, expr); err != nil {
+					return "", err
+				}
+			}
+		} else if n > 0 {
+			if commit, err = nthParent(commit, n, expr); err != nil {
+				return "", err
+			}
+		}
+	}
+	return commit, nil
+}
+
+// resolveRange splits an A..B expression into the commit to exclude (A)
+// and the commit to include (B). Either side defaults to HEAD.
+func resolveRange(expr string) (string, string, error) {
+	from, to, ok := strings.Cut(expr, "..")
+	if !ok {
+		return "", "", fmt.Errorf("'%s' is not a range", expr)
+	}
+	if from == "" {
+		from = "HEAD"
+	}
+	if to == "" {
+		to = "HEAD"
+	}
+
+	exclude, err := resolveRevision(from)
+	if err != nil {
+		return "", "", err
+	}
+	include, err := resolveRevision(to)
+	if err != nil {
+		return "", "", err
+	}
+	return exclude, include, nil
+}
+
+// resolveObject resolves <rev>:<path> to the entry stored at path in the
+// tree of rev. Anything else is resolved as a commit.
+func resolveObject(expr string) (Tree, error) {
+	rev, path, ok := strings.Cut(expr, ":")
+	if !ok {
+		commit, err := resolveRevision(expr)
+		if err != nil {
+			return Tree{}, err
+		}
+		return Tree{Type: "commit", Hash: commit}, nil
+	}
+	if rev == "" {
+		rev = "HEAD"
+	}
+
+	commit, err := resolveRevision(rev)
+	if err != nil {
+		return Tree{}, err
+	}
+	treeHash, err := commitTree(commit)
+	if err != nil {
+		return Tree{}, err
+	}
+	entry, err := lookupPath(treeHash, path)
+	if err != nil {
+		return Tree{}, fmt.Errorf("%v in '%s'", err, rev)
+	}
+	return entry, nil
+}
+
+func resolveRevisionBase(name string) (string, error) {
+	if branch, spec, ok := strings.Cut(name, "@{"); ok {
+		if spec != "u}" && spec != "upstream}" {
+			return "", fmt.Errorf("unsupported revision suffix '@{%s'", spec)
+		}
+		return resolveUpstream(branch)
+	}
+
+	switch name {
+	case "":
+		return "", fmt.Errorf("empty revision")
+	case "HEAD", "@":
+		commit := headCommit()
+		if commit == "" {
+			return "", fmt.Errorf("HEAD does not point to a commit yet")
+		}
+		return commit, nil
+	}
+
+	_, hash, err := refStore().Resolve(name)
+	if err == nil {
+		return hash, nil
+	}
+	if !errors.Is(err, refs.ErrNotFound) {
+		return "", err
+	}
+
+	// Hashes are stored in lowercase; accept them typed in either case.
+	if prefix := strings.ToLower(name); isHexPrefix(prefix) {
+		return expandCommitHash(prefix)
+	}
+	return "", fmt.Errorf("unknown revision '%s'", name)
+}
+
+// resolveUpstream returns the commit of the remote-tracking ref that
+// branch is configured to track.
+func resolveUpstream(branch string) (string, error) {
+	upstream, err := upstreamRef(branch)
+	if err != nil {
+		return "", err
+	}
+	hash, err := refStore().Read(upstream)
+	if errors.Is(err, refs.ErrNotFound) {
+		return "", fmt.Errorf("upstream '%s' of branch '%s' has not been fetched", refs.Short(upstream), branch)
+	}
+	return hash, err
+}
+
+// upstreamRef returns the remote-tracking ref name, e.g.
+// refs/remotes/origin/main, configured as the upstream of branch (or of
+// the current branch when empty).
+func upstreamRef(branch string) (string, error) {
+	if branch == "" || branch == "HEAD" {
+		head := currentBranch()
+		if head == "" {
+			return "", fmt.Errorf("HEAD does not point to a branch")
+		}
+		branch = refs.Short(head)
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		return "", err
+	}
+	upstream, ok := config.Branches[branch]
+	if !ok || upstream.Remote == "" || upstream.Merge == "" {
+		return "", fmt.Errorf("no upstream configured for branch '%s'", branch)
+	}
+	return refs.RemotesPrefix + upstream.Remote + "/" + refs.Short(upstream.Merge), nil
+}
+
+func nthParent(commit string, n int, expr string) (string, error) {
+	commitData, err := readCommit(commit)
+	if err != nil {
+		return "", err
+	}
+	if n > len(commitData.Parents) {
+		return "", fmt.Errorf("revision '%s' does not exist: %s has %d parent(s)", expr, shortHash(commit), len(commitData.Parents))
+	}
+	return commitData.Parents[n-1], nil
+}
+
+func isHexPrefix(s string) bool {
+	return len(s) >= minHashPrefix && len(s) <= objectHashLen && isHex(s)
+}
+
+// expandCommitHash finds the single stored commit whose hash starts with
+// prefix.
+func expandCommitHash(prefix string) (string, error) {
+	entries, err := os.ReadDir(commonPath("commits", prefix[:2]))
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("unknown revision '%s'", prefix)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var matches []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), prefix[2:]) {
+			matches = append(matches, prefix[:2]+entry.Name())
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("unknown revision '%s'", prefix)
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("short hash '%s' is ambiguous: matches %s", prefix, strings.Join(matches, ", "))
+}
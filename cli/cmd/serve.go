@@ -0,0 +1,664 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sdk/pkg/auth"
+	"sdk/pkg/compressor"
+	"sdk/pkg/pack"
+	"sdk/pkg/refs"
+	"sdk/pkg/remote"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveRoot   string
+	serveAddr   string
+	serveNoAuth bool
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve --root <dir>",
+	Short: "Host the repositories under a directory over HTTP",
+	Long: `Serves every repository below --root to http:// remotes: the repository
+in <root>/team/llama-ft is reached as http://<host>:8417/team/llama-ft, and
+<root> itself when it is a repository. Create a repository to push to
+with 'stk init' in its directory.
+
+Clients list refs, then fetch only the objects reachable from the commits
+they want and not from those they have, or push the objects the server is
+missing; either way the objects travel as one stream. A push updates its
+refs all together, and only once every object they need is stored.
+
+It also stands in for the web app and its bucket, for tests: the remote
+stk+http://<host>:8417/team/llama-ft reaches the same repository through
+the presigned-URL API the app serves under /api/cli/repos/.
+
+Requests must carry a token from 'stk login'. The server checks it with
+the web app's signing secret, read from $STK_AUTH_SECRET or $AUTH_SECRET;
+--no-auth serves anyone, for use on a trusted network or in tests.
+Example:
+  AUTH_SECRET=... stk serve --root /srv/stk --addr :8417
+  stk remote add origin http://models.internal:8417/team/llama-ft`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runServe()
+	},
+}
+
+func runServe() {
+	root, err := filepath.Abs(serveRoot)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		log.Fatalf("--root %s is not a directory.", serveRoot)
+	}
+
+	server := &repoServer{root: root, locks: make(map[string]*sync.Mutex)}
+	server.signingKey = make([]byte, 32)
+	if _, err := rand.Read(server.signingKey); err != nil {
+		log.Fatal(err)
+	}
+	if !serveNoAuth {
+		secret := os.Getenv("STK_AUTH_SECRET")
+		if secret == "" {
+			secret = os.Getenv("AUTH_SECRET")
+		}
+		if secret == "" {
+			log.Fatal("Set STK_AUTH_SECRET to the web app's AUTH_SECRET to check tokens, or pass --no-auth.")
+		}
+		server.secret = []byte(secret)
+	}
+
+	log.Printf("Serving repositories under %s on %s", root, serveAddr)
+	log.Fatal(http.ListenAndServe(serveAddr, server))
+}
+
+// repoServer answers the HTTP remote protocol described in
+// pkg/remote/http.go for the repositories under root.
+type repoServer struct {
+	root   string
+	secret []byte
+	// signingKey signs the URLs handed out by presign; they do not
+	// outlive the process.
+	signingKey []byte
+
+	mu sync.Mutex
+	// locks serializes ref updates per repository.
+	locks map[string]*sync.Mutex
+}
+
+// servedRepo is one repository being served.
+type servedRepo struct {
+	store *remote.File
+	lock  *sync.Mutex
+}
+
+func (r servedRepo) readObject(dir, hash string) ([]byte, error) {
+	raw, err := r.readRaw(objectKey(dir, hash))
+	if err != nil {
+		return nil, err
+	}
+	return compressor.DecompressData(raw)
+}
+
+func (r servedRepo) readRaw(key string) ([]byte, error) {
+	body, err := r.store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+func (r servedRepo) has(dir, hash string) bool {
+	found, err := r.store.Has([]string{objectKey(dir, hash)})
+	return err == nil && found[objectKey(dir, hash)]
+}
+
+// serveEndpoints are the last path segments the protocol uses; everything
+// before them names the repository.
+var serveEndpoints = []string{"refs", "has", "fetch-pack", "receive-pack", "presign"}
+
+func (s *repoServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// Presigned URLs carry their own authorization.
+	if key, ok := strings.CutPrefix(req.URL.Path, presignedPath); ok {
+		s.servePresigned(w, req, key)
+		return
+	}
+	user, ok := s.authenticate(req)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="stk"`)
+		http.Error(w, "a valid token from stk login is required", http.StatusUnauthorized)
+		return
+	}
+
+	// The web app's broker API addresses the same repositories.
+	p := req.URL.Path
+	if rest, ok := strings.CutPrefix(p, remote.BrokerPath); ok {
+		p = "/" + rest
+	}
+	name, endpoint, key := splitServePath(p)
+	if endpoint == "" {
+		http.NotFound(w, req)
+		return
+	}
+	repo, err := s.open(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case endpoint == "object" && req.Method == http.MethodGet:
+		s.getObject(w, repo, key)
+	case endpoint == "object" && req.Method == http.MethodPut:
+		s.putObject(w, req, repo, key)
+	case endpoint == "refs" && req.Method == http.MethodGet:
+		s.listRefs(w, repo)
+	case endpoint == "refs" && req.Method == http.MethodPost:
+		s.updateRef(w, req, repo, user, name)
+	case endpoint == "has" && req.Method == http.MethodPost:
+		s.hasObjects(w, req, repo)
+	case endpoint == "fetch-pack" && req.Method == http.MethodPost:
+		s.fetchPack(w, req, repo, user, name)
+	case endpoint == "receive-pack" && req.Method == http.MethodPost:
+		s.receivePack(w, req, repo, user, name)
+	case endpoint == "presign" && req.Method == http.MethodPost:
+		s.presign(w, req, name)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// authenticate checks the bearer token and returns the user it was issued
+// to, or "anonymous" under --no-auth.
+func (s *repoServer) authenticate(req *http.Request) (string, bool) {
+	if s.secret == nil {
+		return "anonymous", true
+	}
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return "", false
+	}
+	claims, err := auth.VerifyToken(token, s.secret)
+	if err != nil {
+		return "", false
+	}
+	return claims.Subject, true
+}
+
+// splitServePath splits a request path into the repository, the endpoint
+// and, for objects, the key.
+func splitServePath(p string) (string, string, string) {
+	p = strings.TrimPrefix(p, "/")
+	if i := strings.Index("/"+p, "/object/"); i >= 0 {
+		return strings.Trim(p[:i], "/"), "object", p[i+len("object/"):]
+	}
+	name, endpoint := path.Split(p)
+	for _, e := range serveEndpoints {
+		if endpoint == e {
+			return strings.Trim(name, "/"), endpoint, ""
+		}
+	}
+	return "", "", ""
+}
+
+// open finds the repository name refers to below the root: a directory
+// holding .stk, or one laid out like .stk as a push to a directory leaves
+// it.
+func (s *repoServer) open(name string) (servedRepo, error) {
+	// Hidden path segments cover "..", and .stk of a served worktree.
+	if name != "" && (path.Clean(name) != name || strings.Contains("/"+name, "/.")) {
+		return servedRepo{}, fmt.Errorf("no repository %q", name)
+	}
+	dir := filepath.Join(s.root, filepath.FromSlash(name))
+	if !fileExists(filepath.Join(dir, ".stk")) && !fileExists(filepath.Join(dir, "refs")) {
+		return servedRepo{}, fmt.Errorf("no repository %q", name)
+	}
+	store, err := remote.NewFile(dir)
+	if err != nil {
+		return servedRepo{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lock, ok := s.locks[dir]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.locks[dir] = lock
+	}
+	return servedRepo{store: store, lock: lock}, nil
+}
+
+func (s *repoServer) listRefs(w http.ResponseWriter, repo servedRepo) {
+	all, err := repo.store.ListRefs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	type wireRef struct {
+		Name string `json:"name"`
+		Hash string `json:"hash"`
+	}
+	wire := make([]wireRef, 0, len(all))
+	for _, ref := range all {
+		wire = append(wire, wireRef{ref.Name, ref.Hash})
+	}
+	w.Header().Set(remote.CapabilitiesHeader, "fetch-pack receive-pack")
+	writeJSON(w, wire)
+}
+
+func (s *repoServer) getObject(w http.ResponseWriter, repo servedRepo, key string) {
+	body, err := repo.store.Get(key)
+	if errors.Is(err, remote.ErrNotFound) {
+		http.NotFound(w, nil)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer body.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	io.Copy(w, body)
+}
+
+// putObject stores an object after checking it against the hash in its
+// key, so a client cannot plant content under another object's name.
+func (s *repoServer) putObject(w http.ResponseWriter, req *http.Request, repo servedRepo, key string) {
+	raw, err := io.ReadAll(io.LimitReader(req.Body, pack.MaxObjectSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, _, err := verifyObject(key, raw); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := repo.store.Put(key, bytes.NewReader(raw), int64(len(raw))); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *repoServer) hasObjects(w http.ResponseWriter, req *http.Request, repo servedRepo) {
+	var keys []string
+	if err := json.NewDecoder(req.Body).Decode(&keys); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(keys) > remote.BatchSize {
+		http.Error(w, fmt.Sprintf("at most %d keys per request", remote.BatchSize), http.StatusBadRequest)
+		return
+	}
+	found, err := repo.store.Has(keys)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	present := make([]string, 0, len(found))
+	for _, key := range keys {
+		if found[key] {
+			present = append(present, key)
+		}
+	}
+	writeJSON(w, present)
+}
+
+func (s *repoServer) updateRef(w http.ResponseWriter, req *http.Request, repo servedRepo, user, name string) {
+	var update remote.RefUpdate
+	if err := json.NewDecoder(req.Body).Decode(&update); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.applyUpdates(w, repo, user, name, []remote.RefUpdate{update})
+}
+
+// fetchPack answers a remote.FetchRequest with a pack of the objects
+// reachable from the wanted commits but not from the commits the client
+// has, leaving out the objects in the trees of the latter.
+func (s *repoServer) fetchPack(w http.ResponseWriter, req *http.Request, repo servedRepo, user, name string) {
+	var request remote.FetchRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	modelLimit, err := parseFilter(request.Filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, hash := range append(request.Wants, request.Haves...) {
+		if !isObjectHash(hash) {
+			http.Error(w, fmt.Sprintf("invalid commit %q", hash), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Commits the client has, as far as this repository knows them.
+	have := make(map[string]bool)
+	var haveTips []string
+	for _, hash := range request.Haves {
+		if repo.has(commitsDir, hash) {
+			haveTips = append(haveTips, hash)
+		}
+	}
+	if err := walkCommits(repo, haveTips, func(hash string, _ Commit) bool {
+		have[hash] = true
+		return true
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var commits, boundary []string
+	for _, hash := range request.Wants {
+		if !repo.has(commitsDir, hash) {
+			http.Error(w, fmt.Sprintf("commit %s is not in this repository", hash), http.StatusNotFound)
+			return
+		}
+	}
+	if err := walkCommits(repo, request.Wants, func(hash string, commit Commit) bool {
+		if have[hash] {
+			boundary = append(boundary, hash)
+			return false
+		}
+		commits = append(commits, hash)
+		return true
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	exclude, err := treeObjects(repo, append(boundary, haveTips...))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	keys, err := objectKeysFrom(repo, commits, exclude, modelLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("%s fetched %d objects for %d commits from /%s", user, len(keys), len(commits), name)
+
+	w.Header().Set("Content-Type", remote.PackContentType)
+	pw, err := pack.NewWriter(w)
+	if err != nil {
+		return
+	}
+	for _, key := range keys {
+		raw, err := repo.readRaw(key)
+		if err != nil {
+			// The status line is gone; a pack without its trailer tells
+			// the client the stream is incomplete.
+			log.Printf("fetch from /%s: %v", name, err)
+			return
+		}
+		if err := pw.Add(key, raw); err != nil {
+			return
+		}
+	}
+	pw.Close()
+}
+
+// receivePack stores the pack of a push, checks that every object the
+// pushed commits reference is present, then applies the ref updates.
+func (s *repoServer) receivePack(w http.ResponseWriter, req *http.Request, repo servedRepo, user, name string) {
+	body := bufio.NewReader(req.Body)
+	header, err := body.ReadBytes('\n')
+	if err != nil {
+		http.Error(w, "missing request header", http.StatusBadRequest)
+		return
+	}
+	var request remote.ReceiveRequest
+	if err := json.Unmarshal(header, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, update := range request.Updates {
+		if err := validateUpdate(update); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	pr, err := pack.NewReader(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	received := make(map[string]bool)
+	for {
+		key, raw, err := pr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, _, err := verifyObject(key, raw); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := repo.store.Put(key, bytes.NewReader(raw), int64(len(raw))); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		received[key] = true
+	}
+
+	var tips []string
+	for _, update := range request.Updates {
+		tips = append(tips, update.New)
+	}
+	if err := checkConnected(repo, tips, received); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("%s pushed %d objects to /%s", user, len(received), name)
+	s.applyUpdates(w, repo, user, name, request.Updates)
+}
+
+func validateUpdate(update remote.RefUpdate) error {
+	if err := remote.ValidateRef(update.Name); err != nil {
+		return err
+	}
+	if !isObjectHash(update.New) || (update.Old != "" && !isObjectHash(update.Old)) {
+		return fmt.Errorf("invalid update of %s", update.Name)
+	}
+	return nil
+}
+
+// applyUpdates moves every ref from its old to its new commit, or none of
+// them if any has moved. Updates through this server are serialized; if
+// one still fails, those already made are undone.
+func (s *repoServer) applyUpdates(w http.ResponseWriter, repo servedRepo, user, name string, updates []remote.RefUpdate) {
+	for _, update := range updates {
+		if err := validateUpdate(update); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !repo.has(commitsDir, update.New) {
+			http.Error(w, fmt.Sprintf("commit %s has not been uploaded", update.New), http.StatusBadRequest)
+			return
+		}
+	}
+
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+	all, err := repo.store.ListRefs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	current := make(map[string]string)
+	for _, ref := range all {
+		current[ref.Name] = ref.Hash
+	}
+	for _, update := range updates {
+		if current[update.Name] != update.Old {
+			http.Error(w, fmt.Sprintf("%s is at %s, expected %s", update.Name, describeCommit(current[update.Name]), describeCommit(update.Old)), http.StatusConflict)
+			return
+		}
+	}
+
+	for i, update := range updates {
+		err := repo.store.UpdateRef(update.Name, update.Old, update.New)
+		if err == nil {
+			continue
+		}
+		for _, done := range updates[:i] {
+			if done.Old == "" {
+				repo.store.DeleteRef(done.Name, done.New)
+			} else {
+				repo.store.UpdateRef(done.Name, done.New, done.Old)
+			}
+		}
+		status := http.StatusInternalServerError
+		if errors.Is(err, refs.ErrStale) {
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	for _, update := range updates {
+		log.Printf("%s updated %s in /%s: %s -> %s", user, update.Name, name, describeCommit(update.Old), shortHash(update.New))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// walkCommits visits the commits reachable from tips, each once, children
+// before parents. visit returns false to stop at a commit.
+func walkCommits(src objectSource, tips []string, visit func(hash string, commit Commit) bool) error {
+	seen := make(map[string]bool)
+	queue := append([]string(nil), tips...)
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+		commit, err := sourceCommit(src, hash)
+		if err != nil {
+			return err
+		}
+		if visit(hash, commit) {
+			queue = append(queue, commit.Parents...)
+		}
+	}
+	return nil
+}
+
+// checkConnected makes sure that everything reachable from tips is in the
+// repository. Objects that were there before the push are complete, so
+// only what the push brought in is walked.
+func checkConnected(repo servedRepo, tips []string, received map[string]bool) error {
+	checked := make(map[string]bool)
+	// need reports whether an object still has to be walked, failing when
+	// it is missing.
+	need := func(dir, hash string) (bool, error) {
+		key := objectKey(dir, hash)
+		if checked[key] {
+			return false, nil
+		}
+		checked[key] = true
+		if !repo.has(dir, hash) {
+			return false, fmt.Errorf("push is missing %s", key)
+		}
+		return received[key], nil
+	}
+
+	checkModel := func(hash string) error {
+		if walk, err := need(modelsDir, hash); !walk {
+			return err
+		}
+		manifest, err := sourceManifest(repo, hash)
+		if err != nil {
+			return err
+		}
+		for _, blob := range append([]string{manifest.Architecture, manifest.Metadata}, manifest.Chunks...) {
+			if _, err := need(blobsDir, blob); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var checkTree func(hash string) error
+	checkTree = func(hash string) error {
+		if walk, err := need(treesDir, hash); !walk {
+			return err
+		}
+		entries, err := sourceTree(repo, hash)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			switch entry.Type {
+			case "tree":
+				err = checkTree(entry.Hash)
+			case "model":
+				err = checkModel(entry.Hash)
+			default:
+				_, err = need(blobsDir, entry.Hash)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	queue := append([]string(nil), tips...)
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		walk, err := need(commitsDir, hash)
+		if err != nil {
+			return err
+		}
+		if !walk {
+			continue
+		}
+		commit, err := sourceCommit(repo, hash)
+		if err != nil {
+			return err
+		}
+		if err := checkTree(commit.Tree); err != nil {
+			return err
+		}
+		queue = append(queue, commit.Parents...)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVar(&serveRoot, "root", ".", "Directory holding the repositories to serve")
+	serveCmd.Flags().StringVar(&serveAddr, "addr", ":8417", "Address to listen on")
+	serveCmd.Flags().BoolVar(&serveNoAuth, "no-auth", false, "Serve without checking tokens")
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sdk/pkg/remote"
+	"strconv"
+	"time"
+)
+
+// presignedPath is where stk serve answers the URLs it presigns, followed
+// by the object key.
+const presignedPath = "/presigned/"
+
+// presignExpiry is how long a presigned URL stays valid, as with the web
+// app.
+const presignExpiry = 15 * time.Minute
+
+// presign hands out URLs to GET or PUT objects of the repository name
+// without a token, as the web app does for its bucket.
+func (s *repoServer) presign(w http.ResponseWriter, req *http.Request, name string) {
+	var request remote.PresignRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.Method != http.MethodGet && request.Method != http.MethodPut {
+		http.Error(w, fmt.Sprintf("cannot presign %q", request.Method), http.StatusBadRequest)
+		return
+	}
+	if len(request.Keys) > remote.BatchSize {
+		http.Error(w, fmt.Sprintf("at most %d keys per request", remote.BatchSize), http.StatusBadRequest)
+		return
+	}
+
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	expires := strconv.FormatInt(time.Now().Add(presignExpiry).Unix(), 10)
+	urls := make(map[string]string, len(request.Keys))
+	for _, key := range request.Keys {
+		// Refs move only through the API, which checks the old value.
+		if _, _, ok := parseObjectKey(key); !ok {
+			http.Error(w, fmt.Sprintf("invalid object key %q", key), http.StatusBadRequest)
+			return
+		}
+		query := url.Values{
+			"repo":    {name},
+			"method":  {request.Method},
+			"expires": {expires},
+		}
+		query.Set("signature", s.presignSignature(name, request.Method, key, expires))
+		urls[key] = fmt.Sprintf("%s://%s%s%s?%s", scheme, req.Host, presignedPath, key, query.Encode())
+	}
+	writeJSON(w, remote.PresignResponse{URLs: urls})
+}
+
+func (s *repoServer) presignSignature(name, method, key, expires string) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", method, name, key, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// servePresigned answers a URL handed out by presign like a bucket would.
+func (s *repoServer) servePresigned(w http.ResponseWriter, req *http.Request, key string) {
+	query := req.URL.Query()
+	name, method, expires := query.Get("repo"), query.Get("method"), query.Get("expires")
+	signature, err := hex.DecodeString(query.Get("signature"))
+	want, _ := hex.DecodeString(s.presignSignature(name, method, key, expires))
+	if err != nil || !hmac.Equal(signature, want) || method != req.Method {
+		http.Error(w, "signature does not match", http.StatusForbidden)
+		return
+	}
+	if deadline, err := strconv.ParseInt(expires, 10, 64); err != nil || time.Now().Unix() > deadline {
+		http.Error(w, "request has expired", http.StatusForbidden)
+		return
+	}
+
+	repo, err := s.open(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	switch req.Method {
+	case http.MethodGet:
+		s.getObject(w, repo, key)
+	case http.MethodPut:
+		s.putObject(w, req, repo, key)
+	}
+}
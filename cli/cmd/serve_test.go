@@ -0,0 +1,214 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sdk/pkg/auth"
+	"sdk/pkg/compressor"
+	"sdk/pkg/hasher"
+	"sdk/pkg/refs"
+	"sdk/pkg/remote"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestServer serves the repositories below a new directory as stk serve
+// does, checking tokens against secret unless it is nil. It returns the
+// server and the root, which holds an empty repository team/repo.
+func newTestServer(t *testing.T, secret []byte) (*httptest.Server, string) {
+	t.Helper()
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "team", "repo", "refs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	server := &repoServer{root: root, secret: secret, signingKey: []byte("signing key"), locks: make(map[string]*sync.Mutex)}
+	srv := httptest.NewServer(server)
+	t.Cleanup(srv.Close)
+	return srv, root
+}
+
+// signToken issues a token of the given type as the web app does.
+func signToken(t *testing.T, secret []byte, tokenType string) string {
+	t.Helper()
+	claims, err := json.Marshal(auth.Claims{Subject: "user-1", Type: tokenType, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// compressedBlob returns the key and stored form of a blob holding data.
+func compressedBlob(t *testing.T, data string) (string, []byte) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "blob")
+	if err := compressor.CompressData([]byte(data), path); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return objectKey(blobsDir, hasher.HashData([]byte(data))), raw
+}
+
+func TestServeRequiresCLIToken(t *testing.T) {
+	secret := []byte("app secret")
+	srv, _ := newTestServer(t, secret)
+	for _, tt := range []struct {
+		name, token string
+		status      int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"session token", signToken(t, secret, "session"), http.StatusUnauthorized},
+		{"other secret", signToken(t, []byte("other"), "cli"), http.StatusUnauthorized},
+		{"cli token", signToken(t, secret, "cli"), http.StatusOK},
+	} {
+		for _, path := range []string{"/team/repo/refs", remote.BrokerPath + "team/repo/refs"} {
+			req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("%s: GET %s = %d, want %d", tt.name, path, resp.StatusCode, tt.status)
+			}
+		}
+	}
+
+	// The broker reports a rejected token as access denied.
+	b, err := remote.Open("stk+"+srv.URL+"/team/repo", remote.Options{Token: signToken(t, secret, "session")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.ListRefs(); !errors.Is(err, remote.ErrAccessDenied) {
+		t.Errorf("ListRefs with a session token = %v, want ErrAccessDenied", err)
+	}
+}
+
+func TestBrokerPresignedURLs(t *testing.T) {
+	secret := []byte("app secret")
+	srv, root := newTestServer(t, secret)
+	token := signToken(t, secret, "cli")
+	b, err := remote.Open("stk+"+srv.URL+"/team/repo", remote.Options{Token: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, raw := compressedBlob(t, "weights\n")
+	if err := b.Put(key, bytes.NewReader(raw), int64(len(raw))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "team", "repo", filepath.FromSlash(key))); err != nil {
+		t.Errorf("object not stored in the repository: %v", err)
+	}
+	has, err := b.Has([]string{key})
+	if err != nil || !has[key] {
+		t.Errorf("Has = %v, %v", has, err)
+	}
+	body, err := b.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(body)
+	body.Close()
+	if !bytes.Equal(got, raw) {
+		t.Error("Get returned other bytes than were put")
+	}
+	otherKey, _ := compressedBlob(t, "other\n")
+	if _, err := b.Get(otherKey); !errors.Is(err, remote.ErrNotFound) {
+		t.Errorf("Get of a missing object = %v, want ErrNotFound", err)
+	}
+	if err := b.Put(otherKey, bytes.NewReader(raw), int64(len(raw))); err == nil {
+		t.Error("Put accepted an object that does not match its key")
+	}
+
+	// Refs move only to commits the repository holds.
+	if err := b.UpdateRef(refs.BranchRef("main"), "", strings.Repeat("a", 64)); err == nil || errors.Is(err, remote.ErrStale) {
+		t.Errorf("UpdateRef to a commit never uploaded = %v", err)
+	}
+	if list, err := b.ListRefs(); err != nil || len(list) != 0 {
+		t.Errorf("ListRefs = %v, %v; want no refs", list, err)
+	}
+
+	// A presigned URL is good for its key and method only.
+	presign := func(method string) string {
+		payload, _ := json.Marshal(remote.PresignRequest{Method: method, Keys: []string{key}})
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+remote.BrokerPath+"team/repo/presign", bytes.NewReader(payload))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var answer remote.PresignResponse
+		if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+			t.Fatal(err)
+		}
+		return answer.URLs[key]
+	}
+	get := presign(http.MethodGet)
+	status := func(method, url string) int {
+		req, _ := http.NewRequest(method, url, bytes.NewReader(raw))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for _, tt := range []struct {
+		name, method, url string
+		want              int
+	}{
+		{"as issued", http.MethodGet, get, http.StatusOK},
+		{"other method", http.MethodPut, get, http.StatusForbidden},
+		{"other key", http.MethodGet, strings.Replace(get, key, otherKey, 1), http.StatusForbidden},
+		{"other repository", http.MethodGet, strings.Replace(get, "repo=team", "repo=other", 1), http.StatusForbidden},
+		{"extended", http.MethodGet, strings.Replace(get, "expires=", "expires=9", 1), http.StatusForbidden},
+		{"put as issued", http.MethodPut, presign(http.MethodPut), http.StatusCreated},
+	} {
+		if got := status(tt.method, tt.url); got != tt.want {
+			t.Errorf("%s: %s = %d, want %d", tt.name, tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestPushAndCloneThroughBroker(t *testing.T) {
+	srv, _ := newTestServer(t, nil)
+	url := "stk+" + srv.URL + "/team/repo"
+	a := newTestRepo(t)
+	a.write("a.txt", "a\n")
+	a.writeModel("models/m.safetensors", 1, "w")
+	a.commit("one")
+	a.run("remote", "add", "origin", url)
+	a.run("push", "-u")
+
+	b := cloneTestRepo(t, url)
+	if got := b.read("a.txt"); got != "a\n" {
+		t.Errorf("a.txt = %q in the clone", got)
+	}
+	if got := b.modelValue("models/m.safetensors", "w"); got != 1 {
+		t.Errorf("cloned model has w = %g", got)
+	}
+	if b.head() != a.head() {
+		t.Errorf("clone is at %s, want %s", b.head(), a.head())
+	}
+}
@@ -0,0 +1,320 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sdk/pkg/hasher"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const sparseCheckoutFile = "info/sparse-checkout"
+
+var sparseCheckoutCmd = &cobra.Command{
+	Use:   "sparse-checkout",
+	Short: "Limit the working directory to a subset of paths",
+	Long: `Only files and models matching the patterns in .stk/info/sparse-checkout are
+written to the working directory; everything else stays in the index, so it
+is committed unchanged, but is never decompressed or rebuilt.
+
+Patterns are matched like .stkattributes: a pattern without a slash matches
+a file or directory name anywhere, a pattern with a slash matches from the
+repository root, a trailing slash matches directories only and a leading !
+excludes paths matched by an earlier line.
+Example:
+  stk sparse-checkout set configs/ models/encoder.pt
+  stk sparse-checkout add 'tokenizer*'
+  stk sparse-checkout list
+  stk sparse-checkout disable`,
+}
+
+var sparseSetCmd = &cobra.Command{
+	Use:   "set <pattern>...",
+	Short: "Replace the sparse checkout patterns",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		writeSparsePatterns(args)
+		applySparseCheckout()
+	},
+}
+
+var sparseAddCmd = &cobra.Command{
+	Use:   "add <pattern>...",
+	Short: "Add sparse checkout patterns",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		patterns, err := readSparseLines()
+		if err != nil {
+			log.Fatal(err)
+		}
+		writeSparsePatterns(append(patterns, args...))
+		applySparseCheckout()
+	},
+}
+
+var sparseListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Show the sparse checkout patterns",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		patterns, err := readSparseLines()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, pattern := range patterns {
+			fmt.Println(pattern)
+		}
+	},
+}
+
+var sparseDisableCmd = &cobra.Command{
+	Use:   "disable",
+	Short: "Restore every path to the working directory",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := os.Remove(stkPath(sparseCheckoutFile)); err != nil && !os.IsNotExist(err) {
+			log.Fatal(err)
+		}
+		applySparseCheckout()
+	},
+}
+
+// sparsePattern is one line of .stk/info/sparse-checkout.
+type sparsePattern struct {
+	pattern string
+	negate  bool
+	dirOnly bool
+}
+
+// sparsePatterns decides which paths are materialized. An empty set
+// includes everything.
+type sparsePatterns []sparsePattern
+
+// readSparseCheckout parses .stk/info/sparse-checkout. A missing file
+// disables sparse checkout.
+func readSparseCheckout() (sparsePatterns, error) {
+	lines, err := readSparseLines()
+	if err != nil {
+		return nil, err
+	}
+
+	var patterns sparsePatterns
+	for _, line := range lines {
+		p := sparsePattern{pattern: line}
+		if strings.HasPrefix(p.pattern, "!") {
+			p.negate = true
+			p.pattern = p.pattern[1:]
+		}
+		if strings.HasSuffix(p.pattern, "/") {
+			p.dirOnly = true
+			p.pattern = strings.TrimSuffix(p.pattern, "/")
+		}
+		if p.pattern == "" {
+			continue
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+func readSparseLines() ([]string, error) {
+	f, err := os.Open(stkPath(sparseCheckoutFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+func writeSparsePatterns(patterns []string) {
+	if err := os.MkdirAll(filepath.Dir(stkPath(sparseCheckoutFile)), 0755); err != nil {
+		log.Fatal(err)
+	}
+	data := strings.Join(patterns, "\n") + "\n"
+	if err := os.WriteFile(stkPath(sparseCheckoutFile), []byte(data), 0644); err != nil {
+		log.Fatal("Error writing sparse checkout patterns: ", err)
+	}
+}
+
+// includes reports whether filePath is materialized. The last matching
+// pattern wins.
+func (patterns sparsePatterns) includes(filePath string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	included := false
+	for _, p := range patterns {
+		if p.matches(filePath) {
+			included = !p.negate
+		}
+	}
+	return included
+}
+
+// matches reports whether the pattern matches filePath or one of the
+// directories containing it.
+func (p sparsePattern) matches(filePath string) bool {
+	parts := strings.Split(filePath, "/")
+	anchored := strings.Contains(p.pattern, "/")
+	pattern := strings.TrimPrefix(p.pattern, "/")
+
+	for i := range parts {
+		isDir := i < len(parts)-1
+		if p.dirOnly && !isDir {
+			continue
+		}
+
+		target := parts[i]
+		if anchored {
+			target = strings.Join(parts[:i+1], "/")
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// applySparseCheckout brings the working directory in line with the
+// current patterns: skipped entries that now match are written out and
+// unmodified entries that no longer match are removed.
+func applySparseCheckout() {
+	sparse, err := readSparseCheckout()
+	if err != nil {
+		log.Fatal(err)
+	}
+	entries, err := readIndexEntries()
+	if err != nil {
+		log.Fatal("Error reading index: ", err)
+	}
+	index, modelIndex, err := readIndexFiles()
+	if err != nil {
+		log.Fatal("Error reading index: ", err)
+	}
+
+	paths := make([]string, 0, len(entries))
+	for path := range entries {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var models []Tree
+	written, removed, kept := 0, 0, 0
+	for _, path := range paths {
+		entry := entries[path]
+		_, isModel := modelIndex[path]
+		exists := fileExists(path)
+
+		switch {
+		case sparse.includes(path) && !exists && entry.SkipWorktree:
+			entry.SkipWorktree = false
+			if isModel {
+				model, err := stagedModelEntry(path, modelIndex[path])
+				if err != nil {
+					log.Fatal(err)
+				}
+				models = append(models, model)
+				break
+			}
+			data, err := readBlob(entry.Hash)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				log.Fatal(err)
+			}
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				log.Fatal(err)
+			}
+			written++
+
+		case !sparse.includes(path) && exists:
+			// Model entries without a recorded file hash cannot be checked,
+			// so they are treated as modified.
+			if (isModel && entry.Hash == "") || (entry.Hash != "" && hasher.HashFile(path) != entry.Hash) {
+				fmt.Printf("Not removing %s: it has local changes\n", path)
+				kept++
+				continue
+			}
+			if err := os.Remove(path); err != nil {
+				log.Fatal(err)
+			}
+			removeEmptyParents(path)
+			entry.SkipWorktree = true
+			removed++
+
+		default:
+			continue
+		}
+		setIndexEntry(index, entry)
+	}
+
+	if len(models) > 0 {
+		tmpDir, err := newRebuildDir()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		for _, model := range models {
+			if err := rebuildModel(model, tmpDir); err != nil {
+				log.Fatal(err)
+			}
+			setIndexEntry(index, FileData{Hash: hasher.HashFile(model.Path), Path: model.Path})
+		}
+	}
+
+	if err := writeIndexFiles(index, modelIndex); err != nil {
+		log.Fatal("Error updating index: ", err)
+	}
+
+	fmt.Printf("Sparse checkout updated: %d path(s) written, %d removed", written+len(models), removed)
+	if kept > 0 {
+		fmt.Printf(", %d kept because of local changes", kept)
+	}
+	fmt.Println()
+}
+
+// stagedModelEntry stores the staged manifest of a model as an object so
+// it can be rebuilt like a committed one.
+func stagedModelEntry(path string, manifest interface{}) (Tree, error) {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return Tree{}, err
+	}
+	var decoded ModelManifest
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return Tree{}, fmt.Errorf("failed to parse staged manifest of %s: %w", path, err)
+	}
+	hash, err := createModelManifest(decoded)
+	if err != nil {
+		return Tree{}, err
+	}
+	return Tree{Type: "model", Path: path, Hash: hash}, nil
+}
+
+func init() {
+	rootCmd.AddCommand(sparseCheckoutCmd)
+	sparseCheckoutCmd.AddCommand(sparseSetCmd, sparseAddCmd, sparseListCmd, sparseDisableCmd)
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSparseCheckout(t *testing.T) {
+	r := newTestRepo(t)
+	files := []string{"configs/a.json", "configs/secret.json", "docs/readme.md", "src/tokenizer.json", "src/x.go", "top.txt"}
+	for _, path := range files {
+		r.write(path, path+"\n")
+	}
+	r.commit("one")
+
+	patterns := []string{"configs/", "tokenizer*", "!configs/secret.json", "/top.txt"}
+	r.run(append([]string{"sparse-checkout", "set"}, patterns...)...)
+	if got := strings.TrimSpace(r.run("sparse-checkout", "list")); got != strings.Join(patterns, "\n") {
+		t.Errorf("sparse-checkout list = %q", got)
+	}
+	wantPresent := map[string]bool{"configs/a.json": true, "src/tokenizer.json": true, "top.txt": true}
+	for _, path := range files {
+		if r.exists(path) != wantPresent[path] {
+			t.Errorf("%s present = %v, want %v", path, r.exists(path), wantPresent[path])
+		}
+	}
+	if out := r.run("status"); strings.Contains(out, "deleted") {
+		t.Errorf("status reports skipped paths:\n%s", out)
+	}
+
+	// Commits keep the skipped paths, and checkouts leave them out.
+	r.write("configs/a.json", "edited\n")
+	r.commit("two")
+	if out := r.run("ls-tree", "-r", "HEAD"); !strings.Contains(out, "docs/readme.md") || !strings.Contains(out, "src/x.go") {
+		t.Errorf("the commit dropped skipped paths:\n%s", out)
+	}
+	r.run("checkout", "HEAD~1")
+	if got := r.read("configs/a.json"); got != "configs/a.json\n" {
+		t.Errorf("configs/a.json = %q after checkout", got)
+	}
+	if r.exists("docs/readme.md") {
+		t.Error("checkout wrote a skipped path")
+	}
+
+	r.run("sparse-checkout", "add", "docs/")
+	if !r.exists("docs/readme.md") {
+		t.Error("sparse-checkout add did not write docs/readme.md")
+	}
+	r.run("sparse-checkout", "disable")
+	for _, path := range files {
+		if !r.exists(path) {
+			t.Errorf("%s missing after disable", path)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sdk/pkg/hasher"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the working directory and index status",
+	Long: `Show the current branch and how far it is ahead of or behind its
+upstream, the changes staged for the next commit, the changes not yet
+staged and untracked files. Paths left out by sparse checkout are treated
+as unchanged.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runStatus()
+	},
+}
+
+// fileStatus is one line of stk status, e.g. "modified: a.txt".
+type fileStatus struct {
+	kind string
+	path string
+}
+
+func runStatus() {
+	fmt.Println("On", statusHeadName())
+	if branch := currentBranch(); branch != "" {
+		if summary := trackingSummary(branch); summary != "" {
+			fmt.Println(summary)
+		}
+	}
+	if _, ok := readMergeState(); ok {
+		fmt.Println("You are in the middle of a merge; run 'stk commit' to conclude it or 'stk merge --abort'.")
+	}
+
+	headTree, err := commitTree(headCommit())
+	if err != nil {
+		log.Fatal(err)
+	}
+	committed, err := flattenTree(headTree)
+	if err != nil {
+		log.Fatal(err)
+	}
+	entries, err := readIndexEntries()
+	if err != nil {
+		log.Fatal("Error reading index: ", err)
+	}
+	staged, err := stagedModels()
+	if err != nil {
+		log.Fatal("Error reading index: ", err)
+	}
+
+	// Staged: index against HEAD.
+	var toCommit []fileStatus
+	for path, entry := range entries {
+		hash := entry.Hash
+		if manifest, ok := staged[path]; ok {
+			hash = manifest
+		}
+		head, ok := committed[path]
+		switch {
+		case !ok:
+			toCommit = append(toCommit, fileStatus{"new file", path})
+		case head.Hash != hash:
+			toCommit = append(toCommit, fileStatus{"modified", path})
+		}
+	}
+	for path := range committed {
+		if _, ok := entries[path]; !ok {
+			toCommit = append(toCommit, fileStatus{"deleted", path})
+		}
+	}
+
+	// Not staged: working directory against index.
+	var notStaged []fileStatus
+	for path, entry := range entries {
+		if entry.SkipWorktree {
+			continue
+		}
+		if !fileExists(path) {
+			notStaged = append(notStaged, fileStatus{"deleted", path})
+			continue
+		}
+		if entry.Hash != "" && hasher.HashFile(path) != entry.Hash {
+			notStaged = append(notStaged, fileStatus{"modified", path})
+		}
+	}
+
+	var untracked []fileStatus
+	filepath.WalkDir(".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() && path != "." && d.Name()[0] == '.' {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || skipDotfile(path, d) {
+			return nil
+		}
+		if _, ok := entries[filepath.ToSlash(path)]; !ok {
+			untracked = append(untracked, fileStatus{"", path})
+		}
+		return nil
+	})
+
+	printStatusSection("Changes to be committed:", toCommit)
+	printStatusSection("Changes not staged for commit:", notStaged)
+	printStatusSection("Untracked files:", untracked)
+
+	if len(toCommit)+len(notStaged)+len(untracked) == 0 {
+		fmt.Println("nothing to commit, working tree clean")
+	}
+}
+
+func statusHeadName() string {
+	if currentBranch() == "" {
+		return headName()
+	}
+	return "branch " + headName()
+}
+
+func printStatusSection(title string, files []fileStatus) {
+	if len(files) == 0 {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+
+	fmt.Println(title)
+	for _, file := range files {
+		if file.kind == "" {
+			fmt.Printf("\t%s\n", file.path)
+			continue
+		}
+		fmt.Printf("\t%-10s %s\n", file.kind+":", file.path)
+	}
+	fmt.Println()
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+}
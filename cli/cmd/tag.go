@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"sdk/pkg/refs"
+
+	"github.com/spf13/cobra"
+)
+
+var deleteTag bool
+
+var tagCmd = &cobra.Command{
+	Use:   "tag [name] [commit]",
+	Short: "Create, list or delete tags",
+	Long: `Tags are fixed names for commits, stored under refs/tags.
+Example:
+  stk tag                  # list tags
+  stk tag v1.0             # tag the current commit
+  stk tag v0.9 feature-x   # tag the tip of another branch
+  stk tag v0.8 main~2      # tag any revision
+  stk tag -d v1.0          # delete a tag`,
+	Args: cobra.MaximumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		switch {
+		case deleteTag:
+			if len(args) != 1 {
+				log.Fatal("Usage: stk tag -d <tag>")
+			}
+			runDeleteTag(args[0])
+		case len(args) == 0:
+			listTags()
+		default:
+			target := ""
+			if len(args) == 2 {
+				target = args[1]
+			}
+			createTag(args[0], target)
+		}
+	},
+}
+
+func listTags() {
+	tags, err := refStore().List(refs.TagsPrefix)
+	if err != nil {
+		log.Fatal("Error reading tags: ", err)
+	}
+	for _, tag := range tags {
+		fmt.Println(refs.Short(tag.Name))
+	}
+}
+
+func createTag(name, target string) {
+	if err := refs.ValidateName(name); err != nil {
+		log.Fatal(err)
+	}
+
+	store := refStore()
+	if store.Exists(refs.TagRef(name)) {
+		log.Fatalf("Tag '%s' already exists.", name)
+	}
+
+	commit := headCommit()
+	if target != "" {
+		var err error
+		if commit, err = resolveRevision(target); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if commit == "" {
+		log.Fatal("Cannot tag: the current branch has no commits yet.")
+	}
+
+	if err := store.Write(refs.TagRef(name), commit); err != nil {
+		log.Fatal("Error creating tag: ", err)
+	}
+	fmt.Println("Created tag:", name)
+}
+
+func runDeleteTag(name string) {
+	if err := refStore().Delete(refs.TagRef(name)); err != nil {
+		log.Fatalf("Tag '%s' does not exist.", name)
+	}
+	fmt.Println("Deleted tag:", name)
+}
+
+func init() {
+	rootCmd.AddCommand(tagCmd)
+	tagCmd.Flags().BoolVarP(&deleteTag, "delete", "d", false, "Delete tag")
+}
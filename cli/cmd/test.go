@@ -2,7 +2,7 @@ package cmd
 
 import (
 	"fmt"
-	"sdk/pkg/aws"
+	"log"
 
 	"github.com/spf13/cobra"
 )
@@ -13,7 +13,11 @@ var testCmd = &cobra.Command{
 	Short: "test",
 	Long:  `test`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println(aws.RepoExists("testRepo/"))
+		all, err := openRemote(remoteArg(args), false).ListRefs()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(len(all) > 0)
 	},
 }
 
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path"
+	"sdk/pkg/remote"
+	"sdk/pkg/transfer"
+
+	"github.com/spf13/cobra"
+)
+
+// pushJournalFile records the progress of an interrupted push, see
+// transfer.Journal.
+const pushJournalFile = "push_journal"
+
+// TransferConfig tunes uploads and downloads. Zero values select the
+// defaults of pkg/transfer.
+type TransferConfig struct {
+	// Jobs is how many objects are transferred at once.
+	Jobs int `json:"jobs,omitempty"`
+	// Retries is how many times a failed transfer is retried.
+	Retries int `json:"retries,omitempty"`
+}
+
+var transferJobs int
+
+func addJobsFlag(cmd *cobra.Command) {
+	cmd.Flags().IntVarP(&transferJobs, "jobs", "j", 0, "Number of objects to transfer at once (default 16)")
+}
+
+// transferOptions combines --jobs with the "transfer" settings in
+// .stk/config.json.
+func transferOptions(progress *transfer.Progress) transfer.Options {
+	opts := transfer.Options{Concurrency: transferJobs, Progress: progress}
+	if config, err := loadConfig(); err == nil && config.Transfer != nil {
+		if opts.Concurrency <= 0 {
+			opts.Concurrency = config.Transfer.Jobs
+		}
+		opts.Retries = config.Transfer.Retries
+	}
+	return opts
+}
+
+// transferError marks errors that retrying cannot fix.
+func transferError(err error) error {
+	if errors.Is(err, remote.ErrNotFound) || errors.Is(err, remote.ErrAccessDenied) || isMissingObject(err) {
+		return transfer.Permanent(err)
+	}
+	return err
+}
+
+// uploadObjects uploads the objects under keys to r, several at a time.
+// Runs of keys in the same object directory are uploaded as one stage,
+// so that the order objectKeys gives between data, manifests, trees and
+// commits holds. Every upload is recorded in journal. Like a pack, the
+// upload includes the chunks a partial clone keeps in its cache.
+func uploadObjects(r remote.Remote, keys []string, journal *transfer.Journal) error {
+	var stages [][]transfer.Job
+	var total int64
+	for i, key := range keys {
+		path, err := storedObjectPath(key)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if i == 0 || objectDir(key) != objectDir(keys[i-1]) {
+			stages = append(stages, nil)
+		}
+		stages[len(stages)-1] = append(stages[len(stages)-1], transfer.Job{Key: key, Size: info.Size()})
+		total += info.Size()
+	}
+
+	progress := transfer.NewProgress("Writing objects", len(keys), total)
+	defer progress.Finish()
+	opts := transferOptions(progress)
+	for _, stage := range stages {
+		err := transfer.Run(stage, opts, func(job transfer.Job, count func(int64)) error {
+			if err := putObject(r, job, journal, count); err != nil {
+				return transferError(err)
+			}
+			return journal.MarkDone(job.Key)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putObject uploads one object file, in parts when it is large and the
+// transport supports it.
+func putObject(r remote.Remote, job transfer.Job, journal *transfer.Journal, count func(int64)) error {
+	path, err := storedObjectPath(job.Key)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if multipart, ok := r.(remote.MultipartUploader); ok && job.Size >= remote.MultipartThreshold {
+		return multipart.PutMultipart(job.Key, f, job.Size, remote.MultipartOptions{
+			UploadID: journal.UploadID(job.Key),
+			Started: func(id string) error {
+				return journal.SetUploadID(job.Key, id)
+			},
+			Uploaded: count,
+		})
+	}
+	return r.Put(job.Key, f, job.Size)
+}
+
+// objectDir returns the object directory of a key, e.g. "objects/blobs"
+// for "objects/blobs/ab/cdef…".
+func objectDir(key string) string {
+	return path.Dir(path.Dir(key))
+}
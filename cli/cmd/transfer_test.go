@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"sdk/pkg/refs"
+	"strings"
+	"testing"
+)
+
+func TestPushResumesAfterInterruption(t *testing.T) {
+	url := t.TempDir()
+	r := newTestRepo(t)
+	r.write(".stk/config.json", `{"transfer": {"retries": 1}}`)
+	r.write("a.txt", "a\n")
+	r.write("b.txt", "b\n")
+	r.commit("one")
+	r.run("remote", "add", "origin", url)
+
+	// A dangling link where the commit's directory belongs fails the last
+	// stage, after the blobs and the tree are uploaded.
+	blocker := filepath.Join(url, "commits", r.head()[:2])
+	if err := os.MkdirAll(filepath.Dir(blocker), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(url, "nowhere"), blocker); err != nil {
+		t.Fatal(err)
+	}
+	out, err := r.stk("push")
+	if err == nil || !strings.Contains(out, "Run stk push again to resume.") {
+		t.Fatalf("push into a broken remote (%v):\n%s", err, out)
+	}
+	if hash, _ := refs.New(url).Read(refs.BranchRef("main")); hash != "" {
+		t.Errorf("failed push moved the remote branch to %s", hash)
+	}
+	if !r.exists(".stk/push_journal") {
+		t.Fatal("failed push left no journal")
+	}
+
+	if err := os.Remove(blocker); err != nil {
+		t.Fatal(err)
+	}
+	out = r.run("push")
+	for _, want := range []string{
+		"Resuming an interrupted push; 3 objects were already uploaded",
+		"Uploading 1 of 4 objects for 1 commits",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("resumed push does not say %q:\n%s", want, out)
+		}
+	}
+	if hash, _ := refs.New(url).Read(refs.BranchRef("main")); hash != r.head() {
+		t.Errorf("remote main = %q after the resumed push, want %s", hash, r.head())
+	}
+	if r.exists(".stk/push_journal") {
+		t.Error("journal left behind after a complete push")
+	}
+	c := cloneTestRepo(t, url)
+	if got := c.read("b.txt"); got != "b\n" {
+		t.Errorf("b.txt = %q in a clone of the resumed push", got)
+	}
+}
+
+func TestPartialClonePushesCachedChunks(t *testing.T) {
+	url := t.TempDir()
+	a := newTestRepo(t)
+	a.writeModel("models/m.safetensors", 1, "w")
+	a.commit("one")
+	a.run("remote", "add", "origin", url)
+	a.run("push", "-u")
+
+	// The chunks of a partial clone live in its cache, and a push to a
+	// remote that lacks them sends them from there.
+	b := cloneTestRepo(t, url, "--filter=models:none")
+	mirror := t.TempDir()
+	b.run("remote", "add", "mirror", mirror)
+	b.run("push", "mirror")
+
+	c := cloneTestRepo(t, mirror)
+	if got := c.modelValue("models/m.safetensors", "w"); got != 1 {
+		t.Errorf("model pushed from a partial clone has w = %g", got)
+	}
+}
@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"fmt"
+	"sdk/pkg/refs"
+	"strings"
+)
+
+// setUpstream records <remoteName>/<merge> as the upstream of the local
+// branch, so that push, pull, status and branch -vv default to it.
+func setUpstream(branch, remoteName, merge string) error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if config.Branches == nil {
+		config.Branches = make(map[string]BranchConfig)
+	}
+	config.Branches[refs.Short(branch)] = BranchConfig{Remote: remoteName, Merge: refs.BranchRef(refs.Short(merge))}
+	return saveConfig(config)
+}
+
+// unsetUpstream forgets the upstream of branch.
+func unsetUpstream(branch string) error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if _, ok := config.Branches[refs.Short(branch)]; !ok {
+		return fmt.Errorf("branch '%s' has no upstream information", refs.Short(branch))
+	}
+	delete(config.Branches, refs.Short(branch))
+	return saveConfig(config)
+}
+
+// moveUpstream carries the upstream of a renamed branch over to its new
+// name; an empty newName drops it along with a deleted branch.
+func moveUpstream(oldName, newName string) error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	upstream, ok := config.Branches[oldName]
+	if !ok {
+		return nil
+	}
+	delete(config.Branches, oldName)
+	if newName != "" {
+		config.Branches[newName] = upstream
+	}
+	return saveConfig(config)
+}
+
+// parseUpstream splits a remote-tracking branch such as origin/main or
+// refs/remotes/origin/main into the remote and the branch on it. The
+// remote-tracking ref must exist.
+func parseUpstream(name string) (string, string, error) {
+	short := strings.TrimPrefix(name, refs.RemotesPrefix)
+	remotes, err := loadRemotes()
+	if err != nil {
+		return "", "", err
+	}
+	// Remote names cannot contain a slash, so the first segment is the
+	// remote.
+	remoteName, branch, _ := strings.Cut(short, "/")
+	if _, ok := remotes[remoteName]; !ok || branch == "" {
+		return "", "", fmt.Errorf("'%s' is not a remote-tracking branch (expected <remote>/<branch>)", name)
+	}
+	if !refStore().Exists(trackingRef(remoteName, branch)) {
+		return "", "", fmt.Errorf("the requested upstream branch '%s' does not exist; fetch it from %s first", short, remoteName)
+	}
+	return remoteName, branch, nil
+}
+
+// aheadBehind counts the commits reachable from local but not from
+// upstream, and the other way round.
+func aheadBehind(local, upstream string) (int, int) {
+	fromLocal := reachableCommits(local)
+	fromUpstream := reachableCommits(upstream)
+	ahead, behind := 0, 0
+	for hash := range fromLocal {
+		if !fromUpstream[hash] {
+			ahead++
+		}
+	}
+	for hash := range fromUpstream {
+		if !fromLocal[hash] {
+			behind++
+		}
+	}
+	return ahead, behind
+}
+
+// upstreamState describes how branch relates to its upstream. It returns
+// the short upstream name ("" when none is configured), whether the
+// remote-tracking ref still exists, and the ahead and behind counts.
+func upstreamState(branch string) (string, bool, int, int) {
+	upstream, err := upstreamRef(refs.Short(branch))
+	if err != nil {
+		return "", false, 0, 0
+	}
+	store := refStore()
+	theirs, err := store.Read(upstream)
+	if err != nil {
+		return refs.Short(upstream), false, 0, 0
+	}
+	ours, err := store.Read(branch)
+	if err != nil {
+		return refs.Short(upstream), true, 0, 0
+	}
+	ahead, behind := aheadBehind(ours, theirs)
+	return refs.Short(upstream), true, ahead, behind
+}
+
+// trackingSummary is the line stk status prints about the upstream of
+// branch, or "" when it has none.
+func trackingSummary(branch string) string {
+	upstream, exists, ahead, behind := upstreamState(branch)
+	switch {
+	case upstream == "":
+		return ""
+	case !exists:
+		return fmt.Sprintf("Your branch is based on '%s', but the upstream is gone.", upstream)
+	case ahead > 0 && behind > 0:
+		return fmt.Sprintf("Your branch and '%s' have diverged,\nand have %d and %d different commits each, respectively.", upstream, ahead, behind)
+	case ahead > 0:
+		return fmt.Sprintf("Your branch is ahead of '%s' by %s.", upstream, pluralCommits(ahead))
+	case behind > 0:
+		return fmt.Sprintf("Your branch is behind '%s' by %s, and can be fast-forwarded.", upstream, pluralCommits(behind))
+	default:
+		return fmt.Sprintf("Your branch is up to date with '%s'.", upstream)
+	}
+}
+
+// trackingLabel is the bracketed upstream column of stk branch -vv, e.g.
+// "[origin/main: ahead 1, behind 2]", or "" when branch has no upstream.
+func trackingLabel(branch string) string {
+	upstream, exists, ahead, behind := upstreamState(branch)
+	switch {
+	case upstream == "":
+		return ""
+	case !exists:
+		return "[" + upstream + ": gone]"
+	case ahead > 0 && behind > 0:
+		return fmt.Sprintf("[%s: ahead %d, behind %d]", upstream, ahead, behind)
+	case ahead > 0:
+		return fmt.Sprintf("[%s: ahead %d]", upstream, ahead)
+	case behind > 0:
+		return fmt.Sprintf("[%s: behind %d]", upstream, behind)
+	default:
+		return "[" + upstream + "]"
+	}
+}
+
+func pluralCommits(n int) string {
+	if n == 1 {
+		return "1 commit"
+	}
+	return fmt.Sprintf("%d commits", n)
+}
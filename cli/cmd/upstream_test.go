@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"path/filepath"
+	"sdk/pkg/refs"
+	"strings"
+	"testing"
+)
+
+func TestAheadBehind(t *testing.T) {
+	url := t.TempDir()
+	a := newTestRepo(t)
+	a.write("a.txt", "a\n")
+	a.commit("one")
+	a.run("remote", "add", "origin", url)
+	a.run("push", "-u")
+	b := cloneTestRepo(t, url)
+
+	check := func(status, label string) {
+		t.Helper()
+		if out := b.run("status"); !strings.Contains(out, status) {
+			t.Errorf("status does not say %q:\n%s", status, out)
+		}
+		if out := b.run("branch", "-vv"); !strings.Contains(out, label) {
+			t.Errorf("branch -vv does not show %q:\n%s", label, out)
+		}
+	}
+	check("Your branch is up to date with 'origin/main'.", "[origin/main]")
+
+	a.write("a.txt", "a2\n")
+	a.commit("two")
+	a.run("push")
+	b.run("fetch")
+	check("Your branch is behind 'origin/main' by 1 commit, and can be fast-forwarded.", "[origin/main: behind 1]")
+
+	b.write("b.txt", "b\n")
+	b.commit("b one")
+	b.write("b.txt", "b2\n")
+	b.commit("b two")
+	check("Your branch and 'origin/main' have diverged,\nand have 2 and 1 different commits each, respectively.", "[origin/main: ahead 2, behind 1]")
+
+	// The merge commit counts, and the merged commit no longer does.
+	b.run("pull")
+	check("Your branch is ahead of 'origin/main' by 3 commits.", "[origin/main: ahead 3]")
+	b.run("push")
+	check("Your branch is up to date with 'origin/main'.", "[origin/main]")
+
+	// Branches without an upstream show none; one can be set and unset.
+	b.run("checkout", "-b", "feat")
+	if out := b.run("status"); strings.Contains(out, "origin/") {
+		t.Errorf("status of a branch without an upstream:\n%s", out)
+	}
+	if out, err := b.stk("branch", "--set-upstream-to", "origin/nope"); err == nil {
+		t.Errorf("set an upstream that was never fetched:\n%s", out)
+	}
+	b.run("branch", "--set-upstream-to", "origin/main")
+	b.write("f.txt", "f\n")
+	b.commit("feat")
+	check("Your branch is ahead of 'origin/main' by 1 commit.", "[origin/main: ahead 1]")
+	b.run("branch", "--unset-upstream")
+	if out := b.run("branch", "-vv"); !strings.Contains(out, "* feat "+b.head()[:12]+" feat\n") {
+		t.Errorf("branch -vv after --unset-upstream:\n%s", out)
+	}
+
+	b.run("branch", "--set-upstream-to", "origin/main", "feat")
+	if err := refs.New(filepath.Join(b.dir, ".stk")).Delete(refs.RemotesPrefix + "origin/main"); err != nil {
+		t.Fatal(err)
+	}
+	check("Your branch is based on 'origin/main', but the upstream is gone.", "[origin/main: gone]")
+}
@@ -0,0 +1,447 @@
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sdk/pkg/hasher"
+	"sdk/pkg/refs"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// worktreesDir is the directory of the common .stk holding the state of
+// every linked worktree, one subdirectory per worktree.
+const worktreesDir = "worktrees"
+
+// worktreePathFile records, inside a linked worktree's state directory,
+// where the worktree itself lives.
+const worktreePathFile = "worktree"
+
+var (
+	worktreeNewBranch string
+	worktreeForce     bool
+)
+
+var worktreeCmd = &cobra.Command{
+	Use:   "worktree",
+	Short: "Manage multiple working directories attached to one repository",
+	Long: `A worktree is an extra working directory with its own HEAD and index that
+shares the objects, refs and configuration of the repository it was added
+from, so several branches can be checked out at once without copying the
+object store. A branch can only be checked out in one worktree at a time.
+Example:
+  stk worktree add ../eval eval-run      # check out eval-run in ../eval
+  stk worktree add -b sweep ../sweep     # create branch sweep from HEAD
+  stk worktree list
+  stk worktree remove ../eval
+  stk worktree prune                     # forget worktrees deleted by hand`,
+}
+
+var worktreeAddCmd = &cobra.Command{
+	Use:   "add <path> [<branch>]",
+	Short: "Create a worktree with a branch checked out",
+	Args:  cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		branch := ""
+		if len(args) == 2 {
+			branch = args[1]
+		}
+		runWorktreeAdd(args[0], branch)
+	},
+}
+
+var worktreeListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the worktrees of the repository",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runWorktreeList()
+	},
+}
+
+var worktreeRemoveCmd = &cobra.Command{
+	Use:   "remove <path>",
+	Short: "Delete a worktree and its working directory",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runWorktreeRemove(args[0])
+	},
+}
+
+var worktreePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Forget worktrees whose directory no longer exists",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runWorktreePrune()
+	},
+}
+
+// worktree is one working directory of the repository. The main worktree
+// has no name and keeps its state in the common directory.
+type worktree struct {
+	name     string
+	path     string
+	stateDir string
+	// stale says why a linked worktree is gone, e.g. its directory was
+	// deleted by hand, and is empty while it exists.
+	stale string
+}
+
+func (w worktree) head() (string, string, error) {
+	return refs.NewWorktree(w.stateDir, commonDir()).Head()
+}
+
+// listWorktrees returns the main worktree followed by every linked one.
+func listWorktrees() ([]worktree, error) {
+	common, err := filepath.Abs(commonDir())
+	if err != nil {
+		return nil, err
+	}
+	worktrees := []worktree{{path: filepath.Dir(common), stateDir: common}}
+
+	entries, err := os.ReadDir(filepath.Join(common, worktreesDir))
+	if os.IsNotExist(err) {
+		return worktrees, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		wt := worktree{name: entry.Name(), stateDir: filepath.Join(common, worktreesDir, entry.Name())}
+		data, err := os.ReadFile(filepath.Join(wt.stateDir, worktreePathFile))
+		switch {
+		case os.IsNotExist(err):
+			wt.stale = "its " + worktreePathFile + " file is missing"
+		case err != nil:
+			return nil, err
+		default:
+			wt.path = strings.TrimSpace(string(data))
+			if !fileExists(wt.path) {
+				wt.stale = "its directory is missing"
+			}
+		}
+		worktrees = append(worktrees, wt)
+	}
+	return worktrees, nil
+}
+
+// branchCheckedOutElsewhere returns the path of another worktree that has
+// branchRef checked out, if any. Stale worktrees hold no branch.
+func branchCheckedOutElsewhere(branchRef string) (string, bool) {
+	worktrees, err := listWorktrees()
+	if err != nil {
+		log.Fatal("Error reading worktrees: ", err)
+	}
+	current, err := filepath.Abs(stkDir())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, w := range worktrees {
+		if w.stateDir == current || w.stale != "" {
+			continue
+		}
+		head, _, err := w.head()
+		if err == nil && head == branchRef {
+			return w.path, true
+		}
+	}
+	return "", false
+}
+
+func runWorktreeAdd(path, branch string) {
+	store := refStore()
+	if worktreeNewBranch != "" {
+		if branch != "" {
+			log.Fatal("Usage: stk worktree add -b <new-branch> <path>")
+		}
+		if err := refs.ValidateName(worktreeNewBranch); err != nil {
+			log.Fatal(err)
+		}
+		if store.Exists(refs.BranchRef(worktreeNewBranch)) {
+			log.Fatalf("Branch '%s' already exists.", worktreeNewBranch)
+		}
+		branch = worktreeNewBranch
+	} else if branch == "" {
+		log.Fatal("Usage: stk worktree add <path> <branch>")
+	}
+
+	branchRef := refs.BranchRef(branch)
+	commit := headCommit()
+	if worktreeNewBranch == "" {
+		hash, err := store.Read(branchRef)
+		if err != nil {
+			log.Fatalf("Branch '%s' does not exist.", branch)
+		}
+		commit = hash
+		if other, ok := branchCheckedOutElsewhere(branchRef); ok {
+			log.Fatalf("Branch '%s' is already checked out at '%s'.", branch, other)
+		}
+		if currentBranch() == branchRef {
+			log.Fatalf("Branch '%s' is already checked out here.", branch)
+		}
+	}
+	if commit == "" {
+		log.Fatal("Cannot add a worktree: the current branch has no commits yet.")
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if entries, err := os.ReadDir(absPath); err == nil && len(entries) > 0 {
+		log.Fatalf("'%s' already exists and is not empty.", path)
+	}
+	absCommon, err := filepath.Abs(commonDir())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	name := filepath.Base(absPath)
+	stateDir := filepath.Join(absCommon, worktreesDir, name)
+	for i := 1; fileExists(stateDir); i++ {
+		stateDir = filepath.Join(absCommon, worktreesDir, name+strconv.Itoa(i))
+	}
+
+	if worktreeNewBranch != "" {
+		if err := store.Write(branchRef, commit); err != nil {
+			log.Fatal("Error creating branch: ", err)
+		}
+	}
+
+	files := map[string]string{
+		filepath.Join(stateDir, headFile):         "ref: " + branchRef + "\n",
+		filepath.Join(stateDir, commonDirFile):    absCommon + "\n",
+		filepath.Join(stateDir, worktreePathFile): absPath + "\n",
+		filepath.Join(stateDir, indexFile):        "",
+		filepath.Join(stateDir, modelIndexFile):   "",
+		filepath.Join(absPath, ".stk"):            stkDirPrefix + stateDir + "\n",
+	}
+	for file, content := range files {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			log.Fatal("Error creating worktree: ", err)
+		}
+	}
+
+	leave, err := enterWorktree(absPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer leave()
+
+	treeHash, err := commitTree(commit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := checkoutTree("", treeHash); err != nil {
+		log.Fatal("Error populating worktree: ", err)
+	}
+	if err := writeIndexFromTree(treeHash); err != nil {
+		log.Fatal("Error writing index: ", err)
+	}
+
+	fmt.Printf("Preparing worktree at '%s' (branch '%s')\n", path, branch)
+}
+
+func runWorktreeList() {
+	worktrees, err := listWorktrees()
+	if err != nil {
+		log.Fatal("Error reading worktrees: ", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, wt := range worktrees {
+		if wt.stale != "" {
+			path := wt.path
+			if path == "" {
+				path = wt.stateDir
+			}
+			fmt.Fprintf(w, "%s\t\t(prunable: %s)\n", path, wt.stale)
+			continue
+		}
+		head, hash, err := wt.head()
+		if err != nil {
+			fmt.Fprintf(w, "%s\t\t(unreadable HEAD: %v)\n", wt.path, err)
+			continue
+		}
+		where := "(detached HEAD)"
+		if head != "" {
+			where = "[" + refs.Short(head) + "]"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", wt.path, shortHash(hash), where)
+	}
+	w.Flush()
+}
+
+func runWorktreeRemove(path string) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	worktrees, err := listWorktrees()
+	if err != nil {
+		log.Fatal("Error reading worktrees: ", err)
+	}
+
+	var target *worktree
+	for i, wt := range worktrees {
+		if i > 0 && wt.path == absPath {
+			target = &worktrees[i]
+		}
+	}
+	if target == nil {
+		if absPath == worktrees[0].path {
+			log.Fatal("The main worktree cannot be removed.")
+		}
+		log.Fatalf("'%s' is not a worktree.", path)
+	}
+
+	if worktreeForce {
+		if err := os.RemoveAll(absPath); err != nil {
+			log.Fatal("Error removing worktree: ", err)
+		}
+	} else if fileExists(absPath) {
+		changed, untracked, err := worktreeChanges(absPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(changed) > 0 {
+			fmt.Printf("error: '%s' has local changes:\n", path)
+			for _, file := range changed {
+				fmt.Println("\t" + file)
+			}
+			fmt.Println("Use --force to remove it anyway.")
+			os.Exit(1)
+		}
+		if len(untracked) > 0 {
+			fmt.Printf("error: '%s' has untracked files:\n", path)
+			for _, file := range untracked {
+				fmt.Println("\t" + file)
+			}
+			fmt.Println("Use --force to remove it anyway.")
+			os.Exit(1)
+		}
+		if err := removeWorktreeFiles(absPath); err != nil {
+			log.Fatal("Error removing worktree: ", err)
+		}
+	}
+	if err := os.RemoveAll(target.stateDir); err != nil {
+		log.Fatal("Error removing worktree state: ", err)
+	}
+	fmt.Printf("Removed worktree '%s'\n", path)
+}
+
+// runWorktreePrune deletes the state of every stale linked worktree.
+func runWorktreePrune() {
+	worktrees, err := listWorktrees()
+	if err != nil {
+		log.Fatal("Error reading worktrees: ", err)
+	}
+	for _, wt := range worktrees[1:] {
+		if wt.stale == "" {
+			continue
+		}
+		if err := os.RemoveAll(wt.stateDir); err != nil {
+			log.Fatal("Error removing worktree state: ", err)
+		}
+		fmt.Printf("Removing %s/%s: %s\n", worktreesDir, wt.name, wt.stale)
+	}
+}
+
+// worktreeChanges lists the tracked files of the worktree at dir whose
+// content differs from its index, and the files its index does not track.
+func worktreeChanges(dir string) ([]string, []string, error) {
+	leave, err := enterWorktree(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer leave()
+
+	entries, err := readIndexEntries()
+	if err != nil {
+		return nil, nil, err
+	}
+	var changed []string
+	for path, entry := range entries {
+		if entry.SkipWorktree || entry.Hash == "" {
+			continue
+		}
+		if !fileExists(path) || hasher.HashFile(path) != entry.Hash {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+
+	var untracked []string
+	err = filepath.WalkDir(".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && path != "." && d.Name()[0] == '.' {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || skipDotfile(path, d) {
+			return nil
+		}
+		if _, ok := entries[filepath.ToSlash(path)]; !ok {
+			untracked = append(untracked, path)
+		}
+		return nil
+	})
+	return changed, untracked, err
+}
+
+// removeWorktreeFiles deletes the tracked files of the worktree at dir and
+// its .stk pointer, then dir itself if nothing else is left in it.
+func removeWorktreeFiles(dir string) error {
+	if err := removeTrackedFiles(dir); err != nil {
+		return err
+	}
+	// Files the removal did not cover, such as dotfiles, keep the directory.
+	os.Remove(dir)
+	return nil
+}
+
+func removeTrackedFiles(dir string) error {
+	leave, err := enterWorktree(dir)
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	entries, err := readIndexEntries()
+	if err != nil {
+		return err
+	}
+	for path := range entries {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		removeEmptyParents(path)
+	}
+	if err := os.Remove(".stk"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(worktreeCmd)
+	worktreeCmd.AddCommand(worktreeAddCmd, worktreeListCmd, worktreeRemoveCmd, worktreePruneCmd)
+	worktreeAddCmd.Flags().StringVarP(&worktreeNewBranch, "branch", "b", "", "Create a new branch from HEAD and check it out")
+	worktreeRemoveCmd.Flags().BoolVarP(&worktreeForce, "force", "f", false, "Remove the worktree even if it has local changes or untracked files")
+}
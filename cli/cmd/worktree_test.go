@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWorktreeRemoveKeepsUntrackedFiles(t *testing.T) {
+	r := newTestRepo(t)
+	r.write("a.txt", "a\n")
+	r.commit("one")
+	wt := filepath.Join(t.TempDir(), "wt")
+	r.run("worktree", "add", "-b", "feat", wt)
+
+	notes := filepath.Join(wt, "notes.txt")
+	if err := os.WriteFile(notes, []byte("notes\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := r.stk("worktree", "remove", wt)
+	if err == nil {
+		t.Fatalf("remove succeeded with an untracked file:\n%s", out)
+	}
+	if !strings.Contains(out, "untracked files") || !strings.Contains(out, "notes.txt") {
+		t.Errorf("remove output does not name the file:\n%s", out)
+	}
+	if data, err := os.ReadFile(notes); err != nil || string(data) != "notes\n" {
+		t.Errorf("notes.txt = %q, %v; want it kept", data, err)
+	}
+	if out := r.run("worktree", "list"); !strings.Contains(out, wt) {
+		t.Errorf("worktree is no longer listed:\n%s", out)
+	}
+
+	if err := os.Remove(notes); err != nil {
+		t.Fatal(err)
+	}
+	r.run("worktree", "remove", wt)
+	if _, err := os.Stat(wt); !os.IsNotExist(err) {
+		t.Errorf("worktree directory still exists: %v", err)
+	}
+}
+
+func TestWorktreeRemoveKeepsUnknownDotfiles(t *testing.T) {
+	r := newTestRepo(t)
+	r.write("dir/a.txt", "a\n")
+	r.commit("one")
+	wt := filepath.Join(t.TempDir(), "wt")
+	r.run("worktree", "add", "-b", "feat", wt)
+
+	env := filepath.Join(wt, ".env")
+	if err := os.WriteFile(env, []byte("KEY=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r.run("worktree", "remove", wt)
+	if _, err := os.Stat(env); err != nil {
+		t.Errorf(".env was deleted: %v", err)
+	}
+	for _, gone := range []string{"dir", ".stk"} {
+		if _, err := os.Stat(filepath.Join(wt, gone)); !os.IsNotExist(err) {
+			t.Errorf("%s still exists: %v", gone, err)
+		}
+	}
+	if out := r.run("worktree", "list"); strings.Contains(out, wt) {
+		t.Errorf("worktree is still listed:\n%s", out)
+	}
+}
+
+func TestWorktreePrune(t *testing.T) {
+	r := newTestRepo(t)
+	r.write("a.txt", "a\n")
+	r.commit("one")
+	deleted := filepath.Join(t.TempDir(), "deleted")
+	broken := filepath.Join(t.TempDir(), "broken")
+	kept := filepath.Join(t.TempDir(), "kept")
+	r.run("worktree", "add", "-b", "feat", deleted)
+	r.run("worktree", "add", "-b", "other", broken)
+	r.run("worktree", "add", "-b", "busy", kept)
+
+	// One directory deleted by hand, one state dir lacking its worktree file.
+	if err := os.RemoveAll(deleted); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(r.dir, ".stk", "worktrees", "broken", "worktree")); err != nil {
+		t.Fatal(err)
+	}
+
+	out := r.run("worktree", "list")
+	if strings.Count(out, "prunable") != 2 || !strings.Contains(out, deleted) || !strings.Contains(out, "[busy]") {
+		t.Errorf("worktree list does not report the stale worktrees:\n%s", out)
+	}
+	r.run("checkout", "feat")
+	r.run("checkout", "other")
+	if out, err := r.stk("checkout", "busy"); err == nil || !strings.Contains(out, "already checked out at") {
+		t.Errorf("checkout of a branch used by a live worktree (%v):\n%s", err, out)
+	}
+
+	out = r.run("worktree", "prune")
+	if !strings.Contains(out, "worktrees/deleted") || !strings.Contains(out, "worktrees/broken") || strings.Contains(out, "kept") {
+		t.Errorf("prune output:\n%s", out)
+	}
+	out = r.run("worktree", "list")
+	if strings.Contains(out, "prunable") || !strings.Contains(out, kept) {
+		t.Errorf("worktree list after prune:\n%s", out)
+	}
+	r.run("worktree", "add", deleted, "feat")
+}
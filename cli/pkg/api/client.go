@@ -8,6 +8,17 @@ import (
 	"net/http"
 )
 
+// StatusError is returned by CallAPI when the server answers with an error
+// status.
+type StatusError struct {
+	StatusCode int
+	Body       []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("API error: %s", e.Body)
+}
+
 func CallAPI(method, url, token string, payload any) ([]byte, error) {
 	var bodyReader io.Reader
 	if payload != nil {
@@ -39,7 +50,7 @@ func CallAPI(method, url, token string, payload any) ([]byte, error) {
 	}
 
 	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("API error: %s", respBody)
+		return nil, &StatusError{StatusCode: resp.StatusCode, Body: respBody}
 	}
 
 	return respBody, nil
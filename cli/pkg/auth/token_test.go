@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var secret = []byte("test secret")
+
+// sign builds a token with the given header algorithm and claims.
+func sign(t *testing.T, key []byte, alg string, claims any) string {
+	t.Helper()
+	segment := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	unsigned := segment(map[string]string{"alg": alg, "typ": "JWT"}) + "." + segment(claims)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyToken(t *testing.T) {
+	now := time.Now().Unix()
+	valid := Claims{Subject: "user-1", Type: "cli", IssuedAt: now, ExpiresAt: now + 3600}
+	claims, err := VerifyToken(sign(t, secret, "HS256", valid), secret)
+	if err != nil || claims != valid {
+		t.Fatalf("VerifyToken = %+v, %v; want %+v", claims, err, valid)
+	}
+	noExpiry := Claims{Subject: "user-1", Type: "cli"}
+	if _, err := VerifyToken(sign(t, secret, "HS256", noExpiry), secret); err != nil {
+		t.Errorf("token without expiry: %v", err)
+	}
+
+	token := sign(t, secret, "HS256", valid)
+	parts := strings.Split(token, ".")
+	// The claims of another user, under the signature of the valid token.
+	forged := strings.Split(sign(t, secret, "HS256", Claims{Subject: "admin", Type: "cli"}), ".")[1]
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"session token", sign(t, secret, "HS256", Claims{Subject: "user-1", Type: "session", ExpiresAt: now + 3600})},
+		{"no type", sign(t, secret, "HS256", Claims{Subject: "user-1", ExpiresAt: now + 3600})},
+		{"type not a string", sign(t, secret, "HS256", map[string]any{"sub": "user-1", "type": 1})},
+		{"no subject", sign(t, secret, "HS256", Claims{Type: "cli", ExpiresAt: now + 3600})},
+		{"expired", sign(t, secret, "HS256", Claims{Subject: "user-1", Type: "cli", ExpiresAt: now - 1})},
+		{"other secret", sign(t, []byte("other"), "HS256", valid)},
+		{"alg none", sign(t, secret, "none", valid)},
+		{"alg HS512", sign(t, secret, "HS512", valid)},
+		{"tampered signature", token[:len(token)-1] + "x"},
+		{"tampered claims", parts[0] + "." + forged + "." + parts[2]},
+		{"two segments", parts[0] + "." + parts[1]},
+		{"empty", ""},
+		{"garbage", "a.b.c"},
+	}
+	for _, tt := range tests {
+		if _, err := VerifyToken(tt.token, secret); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: VerifyToken = %v, want ErrInvalidToken", tt.name, err)
+		}
+	}
+}
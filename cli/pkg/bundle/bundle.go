@@ -0,0 +1,136 @@
+// Package bundle reads and writes bundle files, which carry part of the
+// history of a repository to machines that cannot reach its remotes. A
+// bundle is a header followed by a pack (see pkg/pack):
+//
+//	# stk bundle v1
+//	-<commit> <subject>
+//	<commit> <ref>
+//	blake3 <hex digest of the lines above>
+//	STKPACK 1
+//	…
+//
+// with one "-" line per prerequisite, a commit the pack leaves out that the
+// importing repository must already have, and one line per ref, such as
+// refs/heads/main, the bundle carries. The header checksum and the pack's
+// own cover the whole file.
+package bundle
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sdk/pkg/refs"
+	"strings"
+
+	"github.com/zeebo/blake3"
+)
+
+const signature = "# stk bundle v1"
+
+// Extension is the file name extension bundles are given.
+const Extension = ".stkb"
+
+// ErrCorrupt is returned for a header that is malformed or does not match
+// its checksum.
+var ErrCorrupt = errors.New("corrupt bundle")
+
+// Prerequisite is a commit the importing repository must have.
+type Prerequisite struct {
+	Commit string
+	// Subject is the first line of the commit message, for people
+	// reading the header.
+	Subject string
+}
+
+// Header describes what a bundle holds.
+type Header struct {
+	Prerequisites []Prerequisite
+	Refs          []refs.Ref
+}
+
+// WriteHeader writes h to w. The pack must follow.
+func WriteHeader(w io.Writer, h Header) error {
+	var b strings.Builder
+	b.WriteString(signature + "\n")
+	for _, p := range h.Prerequisites {
+		// The subject is informational; keep it on its line.
+		subject := strings.Join(strings.Fields(p.Subject), " ")
+		fmt.Fprintf(&b, "-%s %s\n", p.Commit, subject)
+	}
+	for _, ref := range h.Refs {
+		if err := validateRef(ref); err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "%s %s\n", ref.Hash, ref.Name)
+	}
+	sum := blake3.Sum256([]byte(b.String()))
+	fmt.Fprintf(&b, "blake3 %s\n", hex.EncodeToString(sum[:]))
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ReadHeader reads and checks the header of a bundle, leaving r at the
+// start of the pack.
+func ReadHeader(r *bufio.Reader) (Header, error) {
+	var h Header
+	sum := blake3.New()
+	for first := true; ; first = false {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				return h, fmt.Errorf("%w: header is truncated", ErrCorrupt)
+			}
+			return h, err
+		}
+		text := strings.TrimSuffix(line, "\n")
+		if first {
+			if text != signature {
+				return h, fmt.Errorf("%w: not a bundle", ErrCorrupt)
+			}
+			sum.Write([]byte(line))
+			continue
+		}
+
+		if digest, ok := strings.CutPrefix(text, "blake3 "); ok {
+			if digest != hex.EncodeToString(sum.Sum(nil)) {
+				return h, fmt.Errorf("%w: header checksum mismatch", ErrCorrupt)
+			}
+			return h, nil
+		}
+		sum.Write([]byte(line))
+
+		if rest, ok := strings.CutPrefix(text, "-"); ok {
+			commit, subject, _ := strings.Cut(rest, " ")
+			if !isHash(commit) {
+				return h, fmt.Errorf("%w: bad prerequisite %q", ErrCorrupt, text)
+			}
+			h.Prerequisites = append(h.Prerequisites, Prerequisite{Commit: commit, Subject: subject})
+			continue
+		}
+		hash, name, _ := strings.Cut(text, " ")
+		ref := refs.Ref{Name: name, Hash: hash}
+		if err := validateRef(ref); err != nil {
+			return h, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		h.Refs = append(h.Refs, ref)
+	}
+}
+
+func validateRef(ref refs.Ref) error {
+	name, ok := strings.CutPrefix(ref.Name, "refs/")
+	if !ok || !isHash(ref.Hash) {
+		return fmt.Errorf("invalid bundle ref %q", ref.Name)
+	}
+	return refs.ValidateName(name)
+}
+
+// isHash reports whether s is a full object hash.
+func isHash(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil && strings.ToLower(s) == s
+}
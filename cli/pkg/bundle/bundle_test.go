@@ -0,0 +1,110 @@
+package bundle
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"sdk/pkg/refs"
+	"strings"
+	"testing"
+)
+
+var (
+	hashA = strings.Repeat("a", 64)
+	hashB = strings.Repeat("b", 64)
+	hashC = strings.Repeat("c", 64)
+)
+
+func writeHeader(t *testing.T, h Header) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := WriteHeader(&buf, h); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+	h := Header{
+		Prerequisites: []Prerequisite{{Commit: hashA, Subject: "Tune the  learning rate\n\twith a scheduler"}},
+		Refs: []refs.Ref{
+			{Name: "refs/heads/main", Hash: hashB},
+			{Name: "refs/tags/v1.0", Hash: hashC},
+		},
+	}
+	data := append(writeHeader(t, h), "STKPACK 1\n"...)
+
+	r := bufio.NewReader(bytes.NewReader(data))
+	got, err := ReadHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The subject is folded onto one line.
+	h.Prerequisites[0].Subject = "Tune the learning rate with a scheduler"
+	if !reflect.DeepEqual(got, h) {
+		t.Errorf("ReadHeader = %+v, want %+v", got, h)
+	}
+	rest, _ := io.ReadAll(r)
+	if string(rest) != "STKPACK 1\n" {
+		t.Errorf("ReadHeader left %q unread, want the pack", rest)
+	}
+}
+
+func TestHeaderWithoutPrerequisites(t *testing.T) {
+	h := Header{Refs: []refs.Ref{{Name: "refs/heads/main", Hash: hashA}}}
+	got, err := ReadHeader(bufio.NewReader(bytes.NewReader(writeHeader(t, h))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Prerequisites) != 0 || !reflect.DeepEqual(got.Refs, h.Refs) {
+		t.Errorf("ReadHeader = %+v, want %+v", got, h)
+	}
+}
+
+func TestWriteHeaderRejectsInvalidRefs(t *testing.T) {
+	for _, ref := range []refs.Ref{
+		{Name: "main", Hash: hashA},
+		{Name: "refs/heads/a..b", Hash: hashA},
+		{Name: "refs/heads/main", Hash: "abc"},
+		{Name: "refs/heads/main", Hash: strings.ToUpper(hashA)},
+	} {
+		if err := WriteHeader(io.Discard, Header{Refs: []refs.Ref{ref}}); err == nil {
+			t.Errorf("WriteHeader accepted %+v", ref)
+		}
+	}
+}
+
+func TestReadHeaderCorrupt(t *testing.T) {
+	valid := string(writeHeader(t, Header{
+		Prerequisites: []Prerequisite{{Commit: hashA, Subject: "base"}},
+		Refs:          []refs.Ref{{Name: "refs/heads/main", Hash: hashB}},
+	}))
+	replace := func(old, new string) string {
+		if !strings.Contains(valid, old) {
+			t.Fatalf("header does not contain %q", old)
+		}
+		return strings.Replace(valid, old, new, 1)
+	}
+	tests := map[string]string{
+		"not a bundle":     replace(signature, "# stk bundle v2"),
+		"empty":            "",
+		"truncated":        valid[:strings.Index(valid, "blake3")],
+		"edited ref":       replace("refs/heads/main", "refs/heads/evil"),
+		"edited hash":      replace(hashB, hashC),
+		"edited subject":   replace(" base\n", " other\n"),
+		"dropped prereq":   replace("-"+hashA+" base\n", ""),
+		"bad checksum":     replace("blake3 ", "blake3 0"),
+		"bad prerequisite": replace("-"+hashA, "-xyz"),
+		"invalid ref":      replace(hashB+" refs/heads/main", hashB+" main"),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ReadHeader(bufio.NewReader(strings.NewReader(data)))
+			if !errors.Is(err, ErrCorrupt) {
+				t.Errorf("ReadHeader = %v, want ErrCorrupt", err)
+			}
+		})
+	}
+}
@@ -134,3 +134,19 @@ func GetDecompressFile(src string) ([]byte, error) {
 	return buf.Bytes(), nil
 
 }
+
+// DecompressData decompresses data held in memory, such as an object
+// downloaded from a remote.
+func DecompressData(data []byte) ([]byte, error) {
+	decoder, err := zstd.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer decoder.Close()
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, decoder); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
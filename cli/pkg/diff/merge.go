@@ -0,0 +1,191 @@
+package diff
+
+import (
+	"bytes"
+)
+
+// maxMergeCells bounds the size of the LCS table built for a text merge.
+// Larger inputs are reported as a whole-file conflict instead.
+const maxMergeCells = 16 * 1024 * 1024
+
+// IsBinary reports whether data looks like binary content that should not
+// be merged line by line.
+func IsBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// Merge3 performs a line-based three-way merge of ours and theirs against
+// their common ancestor base. Regions changed on both sides in different ways
+// are wrapped in conflict markers labelled with oursLabel and theirsLabel.
+// The returned bool is true when at least one conflict was written.
+func Merge3(base, ours, theirs []byte, oursLabel, theirsLabel string) ([]byte, bool) {
+	o := splitLines(base)
+	a := splitLines(ours)
+	b := splitLines(theirs)
+
+	if len(o)*len(a) > maxMergeCells || len(o)*len(b) > maxMergeCells {
+		var out bytes.Buffer
+		writeConflict(&out, a, b, oursLabel, theirsLabel)
+		return out.Bytes(), true
+	}
+
+	matchA := matchLines(o, a)
+	matchB := matchLines(o, b)
+
+	var out bytes.Buffer
+	conflict := false
+	i, ia, ib := 0, 0, 0
+
+	for {
+		// Copy the stable region where both sides still agree with base.
+		for i < len(o) && matchA[i] == ia && matchB[i] == ib {
+			out.Write(o[i])
+			i++
+			ia++
+			ib++
+		}
+		if i == len(o) && ia == len(a) && ib == len(b) {
+			break
+		}
+
+		// Find the next base line kept by both sides.
+		next, nextA, nextB := len(o), len(a), len(b)
+		for j := i; j < len(o); j++ {
+			if matchA[j] >= 0 && matchB[j] >= 0 {
+				next, nextA, nextB = j, matchA[j], matchB[j]
+				break
+			}
+		}
+
+		chunkO, chunkA, chunkB := o[i:next], a[ia:nextA], b[ib:nextB]
+		switch {
+		case equalLines(chunkA, chunkO):
+			writeLines(&out, chunkB)
+		case equalLines(chunkB, chunkO), equalLines(chunkA, chunkB):
+			writeLines(&out, chunkA)
+		default:
+			writeConflict(&out, chunkA, chunkB, oursLabel, theirsLabel)
+			conflict = true
+		}
+
+		i, ia, ib = next, nextA, nextB
+	}
+
+	return out.Bytes(), conflict
+}
+
+// HasConflictMarkers reports whether data still contains the markers written
+// by Merge3.
+func HasConflictMarkers(data []byte) bool {
+	for _, line := range splitLines(data) {
+		if bytes.HasPrefix(line, []byte("<<<<<<< ")) || bytes.HasPrefix(line, []byte(">>>>>>> ")) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitLines(data []byte) [][]byte {
+	if len(data) == 0 {
+		return nil
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// matchLines returns, for every line of base, the index of the line of other
+// it is paired with in a longest common subsequence, or -1.
+func matchLines(base, other [][]byte) []int {
+	n, m := len(base), len(other)
+	table := make([][]int32, n+1)
+	for i := range table {
+		table[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if bytes.Equal(base[i], other[j]) {
+				table[i][j] = table[i+1][j+1] + 1
+			} else if table[i+1][j] >= table[i][j+1] {
+				table[i][j] = table[i+1][j]
+			} else {
+				table[i][j] = table[i][j+1]
+			}
+		}
+	}
+
+	match := make([]int, n)
+	for i := range match {
+		match[i] = -1
+	}
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case bytes.Equal(base[i], other[j]):
+			match[i] = j
+			i++
+			j++
+		case table[i+1][j] >= table[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return match
+}
+
+func equalLines(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func writeLines(out *bytes.Buffer, lines [][]byte) {
+	for _, line := range lines {
+		out.Write(line)
+	}
+}
+
+func writeConflict(out *bytes.Buffer, a, b [][]byte, oursLabel, theirsLabel string) {
+	out.WriteString("<<<<<<< " + oursLabel + "\n")
+	writeSection(out, a)
+	out.WriteString("=======\n")
+	writeSection(out, b)
+	out.WriteString(">>>>>>> " + theirsLabel + "\n")
+}
+
+func writeSection(out *bytes.Buffer, lines [][]byte) {
+	writeLines(out, lines)
+	if len(lines) > 0 && !bytes.HasSuffix(lines[len(lines)-1], []byte("\n")) {
+		out.WriteByte('\n')
+	}
+}
+
+// LineStat counts the lines added and removed between two versions of a
+// text file.
+func LineStat(before, after []byte) (added, removed int) {
+	a := splitLines(before)
+	b := splitLines(after)
+	if len(a)*len(b) > maxMergeCells {
+		return len(b), len(a)
+	}
+
+	kept := 0
+	for _, j := range matchLines(a, b) {
+		if j >= 0 {
+			kept++
+		}
+	}
+	return len(b) - kept, len(a) - kept
+}
@@ -0,0 +1,141 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMerge3(t *testing.T) {
+	tests := []struct {
+		name               string
+		base, ours, theirs string
+		want               string
+		conflict           bool
+	}{
+		{
+			name: "unchanged",
+			base: "a\nb\nc\n", ours: "a\nb\nc\n", theirs: "a\nb\nc\n",
+			want: "a\nb\nc\n",
+		},
+		{
+			name: "changed by them",
+			base: "a\nb\nc\n", ours: "a\nb\nc\n", theirs: "a\nB\nc\n",
+			want: "a\nB\nc\n",
+		},
+		{
+			name: "changed by us",
+			base: "a\nb\nc\n", ours: "a\nb\nC\n", theirs: "a\nb\nc\n",
+			want: "a\nb\nC\n",
+		},
+		{
+			name: "separate regions",
+			base: "a\nb\nc\nd\ne\n", ours: "A\nb\nc\nd\ne\n", theirs: "a\nb\nc\nd\nE\n",
+			want: "A\nb\nc\nd\nE\n",
+		},
+		{
+			name: "same change on both sides",
+			base: "a\nb\nc\n", ours: "a\nx\nc\n", theirs: "a\nx\nc\n",
+			want: "a\nx\nc\n",
+		},
+		{
+			name: "insertion and deletion",
+			base: "a\nb\nc\nd\n", ours: "a\nnew\nb\nc\nd\n", theirs: "a\nb\nc\n",
+			want: "a\nnew\nb\nc\n",
+		},
+		{
+			name: "from empty base",
+			base: "", ours: "a\n", theirs: "",
+			want: "a\n",
+		},
+		{
+			name: "conflicting edits",
+			base: "a\nb\nc\n", ours: "a\nours\nc\n", theirs: "a\ntheirs\nc\n",
+			want:     "a\n<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> feat\nc\n",
+			conflict: true,
+		},
+		{
+			name: "conflicting appends",
+			base: "a\n", ours: "a\nx\n", theirs: "a\ny\n",
+			want:     "a\n<<<<<<< HEAD\nx\n=======\ny\n>>>>>>> feat\n",
+			conflict: true,
+		},
+		{
+			name: "edit against deletion",
+			base: "a\nb\nc\n", ours: "a\nB\nc\n", theirs: "a\nc\n",
+			want:     "a\n<<<<<<< HEAD\nB\n=======\n>>>>>>> feat\nc\n",
+			conflict: true,
+		},
+		{
+			name: "no trailing newline",
+			base: "a\nb", ours: "a\nx", theirs: "a\ny",
+			want:     "a\n<<<<<<< HEAD\nx\n=======\ny\n>>>>>>> feat\n",
+			conflict: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, conflict := Merge3([]byte(tt.base), []byte(tt.ours), []byte(tt.theirs), "HEAD", "feat")
+			if string(got) != tt.want || conflict != tt.conflict {
+				t.Errorf("Merge3 = %q, %v; want %q, %v", got, conflict, tt.want, tt.conflict)
+			}
+			if HasConflictMarkers(got) != tt.conflict {
+				t.Errorf("HasConflictMarkers = %v, want %v", !tt.conflict, tt.conflict)
+			}
+		})
+	}
+}
+
+func TestMerge3TooLarge(t *testing.T) {
+	var base, ours, theirs strings.Builder
+	for i := 0; i*i <= maxMergeCells; i++ {
+		fmt.Fprintf(&base, "line %d\n", i)
+		fmt.Fprintf(&ours, "line %d\n", i)
+		fmt.Fprintf(&theirs, "line %d\n", i)
+	}
+	// A change only theirs made would merge cleanly if the file were small.
+	ours.WriteString("ours\n")
+
+	got, conflict := Merge3([]byte(base.String()), []byte(ours.String()), []byte(theirs.String()), "HEAD", "feat")
+	if !conflict {
+		t.Fatal("Merge3 of an oversized file reported no conflict")
+	}
+	want := "<<<<<<< HEAD\n" + ours.String() + "=======\n" + theirs.String() + ">>>>>>> feat\n"
+	if !bytes.Equal(got, []byte(want)) {
+		t.Errorf("Merge3 of an oversized file did not write a whole-file conflict")
+	}
+}
+
+func TestLineStat(t *testing.T) {
+	tests := []struct {
+		before, after  string
+		added, removed int
+	}{
+		{"", "", 0, 0},
+		{"a\nb\n", "a\nb\n", 0, 0},
+		{"a\nb\n", "a\nc\nb\n", 1, 0},
+		{"a\nb\nc\n", "a\nc\n", 0, 1},
+		{"a\nb\n", "a\nB\n", 1, 1},
+		{"", "a\nb\n", 2, 0},
+	}
+	for _, tt := range tests {
+		added, removed := LineStat([]byte(tt.before), []byte(tt.after))
+		if added != tt.added || removed != tt.removed {
+			t.Errorf("LineStat(%q, %q) = +%d -%d, want +%d -%d", tt.before, tt.after, added, removed, tt.added, tt.removed)
+		}
+	}
+}
+
+func TestIsBinary(t *testing.T) {
+	if IsBinary([]byte("plain text\n")) {
+		t.Error("text reported as binary")
+	}
+	if !IsBinary([]byte("PK\x03\x04\x00\x00")) {
+		t.Error("data with a NUL byte reported as text")
+	}
+	late := append(bytes.Repeat([]byte("a"), 9000), 0)
+	if IsBinary(late) {
+		t.Error("a NUL byte past the first 8000 bytes was considered")
+	}
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+//go:embed scripts/extract_model.py
+var extractPy []byte
+
+//go:embed scripts/rebuild_model.py
+var rebuildPy []byte
+
+// Safetensors stores .safetensors files as they are. It needs no Python.
+type Safetensors struct{}
+
+func (Safetensors) Name() string      { return "safetensors" }
+func (Safetensors) Formats() []string { return []string{"safetensors"} }
+
+func (Safetensors) Detect(path string) (bool, error) {
+	return strings.HasSuffix(path, ".safetensors"), nil
+}
+
+func (s Safetensors) Extract(req ExtractRequest) (*ExtractResult, error) {
+	result := &ExtractResult{
+		Architecture: filepath.Join(req.WorkDir, ArchitectureFile),
+		Metadata:     filepath.Join(req.WorkDir, MetadataFile),
+		Weights:      filepath.Join(req.WorkDir, WeightsFile),
+	}
+	if err := copyFile(req.Path, result.Weights); err != nil {
+		return nil, &Error{Handler: s.Name(), Code: CodeFailed, Message: err.Error(), Path: req.Path}
+	}
+	// Same documents the Python extractor wrote, so manifests keep their
+	// hashes.
+	if err := writeJSON(result.Metadata, map[string]string{"info": "direct_safetensors"}); err != nil {
+		return nil, err
+	}
+	if err := writeJSON(result.Architecture, map[string]string{"type": "safetensors"}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s Safetensors) Rebuild(req RebuildRequest) error {
+	if err := copyFile(req.Weights, req.Output); err != nil {
+		return &Error{Handler: s.Name(), Code: CodeFailed, Message: err.Error(), Path: req.Output}
+	}
+	return nil
+}
+
+// Python extracts and rebuilds PyTorch, Keras/TensorFlow and ONNX models
+// with the bundled scripts.
+type Python struct {
+	// Interpreter is the python executable to run the scripts with.
+	Interpreter string
+	// Unavailable explains why no interpreter could be found. Extract and
+	// Rebuild fail with it as a missing dependency.
+	Unavailable error
+}
+
+func (Python) Name() string      { return "python" }
+func (Python) Formats() []string { return []string{"pytorch", "keras", "onnx"} }
+
+func (Python) Detect(path string) (bool, error) {
+	switch filepath.Ext(path) {
+	case ".pt", ".pth", ".bin", ".h5", ".keras", ".onnx":
+		return true, nil
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir(), nil
+}
+
+func (p Python) Extract(req ExtractRequest) (*ExtractResult, error) {
+	scriptPath := filepath.Join(req.WorkDir, "extract_model.py")
+	if err := os.WriteFile(scriptPath, extractPy, 0o755); err != nil {
+		return nil, err
+	}
+	if err := p.run(req.WorkDir, req.Path, scriptPath, req.Path); err != nil {
+		return nil, err
+	}
+	return &ExtractResult{
+		Architecture: filepath.Join(req.WorkDir, ArchitectureFile),
+		Metadata:     filepath.Join(req.WorkDir, MetadataFile),
+		Weights:      filepath.Join(req.WorkDir, WeightsFile),
+	}, nil
+}
+
+func (p Python) Rebuild(req RebuildRequest) error {
+	workDir := filepath.Dir(req.Weights)
+	scriptPath := filepath.Join(workDir, "rebuild_model.py")
+	if err := os.WriteFile(scriptPath, rebuildPy, 0o755); err != nil {
+		return err
+	}
+	if err := p.run(workDir, req.Output, scriptPath, req.Weights, req.Architecture, req.Metadata, req.Output); err != nil {
+		return err
+	}
+	// The rebuild script reports unsupported formats without failing.
+	if _, err := os.Stat(req.Output); err != nil {
+		return &Error{Handler: p.Name(), Code: CodeUnsupported, Message: "the rebuild script did not write the model", Path: req.Output}
+	}
+	return nil
+}
+
+// run executes the interpreter in dir and turns a failure into an Error
+// carrying the last line the script printed.
+func (p Python) run(dir, path string, args ...string) error {
+	if p.Unavailable != nil {
+		return &Error{Handler: p.Name(), Code: CodeMissingDep, Message: p.Unavailable.Error(), Path: path}
+	}
+	cmd := exec.Command(p.Interpreter, args...)
+	cmd.Dir = dir
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*exec.ExitError); !ok {
+		return &Error{Handler: p.Name(), Code: CodeMissingDep, Message: "cannot run " + p.Interpreter + ": " + err.Error(), Path: path}
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	last := lines[len(lines)-1]
+	code := CodeFailed
+	switch {
+	case last == "unsupported":
+		code = CodeUnsupported
+	case strings.Contains(last, "ModuleNotFoundError"):
+		code = CodeMissingDep
+	}
+	if last == "" {
+		last = err.Error()
+	}
+	return &Error{Handler: p.Name(), Code: code, Message: last, Path: path}
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sdk/pkg/safetensors"
+	"strings"
+)
+
+// External is a handler implemented by another program speaking the
+// protocol in protocol.go.
+type External struct {
+	HandlerName string
+	// Command is run through the shell.
+	Command string
+	// Patterns select the files the handler extracts, matched like
+	// .stkattributes. Without patterns the handler is asked with a detect
+	// request.
+	Patterns []string
+	// Types are the architecture types the handler rebuilds.
+	Types []string
+}
+
+func (e *External) Name() string      { return e.HandlerName }
+func (e *External) Formats() []string { return e.Types }
+
+func (e *External) Detect(filePath string) (bool, error) {
+	if len(e.Patterns) > 0 {
+		for _, pattern := range e.Patterns {
+			target := filePath
+			if !strings.Contains(pattern, "/") {
+				target = path.Base(filePath)
+			}
+			if ok, _ := path.Match(pattern, filepath.ToSlash(target)); ok {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	result, _, err := e.call(Request{Op: OpDetect, Path: filePath}, "", nil)
+	if err != nil {
+		return false, err
+	}
+	return result.Detected, nil
+}
+
+func (e *External) Extract(req ExtractRequest) (*ExtractResult, error) {
+	msg, tensors, err := e.call(Request{Op: OpExtract, Path: req.Path, WorkDir: req.WorkDir}, req.WorkDir, req.Progress)
+	if err != nil {
+		return nil, err
+	}
+
+	resolve := func(name, fallback string) string {
+		if name == "" {
+			name = fallback
+		}
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(req.WorkDir, name)
+		}
+		return name
+	}
+	result := &ExtractResult{
+		Architecture: resolve(msg.Architecture, ArchitectureFile),
+		Metadata:     resolve(msg.Metadata, MetadataFile),
+		Weights:      resolve(msg.Weights, WeightsFile),
+		Tensors:      tensors,
+	}
+	for _, file := range []string{result.Architecture, result.Metadata, result.Weights} {
+		if _, err := os.Stat(file); err != nil {
+			return nil, &Error{Handler: e.Name(), Code: CodeFailed, Message: "handler did not write " + filepath.Base(file), Path: req.Path}
+		}
+	}
+	return result, nil
+}
+
+func (e *External) Rebuild(req RebuildRequest) error {
+	_, _, err := e.call(Request{
+		Op:           OpRebuild,
+		Architecture: req.Architecture,
+		Metadata:     req.Metadata,
+		Weights:      req.Weights,
+		Output:       req.Output,
+		WorkDir:      filepath.Dir(req.Weights),
+	}, filepath.Dir(req.Weights), req.Progress)
+	return err
+}
+
+// call runs the handler for one request and collects its messages until
+// the final result or error.
+func (e *External) call(req Request, dir string, progress ProgressFunc) (*Message, []safetensors.TensorInfo, error) {
+	req.Protocol = ProtocolVersion
+	input, err := json.Marshal(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	target := req.Path
+	if target == "" {
+		target = req.Output
+	}
+
+	cmd := exec.Command("sh", "-c", e.Command)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(string(input) + "\n")
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, &Error{Handler: e.Name(), Code: CodeMissingDep, Message: err.Error(), Path: target}
+	}
+
+	var (
+		final   *Message
+		tensors []safetensors.TensorInfo
+	)
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var msg Message
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			// Not part of the protocol; pass it on like stderr.
+			fmt.Fprintln(os.Stderr, line)
+			continue
+		}
+
+		switch msg.Type {
+		case "progress":
+			if progress != nil {
+				progress(Progress{Handler: e.Name(), Stage: msg.Stage, Done: msg.Done, Total: msg.Total, Message: msg.Message})
+			}
+		case "tensor":
+			tensors = append(tensors, safetensors.TensorInfo{Name: msg.Name, DType: msg.DType, Shape: msg.Shape})
+		case "result", "error":
+			if final == nil {
+				m := msg
+				final = &m
+			}
+		}
+	}
+	scanErr := scanner.Err()
+	waitErr := cmd.Wait()
+
+	switch {
+	case final != nil && final.Type == "error":
+		code := final.Code
+		if code == "" {
+			code = CodeFailed
+		}
+		return nil, nil, &Error{Handler: e.Name(), Code: code, Message: final.Message, Path: target}
+	case waitErr != nil:
+		return nil, nil, &Error{Handler: e.Name(), Code: CodeFailed, Message: waitErr.Error(), Path: target}
+	case scanErr != nil:
+		return nil, nil, &Error{Handler: e.Name(), Code: CodeFailed, Message: "reading handler output: " + scanErr.Error(), Path: target}
+	case final == nil:
+		return nil, nil, &Error{Handler: e.Name(), Code: CodeFailed, Message: "handler exited without a result", Path: target}
+	}
+	return final, tensors, nil
+}
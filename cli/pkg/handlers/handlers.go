@@ -0,0 +1,189 @@
+// Package handlers converts model files between their native format and the
+// form stk stores: an architecture description, a metadata document and the
+// weights as a single safetensors file.
+//
+// Every format is served by a ModelHandler. Built-in handlers are written in
+// Go or wrap the bundled Python scripts; external handlers are separate
+// programs speaking the JSON protocol described in protocol.go, so new
+// formats can be added without changing stk.
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sdk/pkg/safetensors"
+)
+
+// Names of the files a handler writes to the work directory on extract and
+// reads from it on rebuild.
+const (
+	ArchitectureFile = "architecture.json"
+	MetadataFile     = "metadata.json"
+	WeightsFile      = "weights.safetensors"
+)
+
+// ModelHandler extracts and rebuilds one family of model formats.
+type ModelHandler interface {
+	// Name identifies the handler in messages and configuration.
+	Name() string
+	// Formats lists the architecture types the handler writes into the
+	// "type" field of architecture.json and can therefore rebuild.
+	Formats() []string
+	// Detect reports whether the handler can extract the model at path.
+	Detect(path string) (bool, error)
+	// Extract splits the model at req.Path into the architecture, metadata
+	// and weights files inside req.WorkDir.
+	Extract(req ExtractRequest) (*ExtractResult, error)
+	// Rebuild writes req.Output from the files extracted earlier.
+	Rebuild(req RebuildRequest) error
+}
+
+// Progress reports how far an extract or rebuild has come. Total is zero
+// when the handler cannot tell.
+type Progress struct {
+	Handler string
+	Stage   string
+	Done    int64
+	Total   int64
+	Message string
+}
+
+// ProgressFunc receives progress updates. It may be nil.
+type ProgressFunc func(Progress)
+
+type ExtractRequest struct {
+	Path     string
+	WorkDir  string
+	Progress ProgressFunc
+}
+
+// ExtractResult points at the extracted files and lists the tensors found
+// in the weights.
+type ExtractResult struct {
+	Architecture string
+	Metadata     string
+	Weights      string
+	Tensors      []safetensors.TensorInfo
+}
+
+type RebuildRequest struct {
+	Architecture string
+	Metadata     string
+	Weights      string
+	Output       string
+	Progress     ProgressFunc
+}
+
+// Error codes carried by Error.
+const (
+	CodeUnsupported = "unsupported"
+	CodeInvalid     = "invalid_input"
+	CodeMissingDep  = "missing_dependency"
+	CodeFailed      = "failed"
+)
+
+// Error is a structured handler failure.
+type Error struct {
+	Handler string `json:"handler,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Path    string `json:"path,omitempty"`
+}
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if e.Path != "" {
+		msg = e.Path + ": " + msg
+	}
+	return fmt.Sprintf("%s handler: %s (%s)", e.Handler, msg, e.Code)
+}
+
+// Registry holds the available handlers in priority order.
+type Registry struct {
+	handlers []ModelHandler
+}
+
+func NewRegistry(handlers ...ModelHandler) *Registry {
+	return &Registry{handlers: handlers}
+}
+
+// Register adds h after the handlers already registered.
+func (r *Registry) Register(h ModelHandler) {
+	r.handlers = append(r.handlers, h)
+}
+
+func (r *Registry) Handlers() []ModelHandler {
+	return r.handlers
+}
+
+// Detect returns the first handler that can extract the model at path.
+func (r *Registry) Detect(path string) (ModelHandler, error) {
+	for _, h := range r.handlers {
+		ok, err := h.Detect(path)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return h, nil
+		}
+	}
+	return nil, &Error{Handler: "stk", Code: CodeUnsupported, Message: "no handler recognizes this model format", Path: path}
+}
+
+// ForFormat returns the first handler that can rebuild architecture type
+// format.
+func (r *Registry) ForFormat(format string) (ModelHandler, error) {
+	for _, h := range r.handlers {
+		for _, f := range h.Formats() {
+			if f == format {
+				return h, nil
+			}
+		}
+	}
+	return nil, &Error{Handler: "stk", Code: CodeUnsupported, Message: fmt.Sprintf("no handler rebuilds models of type %q", format)}
+}
+
+// Extract runs the handler for path and fills in the tensor list from the
+// weights file when the handler did not report one.
+func (r *Registry) Extract(req ExtractRequest) (ModelHandler, *ExtractResult, error) {
+	h, err := r.Detect(req.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	result, err := h.Extract(req)
+	if err != nil {
+		return h, nil, err
+	}
+	if result.Tensors == nil {
+		f, err := os.Open(result.Weights)
+		if err != nil {
+			return h, nil, err
+		}
+		defer f.Close()
+		if result.Tensors, err = safetensors.ReadTensorInfo(f); err != nil {
+			return h, nil, &Error{Handler: h.Name(), Code: CodeFailed, Message: err.Error(), Path: req.Path}
+		}
+	}
+	return h, result, nil
+}
+
+// Rebuild picks the handler from the "type" field of the architecture file.
+func (r *Registry) Rebuild(req RebuildRequest) error {
+	data, err := os.ReadFile(req.Architecture)
+	if err != nil {
+		return err
+	}
+	var arch struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &arch); err != nil {
+		return &Error{Handler: "stk", Code: CodeInvalid, Message: "architecture.json is not valid JSON: " + err.Error(), Path: req.Output}
+	}
+
+	h, err := r.ForFormat(arch.Type)
+	if err != nil {
+		return err
+	}
+	return h.Rebuild(req)
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sdk/pkg/safetensors"
+	"testing"
+)
+
+// script writes a shell script to a temporary directory and returns a
+// command running it. The script sees the request on stdin and saves it
+// to request.json next to itself.
+func script(t *testing.T, body string) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "handler.sh")
+	saved := filepath.Join(dir, "request.json")
+	content := "cat > '" + saved + "'\n" + body
+	if err := os.WriteFile(path, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return "sh '" + path + "'", saved
+}
+
+func writeWeights(t *testing.T, path string, names ...string) {
+	t.Helper()
+	f := safetensors.New()
+	for _, name := range names {
+		tensor, err := safetensors.FromFloats("F32", []int64{1}, []float64{1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Set(name, tensor)
+	}
+	data, err := f.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExternalExtract(t *testing.T) {
+	command, saved := script(t, `
+echo '{"type": "progress", "stage": "reading", "done": 1, "total": 2}'
+echo 'not json is passed on'
+echo '{"type": "tensor", "name": "blk.0.w", "dtype": "F16", "shape": [2, 3]}'
+echo '{}' > arch.json
+echo '{}' > metadata.json
+echo '' > weights.safetensors
+echo '{"type": "result", "architecture": "arch.json"}'
+echo '{"type": "error", "message": "ignored after the result"}'
+`)
+	workDir := t.TempDir()
+	var progress []Progress
+	h := &External{HandlerName: "gguf", Command: command, Patterns: []string{"*.gguf"}, Types: []string{"gguf"}}
+	result, err := h.Extract(ExtractRequest{
+		Path:     "/repo/models/llm.gguf",
+		WorkDir:  workDir,
+		Progress: func(p Progress) { progress = append(progress, p) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &ExtractResult{
+		Architecture: filepath.Join(workDir, "arch.json"),
+		Metadata:     filepath.Join(workDir, MetadataFile),
+		Weights:      filepath.Join(workDir, WeightsFile),
+		Tensors:      []safetensors.TensorInfo{{Name: "blk.0.w", DType: "F16", Shape: []int64{2, 3}}},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("Extract = %+v, want %+v", result, want)
+	}
+	if len(progress) != 1 || progress[0] != (Progress{Handler: "gguf", Stage: "reading", Done: 1, Total: 2}) {
+		t.Errorf("progress = %+v", progress)
+	}
+
+	data, err := os.ReadFile(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req Request
+	if err := json.Unmarshal(data, &req); err != nil {
+		t.Fatal(err)
+	}
+	if req != (Request{Protocol: ProtocolVersion, Op: OpExtract, Path: "/repo/models/llm.gguf", WorkDir: workDir}) {
+		t.Errorf("request = %+v", req)
+	}
+}
+
+func TestExternalErrors(t *testing.T) {
+	tests := []struct {
+		name, body, code, message string
+	}{
+		{"error message", `echo '{"type": "error", "code": "unsupported", "message": "GGUF v1"}'; exit 3`, CodeUnsupported, "GGUF v1"},
+		{"error without code", `echo '{"type": "error", "message": "boom"}'`, CodeFailed, "boom"},
+		{"exit status", `exit 2`, CodeFailed, "exit status 2"},
+		{"no result", `echo '{"type": "progress", "stage": "x"}'`, CodeFailed, "handler exited without a result"},
+		{"missing files", `echo '{"type": "result"}'`, CodeFailed, "handler did not write architecture.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			command, _ := script(t, tt.body)
+			h := &External{HandlerName: "x", Command: command, Patterns: []string{"*"}}
+			_, err := h.Extract(ExtractRequest{Path: "/m.bin", WorkDir: t.TempDir()})
+			var herr *Error
+			if !errors.As(err, &herr) {
+				t.Fatalf("Extract = %v, want a handler Error", err)
+			}
+			if herr.Code != tt.code || herr.Message != tt.message || herr.Handler != "x" || herr.Path != "/m.bin" {
+				t.Errorf("Extract = %+v, want code %s and message %q", herr, tt.code, tt.message)
+			}
+		})
+	}
+}
+
+func TestExternalDetect(t *testing.T) {
+	h := &External{HandlerName: "x", Patterns: []string{"*.gguf", "models/llm/*"}}
+	for path, want := range map[string]bool{
+		"a.gguf":             true,
+		"models/deep/b.gguf": true,
+		"models/llm/c.bin":   true,
+		"other/llm/c.bin":    false,
+		"a.gguf.bak":         false,
+	} {
+		if got, err := h.Detect(path); err != nil || got != want {
+			t.Errorf("Detect(%s) = %v, %v; want %v", path, got, err, want)
+		}
+	}
+
+	// Without patterns the handler is asked.
+	command, saved := script(t, `echo '{"type": "result", "detected": true}'`)
+	h = &External{HandlerName: "x", Command: command}
+	if ok, err := h.Detect("/m.bin"); err != nil || !ok {
+		t.Errorf("Detect = %v, %v; want true", ok, err)
+	}
+	data, _ := os.ReadFile(saved)
+	var req Request
+	if err := json.Unmarshal(data, &req); err != nil || req.Op != OpDetect || req.Path != "/m.bin" {
+		t.Errorf("detect request = %s", data)
+	}
+}
+
+func TestExternalRebuild(t *testing.T) {
+	command, saved := script(t, `echo '{"type": "result"}'`)
+	h := &External{HandlerName: "x", Command: command, Types: []string{"gguf"}}
+	dir := t.TempDir()
+	req := RebuildRequest{
+		Architecture: filepath.Join(dir, ArchitectureFile),
+		Metadata:     filepath.Join(dir, MetadataFile),
+		Weights:      filepath.Join(dir, WeightsFile),
+		Output:       "/repo/out.gguf",
+	}
+	if err := h.Rebuild(req); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(saved)
+	var got Request
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := Request{Protocol: ProtocolVersion, Op: OpRebuild, WorkDir: dir, Architecture: req.Architecture, Metadata: req.Metadata, Weights: req.Weights, Output: req.Output}
+	if got != want {
+		t.Errorf("rebuild request = %+v, want %+v", got, want)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	ext := &External{HandlerName: "ext", Patterns: []string{"*.safetensors"}, Types: []string{"safetensors"}}
+	r := NewRegistry(ext, Safetensors{})
+
+	if h, err := r.Detect("m.safetensors"); err != nil || h != ext {
+		t.Errorf("Detect = %v, %v; want the first registered handler", h, err)
+	}
+	if h, err := r.ForFormat("safetensors"); err != nil || h != ext {
+		t.Errorf("ForFormat = %v, %v; want the first registered handler", h, err)
+	}
+	var herr *Error
+	if _, err := r.Detect("m.gguf"); !errors.As(err, &herr) || herr.Code != CodeUnsupported {
+		t.Errorf("Detect of an unknown format = %v", err)
+	}
+	if _, err := r.ForFormat("gguf"); !errors.As(err, &herr) || herr.Code != CodeUnsupported {
+		t.Errorf("ForFormat of an unknown type = %v", err)
+	}
+
+	// Built-in extraction lists the tensors from the weights header, and
+	// rebuild picks the handler from the architecture type.
+	r = NewRegistry(Safetensors{})
+	dir := t.TempDir()
+	model := filepath.Join(dir, "m.safetensors")
+	writeWeights(t, model, "b", "a")
+	work := t.TempDir()
+	h, result, err := r.Extract(ExtractRequest{Path: model, WorkDir: work})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Name() != "safetensors" || len(result.Tensors) != 2 || result.Tensors[0].Name != "b" {
+		t.Errorf("Extract = %s, %+v", h.Name(), result)
+	}
+	out := filepath.Join(dir, "rebuilt.safetensors")
+	if err := r.Rebuild(RebuildRequest{Architecture: result.Architecture, Metadata: result.Metadata, Weights: result.Weights, Output: out}); err != nil {
+		t.Fatal(err)
+	}
+	original, _ := os.ReadFile(model)
+	rebuilt, _ := os.ReadFile(out)
+	if string(original) != string(rebuilt) {
+		t.Error("rebuilt model differs from the original")
+	}
+
+	if err := os.WriteFile(result.Architecture, []byte(`{"type": "gguf"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Rebuild(RebuildRequest{Architecture: result.Architecture, Output: out}); !errors.As(err, &herr) || herr.Code != CodeUnsupported {
+		t.Errorf("Rebuild of an unknown type = %v", err)
+	}
+}
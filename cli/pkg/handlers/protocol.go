@@ -0,0 +1,83 @@
+package handlers
+
+// External handlers are programs that stk starts once per operation. The
+// protocol, version 1, is line-delimited JSON:
+//
+// stk writes a single Request object to the handler's stdin and closes it.
+// Paths are absolute; the handler runs with work_dir as its working
+// directory.
+//
+//	{"protocol": 1, "op": "extract", "path": "/repo/models/llm.gguf",
+//	 "work_dir": "/tmp/stk-123"}
+//
+// Ops are:
+//
+//	detect   can the handler extract path? Only sent when the handler is
+//	         configured without patterns.
+//	extract  split path into architecture.json, metadata.json and
+//	         weights.safetensors inside work_dir.
+//	rebuild  write output from architecture, metadata and weights.
+//
+// The handler answers with Message objects on stdout, one per line, and
+// must finish with exactly one "result" or "error" message:
+//
+//	{"type": "progress", "stage": "reading", "done": 3, "total": 12}
+//	{"type": "tensor", "name": "blk.0.attn_q.weight", "dtype": "F16", "shape": [4096, 4096]}
+//	{"type": "result", "detected": true}
+//	{"type": "result", "architecture": "architecture.json",
+//	 "metadata": "metadata.json", "weights": "weights.safetensors"}
+//	{"type": "error", "code": "unsupported", "message": "GGUF v1 is not supported"}
+//
+// Result paths may be relative to work_dir and default to the names above.
+// The architecture document must contain a "type" field naming one of the
+// handler's formats so stk can choose it again on rebuild. Tensor messages
+// are optional; when none are sent stk reads the list from the weights
+// header. Error codes are unsupported, invalid_input, missing_dependency
+// and failed. Anything written to stderr is shown to the user, and a
+// non-zero exit status without an error message is reported as failed.
+
+// ProtocolVersion is the version of the external handler protocol.
+const ProtocolVersion = 1
+
+// Request is the object sent to an external handler on stdin.
+type Request struct {
+	Protocol     int    `json:"protocol"`
+	Op           string `json:"op"`
+	Path         string `json:"path,omitempty"`
+	WorkDir      string `json:"work_dir,omitempty"`
+	Architecture string `json:"architecture,omitempty"`
+	Metadata     string `json:"metadata,omitempty"`
+	Weights      string `json:"weights,omitempty"`
+	Output       string `json:"output,omitempty"`
+}
+
+// Message is one line written by an external handler on stdout.
+type Message struct {
+	Type string `json:"type"`
+
+	// progress
+	Stage   string `json:"stage,omitempty"`
+	Done    int64  `json:"done,omitempty"`
+	Total   int64  `json:"total,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	// tensor
+	Name  string  `json:"name,omitempty"`
+	DType string  `json:"dtype,omitempty"`
+	Shape []int64 `json:"shape,omitempty"`
+
+	// result
+	Detected     bool   `json:"detected,omitempty"`
+	Architecture string `json:"architecture,omitempty"`
+	Metadata     string `json:"metadata,omitempty"`
+	Weights      string `json:"weights,omitempty"`
+
+	// error
+	Code string `json:"code,omitempty"`
+}
+
+const (
+	OpDetect  = "detect"
+	OpExtract = "extract"
+	OpRebuild = "rebuild"
+)
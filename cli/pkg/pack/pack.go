@@ -0,0 +1,179 @@
+// Package pack streams many repository objects as one byte stream, for
+// the smart HTTP protocol of stk serve and for bundle files. A pack is
+//
+//	STKPACK 1
+//	<key> <size>
+//	<size bytes: the object as stored, compressed>
+//	…
+//	end <count>
+//	blake3 <hex digest of everything above>
+//
+// where each key is the object's path in .stk, e.g. "objects/trees/ab/cd…".
+// Readers verify the count and the checksum, so a truncated or corrupted
+// pack is detected; checking each object against its hash is left to the
+// caller, which knows how to decompress it.
+package pack
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/zeebo/blake3"
+)
+
+const magic = "STKPACK 1\n"
+
+// MaxObjectSize bounds the size a reader accepts for one object.
+const MaxObjectSize = 1 << 36
+
+// ErrCorrupt is returned for a pack that is malformed, truncated or does
+// not match its checksum.
+var ErrCorrupt = errors.New("corrupt pack")
+
+// Writer writes a pack. Close must be called to finish it.
+type Writer struct {
+	w     io.Writer
+	sum   hash.Hash
+	count int
+}
+
+// NewWriter starts a pack on w.
+func NewWriter(w io.Writer) (*Writer, error) {
+	pw := &Writer{sum: blake3.New()}
+	pw.w = io.MultiWriter(w, pw.sum)
+	if _, err := io.WriteString(pw.w, magic); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+// Add writes an object stored under key.
+func (w *Writer) Add(key string, data []byte) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w.w, "%s %d\n", key, len(data)); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(data); err != nil {
+		return err
+	}
+	w.count++
+	return nil
+}
+
+// Count returns the number of objects written so far.
+func (w *Writer) Count() int { return w.count }
+
+// Close writes the trailer. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if _, err := fmt.Fprintf(w.w, "end %d\n", w.count); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w.w, "blake3 %s\n", hex.EncodeToString(w.sum.Sum(nil)))
+	return err
+}
+
+// Reader reads the objects of a pack in order.
+type Reader struct {
+	r     *bufio.Reader
+	sum   hash.Hash
+	count int
+	done  bool
+}
+
+// NewReader checks the start of a pack on r.
+func NewReader(r io.Reader) (*Reader, error) {
+	pr := &Reader{r: bufio.NewReader(r), sum: blake3.New()}
+	line, err := pr.line()
+	if err != nil {
+		return nil, err
+	}
+	if line+"\n" != magic {
+		return nil, fmt.Errorf("%w: not a pack", ErrCorrupt)
+	}
+	return pr, nil
+}
+
+// Next returns the key and stored bytes of the next object. At the end of
+// the pack it verifies the trailer and returns io.EOF.
+func (r *Reader) Next() (string, []byte, error) {
+	if r.done {
+		return "", nil, io.EOF
+	}
+	line, err := r.line()
+	if err != nil {
+		return "", nil, err
+	}
+	key, field, ok := strings.Cut(line, " ")
+	if !ok {
+		return "", nil, fmt.Errorf("%w: bad object header %q", ErrCorrupt, line)
+	}
+	if key == "end" {
+		return "", nil, r.finish(field)
+	}
+
+	size, err := strconv.ParseInt(field, 10, 64)
+	if err != nil || size < 0 || size > MaxObjectSize {
+		return "", nil, fmt.Errorf("%w: bad size for %s", ErrCorrupt, key)
+	}
+	if err := validKey(key); err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return "", nil, fmt.Errorf("%w: %s is truncated", ErrCorrupt, key)
+	}
+	r.sum.Write(data)
+	r.count++
+	return key, data, nil
+}
+
+// finish checks the object count and the checksum.
+func (r *Reader) finish(count string) error {
+	if n, err := strconv.Atoi(count); err != nil || n != r.count {
+		return fmt.Errorf("%w: expected %s objects, read %d", ErrCorrupt, count, r.count)
+	}
+	want := hex.EncodeToString(r.sum.Sum(nil))
+	line, err := r.r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("%w: missing checksum", ErrCorrupt)
+	}
+	if strings.TrimSuffix(line, "\n") != "blake3 "+want {
+		return fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+	r.done = true
+	return io.EOF
+}
+
+// line reads one header line and adds it to the checksum.
+func (r *Reader) line() (string, error) {
+	line, err := r.r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF {
+			return "", fmt.Errorf("%w: unexpected end", ErrCorrupt)
+		}
+		return "", err
+	}
+	r.sum.Write([]byte(line))
+	return strings.TrimSuffix(line, "\n"), nil
+}
+
+// validKey rejects keys that could escape the object directories.
+func validKey(key string) error {
+	if key == "" || strings.ContainsAny(key, " \n\\") || strings.HasPrefix(key, "/") {
+		return fmt.Errorf("invalid object key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid object key %q", key)
+		}
+	}
+	return nil
+}
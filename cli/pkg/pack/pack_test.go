@@ -0,0 +1,150 @@
+package pack
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+type object struct {
+	key  string
+	data []byte
+}
+
+var objects = []object{
+	{"objects/commits/ab/cdef", []byte("commit")},
+	{"objects/blobs/01/2345", []byte("line\nwith newlines\nend 7\n")},
+	{"objects/trees/ff/0000", []byte{}},
+	{"objects/models/aa/bbbb", []byte{0, 1, 2, 0xff}},
+}
+
+func writePack(t *testing.T, objs []object) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range objs {
+		if err := w.Add(o.key, o.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w.Count() != len(objs) {
+		t.Errorf("Count() = %d, want %d", w.Count(), len(objs))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readPack reads every object of data, returning the first error other
+// than the io.EOF that ends a valid pack.
+func readPack(data []byte) ([]object, error) {
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var objs []object
+	for {
+		key, raw, err := r.Next()
+		if err == io.EOF {
+			return objs, nil
+		}
+		if err != nil {
+			return objs, err
+		}
+		objs = append(objs, object{key, raw})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, objs := range [][]object{objects, nil} {
+		got, err := readPack(writePack(t, objs))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(objs) {
+			t.Fatalf("read %d objects, want %d", len(got), len(objs))
+		}
+		for i := range objs {
+			if got[i].key != objs[i].key || !bytes.Equal(got[i].data, objs[i].data) {
+				t.Errorf("object %d = %s %q, want %s %q", i, got[i].key, got[i].data, objs[i].key, objs[i].data)
+			}
+		}
+	}
+}
+
+func TestNextAfterEnd(t *testing.T) {
+	r, err := NewReader(bytes.NewReader(writePack(t, objects[:1])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, _, err := r.Next(); err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := r.Next(); err != io.EOF {
+		t.Errorf("Next after the end = %v, want io.EOF", err)
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	valid := writePack(t, objects)
+	replace := func(old, new string) []byte {
+		if !bytes.Contains(valid, []byte(old)) {
+			t.Fatalf("pack does not contain %q", old)
+		}
+		return bytes.Replace(valid, []byte(old), []byte(new), 1)
+	}
+	tests := map[string][]byte{
+		"not a pack":       append([]byte("STKPACK 2\n"), valid[len(magic):]...),
+		"empty":            nil,
+		"flipped data":     replace("commit", "commiT"),
+		"flipped key":      replace("objects/trees/ff/0000", "objects/trees/ff/0001"),
+		"wrong count":      replace("end 4\n", "end 3\n"),
+		"bad size":         replace("commits/ab/cdef 6", "commits/ab/cdef x"),
+		"escaping key":     replace("objects/commits/ab/cdef", "objects/../../ab/cdef"),
+		"truncated object": valid[:len(magic)+30],
+		"no trailer":       valid[:bytes.Index(valid, []byte("end 4"))],
+		"no checksum":      valid[:bytes.Index(valid, []byte("blake3"))],
+		"bad checksum":     badChecksum(valid),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := readPack(data); !errors.Is(err, ErrCorrupt) {
+				t.Errorf("reading the pack = %v, want ErrCorrupt", err)
+			}
+		})
+	}
+}
+
+// badChecksum changes the last digit of the checksum of a pack.
+func badChecksum(data []byte) []byte {
+	out := bytes.Clone(data)
+	last := len(out) - 2
+	if out[last] == '0' {
+		out[last] = '1'
+	} else {
+		out[last] = '0'
+	}
+	return out
+}
+
+func TestAddRejectsInvalidKeys(t *testing.T) {
+	w, err := NewWriter(io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"", "/etc/passwd", "../x", "objects/../x", "objects//x", "objects/./x", "a b", "a\nb", `a\b`} {
+		if err := w.Add(key, nil); err == nil {
+			t.Errorf("Add(%q) succeeded", key)
+		}
+	}
+	if w.Count() != 0 {
+		t.Errorf("Count() = %d after rejected keys", w.Count())
+	}
+}
@@ -0,0 +1,120 @@
+// Package pyenv locates the Python interpreter used to run model handlers.
+package pyenv
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// EnvVar overrides the interpreter for a single shell or job.
+const EnvVar = "STK_PYTHON"
+
+// Interpreter is a Python executable together with where it was found.
+type Interpreter struct {
+	Path   string
+	Source string
+}
+
+// Find resolves the interpreter, in order, from the configured path, the
+// STK_PYTHON environment variable, an active virtualenv, an active conda
+// environment and finally python3 or python on PATH. An explicitly
+// configured interpreter that does not exist is an error rather than a
+// reason to fall back.
+func Find(configured string) (Interpreter, error) {
+	if configured != "" {
+		return explicit(configured, "config")
+	}
+	if path := os.Getenv(EnvVar); path != "" {
+		return explicit(path, "$"+EnvVar)
+	}
+	if venv := os.Getenv("VIRTUAL_ENV"); venv != "" {
+		if path, ok := envPython(venv); ok {
+			return Interpreter{Path: path, Source: "virtualenv " + venv}, nil
+		}
+	}
+	if conda := os.Getenv("CONDA_PREFIX"); conda != "" {
+		if path, ok := envPython(conda); ok {
+			return Interpreter{Path: path, Source: "conda env " + conda}, nil
+		}
+	}
+	for _, name := range []string{"python3", "python"} {
+		if path, err := exec.LookPath(name); err == nil {
+			return Interpreter{Path: path, Source: "PATH"}, nil
+		}
+	}
+	return Interpreter{}, fmt.Errorf("no Python interpreter found; set %s, add \"python\" to .stk/config.json or activate a virtualenv", EnvVar)
+}
+
+func explicit(path, source string) (Interpreter, error) {
+	resolved, err := exec.LookPath(path)
+	if err != nil {
+		return Interpreter{}, fmt.Errorf("python interpreter %q from %s is not executable: %w", path, source, err)
+	}
+	return Interpreter{Path: resolved, Source: source}, nil
+}
+
+func envPython(prefix string) (string, bool) {
+	candidates := []string{filepath.Join(prefix, "bin", "python3"), filepath.Join(prefix, "bin", "python")}
+	if runtime.GOOS == "windows" {
+		candidates = []string{filepath.Join(prefix, "Scripts", "python.exe"), filepath.Join(prefix, "python.exe")}
+	}
+	for _, path := range candidates {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// Version returns the output of python --version, e.g. "Python 3.11.4".
+func (i Interpreter) Version() (string, error) {
+	out, err := exec.Command(i.Path, "--version").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s --version: %w", i.Path, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+const importScript = `
+import importlib, json, sys
+found = {}
+for name in sys.argv[1:]:
+    try:
+        module = importlib.import_module(name)
+        found[name] = str(getattr(module, "__version__", "installed"))
+    except Exception:
+        found[name] = None
+print(json.dumps(found))
+`
+
+// Modules tries to import each module and returns the version of those
+// that can be imported. Missing modules are absent from the map.
+func (i Interpreter) Modules(names ...string) (map[string]string, error) {
+	cmd := exec.Command(i.Path, append([]string{"-c", importScript}, names...)...)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("running %s: %w", i.Path, err)
+	}
+
+	// Frameworks may print banners before the result line.
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	var raw map[string]*string
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &raw); err != nil {
+		return nil, fmt.Errorf("unexpected output from %s: %w", i.Path, err)
+	}
+
+	versions := make(map[string]string)
+	for name, version := range raw {
+		if version != nil {
+			versions[name] = *version
+		}
+	}
+	return versions, nil
+}
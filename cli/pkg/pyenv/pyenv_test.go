@@ -0,0 +1,78 @@
+package pyenv
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// fakePython creates an executable named name under dir.
+func fakePython(t *testing.T, dir, name string) string {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFind(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake interpreters are shell scripts")
+	}
+	root := t.TempDir()
+	configured := fakePython(t, filepath.Join(root, "config"), "python")
+	fromEnv := fakePython(t, filepath.Join(root, "env"), "python")
+	venv := filepath.Join(root, "venv")
+	inVenv := fakePython(t, filepath.Join(venv, "bin"), "python3")
+	conda := filepath.Join(root, "conda")
+	inConda := fakePython(t, filepath.Join(conda, "bin"), "python")
+	onPath := filepath.Join(root, "path")
+	inPath := fakePython(t, onPath, "python3")
+
+	tests := []struct {
+		name       string
+		configured string
+		env        map[string]string
+		want       string
+		source     string
+		wantErr    bool
+	}{
+		{name: "config wins", configured: configured, env: map[string]string{EnvVar: fromEnv, "VIRTUAL_ENV": venv}, want: configured, source: "config"},
+		{name: "environment variable", env: map[string]string{EnvVar: fromEnv, "VIRTUAL_ENV": venv}, want: fromEnv, source: "$" + EnvVar},
+		{name: "virtualenv", env: map[string]string{"VIRTUAL_ENV": venv, "CONDA_PREFIX": conda}, want: inVenv, source: "virtualenv " + venv},
+		{name: "conda", env: map[string]string{"CONDA_PREFIX": conda}, want: inConda, source: "conda env " + conda},
+		{name: "path", env: map[string]string{"PATH": onPath}, want: inPath, source: "PATH"},
+		{name: "missing configured interpreter", configured: filepath.Join(root, "nope", "python"), env: map[string]string{EnvVar: fromEnv}, wantErr: true},
+		{name: "nothing found", env: map[string]string{"PATH": filepath.Join(root, "empty")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{EnvVar, "VIRTUAL_ENV", "CONDA_PREFIX"} {
+				t.Setenv(key, "")
+			}
+			t.Setenv("PATH", filepath.Join(root, "empty"))
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			got, err := Find(tt.configured)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Find(%q) = %+v, want an error", tt.configured, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Path != tt.want || got.Source != tt.source {
+				t.Errorf("Find(%q) = %+v, want %s from %s", tt.configured, got, tt.want, tt.source)
+			}
+		})
+	}
+}
@@ -0,0 +1,553 @@
+package refs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	HeadsPrefix   = "refs/heads/"
+	TagsPrefix    = "refs/tags/"
+	RemotesPrefix = "refs/remotes/"
+
+	symbolicPrefix = "ref: "
+	packedRefsFile = "packed-refs"
+)
+
+var (
+	ErrNotFound = errors.New("ref not found")
+	// ErrStale is returned by Update when the ref no longer holds the
+	// expected value.
+	ErrStale = errors.New("ref changed concurrently")
+)
+
+type Ref struct {
+	Name string
+	Hash string
+}
+
+// Store reads and writes the refs of one repository. Refs live as loose
+// files under <common>/refs or as lines of <common>/packed-refs; a loose
+// ref always takes precedence over a packed one of the same name. HEAD
+// lives in <dir>, which differs from common in a linked worktree.
+type Store struct {
+	dir    string
+	common string
+}
+
+func New(dir string) *Store {
+	return &Store{dir: dir, common: dir}
+}
+
+// NewWorktree opens the refs of a worktree whose HEAD lives in dir and
+// whose refs are shared through common.
+func NewWorktree(dir, common string) *Store {
+	return &Store{dir: dir, common: common}
+}
+
+func BranchRef(name string) string {
+	return HeadsPrefix + name
+}
+
+func TagRef(name string) string {
+	return TagsPrefix + name
+}
+
+// Short strips the refs/heads/, refs/tags/ or refs/remotes/ prefix.
+func Short(name string) string {
+	for _, prefix := range []string{HeadsPrefix, TagsPrefix, RemotesPrefix} {
+		if strings.HasPrefix(name, prefix) {
+			return strings.TrimPrefix(name, prefix)
+		}
+	}
+	return name
+}
+
+// ValidateName checks a branch or tag name. Names may contain slashes but
+// every component must be non-empty, must not start with a dot or end with
+// ".lock", and the name may not contain "..", "@{", whitespace, control
+// characters or any of ~^:?*[\.
+func ValidateName(name string) error {
+	invalid := func(reason string) error {
+		return fmt.Errorf("'%s' is not a valid name: %s", name, reason)
+	}
+
+	switch {
+	case name == "":
+		return fmt.Errorf("name must not be empty")
+	case name == "@" || name == "HEAD":
+		return invalid("reserved name")
+	case strings.HasPrefix(name, "-"):
+		return invalid("must not start with '-'")
+	case strings.HasSuffix(name, "."):
+		return invalid("must not end with '.'")
+	case strings.Contains(name, ".."):
+		return invalid("must not contain '..'")
+	case strings.Contains(name, "@{"):
+		return invalid("must not contain '@{'")
+	}
+
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f || r == ' ' || strings.ContainsRune("~^:?*[\\", r) {
+			return invalid(fmt.Sprintf("must not contain %q", r))
+		}
+	}
+
+	for _, part := range strings.Split(name, "/") {
+		switch {
+		case part == "":
+			return invalid("empty path component")
+		case strings.HasPrefix(part, "."):
+			return invalid("component starts with '.'")
+		case strings.HasSuffix(part, ".lock"):
+			return invalid("component ends with '.lock'")
+		}
+	}
+	return nil
+}
+
+// Read returns the hash a fully qualified ref points to.
+func (s *Store) Read(name string) (string, error) {
+	data, err := os.ReadFile(s.loosePath(name))
+	if err == nil {
+		hash := strings.TrimSpace(string(data))
+		if hash != "" {
+			return hash, nil
+		}
+	} else if !os.IsNotExist(err) && !isDirError(err) {
+		return "", err
+	}
+
+	packed, err := s.readPacked()
+	if err != nil {
+		return "", err
+	}
+	if hash, ok := packed[name]; ok {
+		return hash, nil
+	}
+	return "", fmt.Errorf("%s: %w", name, ErrNotFound)
+}
+
+func (s *Store) Exists(name string) bool {
+	_, err := s.Read(name)
+	return err == nil
+}
+
+// Resolve expands a short name by trying it as given and then under
+// refs/heads/, refs/tags/ and refs/remotes/, returning the full ref name
+// and its hash.
+func (s *Store) Resolve(name string) (string, string, error) {
+	candidates := []string{name, HeadsPrefix + name, TagsPrefix + name, RemotesPrefix + name}
+	if !strings.HasPrefix(name, "refs/") {
+		candidates = candidates[1:]
+	}
+	for _, candidate := range candidates {
+		hash, err := s.Read(candidate)
+		if err == nil {
+			return candidate, hash, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return "", "", err
+		}
+	}
+	return "", "", fmt.Errorf("%s: %w", name, ErrNotFound)
+}
+
+// Write points a ref at hash, creating the directories for nested names.
+// It takes the same lock as Update, so a concurrent update makes it fail
+// with ErrStale instead of being overwritten.
+func (s *Store) Write(name, hash string) error {
+	return s.update(name, hash, nil)
+}
+
+// Update points name at hash only if it currently points at old, where an
+// empty old means the ref must not exist yet. The ref stays locked between
+// the check and the write, so concurrent updates cannot both succeed.
+func (s *Store) Update(name, old, hash string) error {
+	return s.update(name, hash, func(current string) error {
+		if current != old {
+			return fmt.Errorf("%s is at %s, expected %s: %w", name, describeHash(current), describeHash(old), ErrStale)
+		}
+		return nil
+	})
+}
+
+// update writes hash to the lock file of name, which only one writer can
+// create, and renames it over the ref once check, when given, accepts the
+// hash the ref points at.
+func (s *Store) update(name, hash string, check func(current string) error) error {
+	path := s.loosePath(name)
+	if err := s.checkDirectoryConflict(name); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	lock := path + ".lock"
+	f, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return fmt.Errorf("%s is locked by another update: %w", name, ErrStale)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(hash + "\n")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(lock)
+		return err
+	}
+
+	if check != nil {
+		current, err := s.Read(name)
+		if errors.Is(err, ErrNotFound) {
+			current, err = "", nil
+		}
+		if err == nil {
+			err = check(current)
+		}
+		if err != nil {
+			os.Remove(lock)
+			return err
+		}
+	}
+	if err := os.Rename(lock, path); err != nil {
+		os.Remove(lock)
+		return err
+	}
+	return nil
+}
+
+func describeHash(hash string) string {
+	if hash == "" {
+		return "nothing"
+	}
+	return hash
+}
+
+// Delete removes a ref from both the loose and the packed storage.
+func (s *Store) Delete(name string) error {
+	found := false
+
+	path := s.loosePath(name)
+	if err := os.Remove(path); err == nil {
+		found = true
+		s.removeEmptyDirs(filepath.Dir(path))
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	packed, err := s.readPacked()
+	if err != nil {
+		return err
+	}
+	if _, ok := packed[name]; ok {
+		found = true
+		delete(packed, name)
+		if err := s.writePacked(packed); err != nil {
+			return err
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("%s: %w", name, ErrNotFound)
+	}
+	return nil
+}
+
+// Rename moves a ref and repoints HEAD when it referred to the old name.
+// The new ref is written before the old one is deleted, so a failure leaves
+// the ref under its old name rather than losing it.
+func (s *Store) Rename(oldName, newName string) error {
+	hash, err := s.Read(oldName)
+	if err != nil {
+		return err
+	}
+	if s.Exists(newName) {
+		return fmt.Errorf("%s already exists", newName)
+	}
+
+	if err := s.Update(newName, "", hash); err != nil {
+		return err
+	}
+	if err := s.Delete(oldName); err != nil {
+		return err
+	}
+
+	head, err := s.SymbolicHead()
+	if err == nil && head == oldName {
+		return s.SetSymbolicHead(newName)
+	}
+	return nil
+}
+
+// List returns every ref whose name starts with prefix, sorted by name.
+func (s *Store) List(prefix string) ([]Ref, error) {
+	all, err := s.readPacked()
+	if err != nil {
+		return nil, err
+	}
+
+	root := filepath.Join(s.common, "refs")
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(path, ".lock") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.common, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if hash := strings.TrimSpace(string(data)); hash != "" {
+			all[filepath.ToSlash(rel)] = hash
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []Ref
+	for name, hash := range all {
+		if strings.HasPrefix(name, prefix) {
+			refs = append(refs, Ref{Name: name, Hash: hash})
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Name < refs[j].Name })
+	return refs, nil
+}
+
+// Pack moves every loose ref into packed-refs, which keeps repositories
+// with many branches and tags from accumulating thousands of small files.
+func (s *Store) Pack() (int, error) {
+	refs, err := s.List("refs/")
+	if err != nil {
+		return 0, err
+	}
+
+	packed := make(map[string]string, len(refs))
+	for _, ref := range refs {
+		packed[ref.Name] = ref.Hash
+	}
+	if err := s.writePacked(packed); err != nil {
+		return 0, err
+	}
+
+	for _, ref := range refs {
+		path := s.loosePath(ref.Name)
+		if err := os.Remove(path); err == nil {
+			s.removeEmptyDirs(filepath.Dir(path))
+		} else if !os.IsNotExist(err) {
+			return 0, err
+		}
+	}
+	return len(refs), nil
+}
+
+// SymbolicHead returns the ref HEAD points to, or an empty string when HEAD
+// is detached.
+func (s *Store) SymbolicHead() (string, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, "HEAD"))
+	if err != nil {
+		return "", err
+	}
+	content := strings.TrimSpace(string(data))
+	if strings.HasPrefix(content, symbolicPrefix) {
+		return strings.TrimPrefix(content, symbolicPrefix), nil
+	}
+	return "", nil
+}
+
+// Head returns the ref HEAD points to and the commit it resolves to. The
+// ref is empty when HEAD is detached and the hash is empty on an unborn
+// branch.
+func (s *Store) Head() (string, string, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, "HEAD"))
+	if err != nil {
+		return "", "", err
+	}
+	content := strings.TrimSpace(string(data))
+
+	name, ok := strings.CutPrefix(content, symbolicPrefix)
+	if !ok {
+		return "", content, nil
+	}
+	hash, err := s.Read(name)
+	if errors.Is(err, ErrNotFound) {
+		return name, "", nil
+	}
+	return name, hash, err
+}
+
+// SetDetachedHead points HEAD directly at a commit instead of a branch.
+func (s *Store) SetDetachedHead(hash string) error {
+	return writeFileAtomic(filepath.Join(s.dir, "HEAD"), []byte(hash+"\n"))
+}
+
+func (s *Store) SetSymbolicHead(name string) error {
+	return writeFileAtomic(filepath.Join(s.dir, "HEAD"), []byte(symbolicPrefix+name+"\n"))
+}
+
+// Migrate converts the layout used by early versions of stk, with branches
+// stored in <dir>/branches and HEAD holding "branches/<name>", to refs/heads.
+func (s *Store) Migrate() error {
+	oldDir := filepath.Join(s.common, "branches")
+	if _, err := os.Stat(oldDir); os.IsNotExist(err) {
+		return nil
+	}
+
+	entries, err := os.ReadDir(oldDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(oldDir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		hash := strings.TrimSpace(string(data))
+		if hash == "" || s.Exists(BranchRef(entry.Name())) {
+			continue
+		}
+		if err := s.Write(BranchRef(entry.Name()), hash); err != nil {
+			return err
+		}
+	}
+
+	headPath := filepath.Join(s.dir, "HEAD")
+	head, err := os.ReadFile(headPath)
+	if err != nil {
+		return err
+	}
+	if name, ok := strings.CutPrefix(strings.TrimSpace(string(head)), "branches/"); ok {
+		if err := s.SetSymbolicHead(BranchRef(name)); err != nil {
+			return err
+		}
+	}
+
+	return os.RemoveAll(oldDir)
+}
+
+func (s *Store) loosePath(name string) string {
+	return filepath.Join(s.common, filepath.FromSlash(name))
+}
+
+// checkDirectoryConflict rejects names like "a/b" when "a" is a ref and
+// vice versa, since both cannot exist as loose files.
+func (s *Store) checkDirectoryConflict(name string) error {
+	refs, err := s.List("refs/")
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		if ref.Name == name {
+			continue
+		}
+		if strings.HasPrefix(name, ref.Name+"/") || strings.HasPrefix(ref.Name, name+"/") {
+			return fmt.Errorf("cannot create '%s': '%s' exists", name, ref.Name)
+		}
+	}
+	return nil
+}
+
+// removeEmptyDirs prunes the directories left behind by a nested ref name,
+// keeping refs/heads, refs/tags and refs/remotes themselves.
+func (s *Store) removeEmptyDirs(dir string) {
+	for {
+		rel, err := filepath.Rel(s.common, dir)
+		if err != nil || len(strings.Split(filepath.ToSlash(rel), "/")) <= 2 {
+			return
+		}
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+func (s *Store) readPacked() (map[string]string, error) {
+	packed := make(map[string]string)
+
+	f, err := os.Open(filepath.Join(s.common, packedRefsFile))
+	if os.IsNotExist(err) {
+		return packed, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hash, name, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("malformed packed-refs line %q", line)
+		}
+		packed[name] = hash
+	}
+	return packed, scanner.Err()
+}
+
+func (s *Store) writePacked(packed map[string]string) error {
+	names := make([]string, 0, len(packed))
+	for name := range packed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("# pack-refs with: sorted\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s %s\n", packed[name], name)
+	}
+	return writeFileAtomic(filepath.Join(s.common, packedRefsFile), []byte(b.String()))
+}
+
+// writeFileAtomic writes data to a lock file next to path and renames it
+// into place so readers never see a partially written ref.
+func writeFileAtomic(path string, data []byte) error {
+	lock := path + ".lock"
+	if err := os.WriteFile(lock, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(lock, path); err != nil {
+		os.Remove(lock)
+		return err
+	}
+	return nil
+}
+
+func isDirError(err error) bool {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		info, statErr := os.Stat(pathErr.Path)
+		return statErr == nil && info.IsDir()
+	}
+	return false
+}
@@ -0,0 +1,280 @@
+package refs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	hashA = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	hashB = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+	hashC = "cccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccc"
+)
+
+func newStore(t *testing.T) (*Store, string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "HEAD"), []byte("ref: refs/heads/main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return New(dir), dir
+}
+
+func mustWrite(t *testing.T, s *Store, name, hash string) {
+	t.Helper()
+	if err := s.Write(name, hash); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	valid := []string{"main", "feature/x", "v1.0", "release-2024", "a/b/c"}
+	invalid := []string{"", "HEAD", "@", "-x", "a.", "a..b", "a@{1}", "a b", "a~1", "a^", "a:b", "a?", "a*", "a[", `a\b`, "a//b", "/a", "a/", ".hidden", "a/.b", "x.lock", "a/x.lock", "tab\tname"}
+	for _, name := range valid {
+		if err := ValidateName(name); err != nil {
+			t.Errorf("ValidateName(%q) = %v, want nil", name, err)
+		}
+	}
+	for _, name := range invalid {
+		if err := ValidateName(name); err == nil {
+			t.Errorf("ValidateName(%q) = nil, want an error", name)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	s, _ := newStore(t)
+	mustWrite(t, s, BranchRef("main"), hashA)
+	mustWrite(t, s, TagRef("v1"), hashB)
+	mustWrite(t, s, RemotesPrefix+"origin/main", hashC)
+
+	tests := []struct {
+		name, full, hash string
+	}{
+		{"main", "refs/heads/main", hashA},
+		{"v1", "refs/tags/v1", hashB},
+		{"origin/main", "refs/remotes/origin/main", hashC},
+		{"refs/tags/v1", "refs/tags/v1", hashB},
+	}
+	for _, tt := range tests {
+		full, hash, err := s.Resolve(tt.name)
+		if err != nil || full != tt.full || hash != tt.hash {
+			t.Errorf("Resolve(%q) = %s, %s, %v; want %s, %s", tt.name, full, hash, err, tt.full, tt.hash)
+		}
+	}
+	if _, _, err := s.Resolve("nope"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Resolve(nope) = %v, want ErrNotFound", err)
+	}
+}
+
+func TestWriteDirectoryConflict(t *testing.T) {
+	s, _ := newStore(t)
+	mustWrite(t, s, BranchRef("a"), hashA)
+	if err := s.Write(BranchRef("a/b"), hashB); err == nil {
+		t.Error("wrote refs/heads/a/b next to refs/heads/a")
+	}
+	mustWrite(t, s, BranchRef("x/y"), hashA)
+	if err := s.Write(BranchRef("x"), hashB); err == nil {
+		t.Error("wrote refs/heads/x next to refs/heads/x/y")
+	}
+}
+
+func TestPack(t *testing.T) {
+	s, dir := newStore(t)
+	mustWrite(t, s, BranchRef("main"), hashA)
+	mustWrite(t, s, BranchRef("feature/x"), hashB)
+	mustWrite(t, s, TagRef("v1"), hashC)
+
+	n, err := s.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("Pack() = %d, want 3", n)
+	}
+	for _, name := range []string{"refs/heads/main", "refs/heads/feature", "refs/tags/v1"} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Errorf("%s left behind after Pack: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "refs", "heads")); err != nil {
+		t.Errorf("refs/heads was removed: %v", err)
+	}
+
+	list, err := s.List("refs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Ref{{"refs/heads/feature/x", hashB}, {"refs/heads/main", hashA}, {"refs/tags/v1", hashC}}
+	if len(list) != len(want) {
+		t.Fatalf("List after Pack = %v, want %v", list, want)
+	}
+	for i := range want {
+		if list[i] != want[i] {
+			t.Errorf("List after Pack = %v, want %v", list, want)
+			break
+		}
+	}
+
+	// A loose ref takes precedence over its packed value.
+	mustWrite(t, s, BranchRef("main"), hashC)
+	if hash, err := s.Read(BranchRef("main")); err != nil || hash != hashC {
+		t.Errorf("Read(main) = %s, %v; want the loose %s", hash, err, hashC)
+	}
+
+	// Deleting a packed ref rewrites packed-refs.
+	if err := s.Delete(TagRef("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if s.Exists(TagRef("v1")) {
+		t.Error("refs/tags/v1 still exists after Delete")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, packedRefsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "refs/tags/v1") {
+		t.Errorf("packed-refs still lists the deleted tag:\n%s", data)
+	}
+	if err := s.Delete(TagRef("v1")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Delete = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	s, dir := newStore(t)
+	old := filepath.Join(dir, "branches")
+	if err := os.MkdirAll(old, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, hash := range map[string]string{"main": hashA, "dev": hashB, "empty": ""} {
+		if err := os.WriteFile(filepath.Join(old, name), []byte(hash+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "HEAD"), []byte("branches/dev\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// An existing ref is not overwritten by the old layout.
+	mustWrite(t, s, BranchRef("main"), hashC)
+
+	if err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"main": hashC, "dev": hashB} {
+		if hash, err := s.Read(BranchRef(name)); err != nil || hash != want {
+			t.Errorf("Read(%s) = %s, %v; want %s", name, hash, err, want)
+		}
+	}
+	if s.Exists(BranchRef("empty")) {
+		t.Error("an empty branch file was migrated")
+	}
+	if head, err := s.SymbolicHead(); err != nil || head != "refs/heads/dev" {
+		t.Errorf("SymbolicHead() = %q, %v; want refs/heads/dev", head, err)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("branches directory left behind: %v", err)
+	}
+
+	// Migrating again is a no-op.
+	if err := s.Migrate(); err != nil {
+		t.Errorf("second Migrate = %v", err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	s, dir := newStore(t)
+	mustWrite(t, s, BranchRef("main"), hashA)
+	if _, err := s.Pack(); err != nil {
+		t.Fatal(err)
+	}
+	mustWrite(t, s, BranchRef("loose"), hashA)
+
+	tests := []struct {
+		name     string
+		ref      string
+		old, new string
+		stale    bool
+		want     string
+	}{
+		{name: "create", ref: BranchRef("new"), old: "", new: hashA, want: hashA},
+		{name: "create existing", ref: BranchRef("loose"), old: "", new: hashB, stale: true, want: hashA},
+		{name: "stale loose", ref: BranchRef("loose"), old: hashC, new: hashB, stale: true, want: hashA},
+		{name: "loose", ref: BranchRef("loose"), old: hashA, new: hashB, want: hashB},
+		{name: "stale packed", ref: BranchRef("main"), old: hashB, new: hashC, stale: true, want: hashA},
+		{name: "packed", ref: BranchRef("main"), old: hashA, new: hashC, want: hashC},
+		{name: "stale missing", ref: BranchRef("gone"), old: hashA, new: hashB, stale: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.Update(tt.ref, tt.old, tt.new)
+			if tt.stale != errors.Is(err, ErrStale) || (!tt.stale && err != nil) {
+				t.Fatalf("Update(%s, %.7s, %.7s) = %v, want stale %v", tt.ref, tt.old, tt.new, err, tt.stale)
+			}
+			hash, _ := s.Read(tt.ref)
+			if hash != tt.want {
+				t.Errorf("%s = %q after Update, want %q", tt.ref, hash, tt.want)
+			}
+			if _, err := os.Stat(s.loosePath(tt.ref) + ".lock"); !os.IsNotExist(err) {
+				t.Errorf("lock file left behind: %v", err)
+			}
+		})
+	}
+
+	// A held lock makes a concurrent update fail rather than wait.
+	lock := filepath.Join(dir, "refs", "heads", "loose.lock")
+	if err := os.WriteFile(lock, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Update(BranchRef("loose"), hashB, hashC); !errors.Is(err, ErrStale) {
+		t.Errorf("Update with the ref locked = %v, want ErrStale", err)
+	}
+	if err := s.Write(BranchRef("loose"), hashC); !errors.Is(err, ErrStale) {
+		t.Errorf("Write with the ref locked = %v, want ErrStale", err)
+	}
+	if hash, _ := s.Read(BranchRef("loose")); hash != hashB {
+		t.Errorf("loose = %q after the locked writes, want %q", hash, hashB)
+	}
+	if _, err := os.Stat(lock); err != nil {
+		t.Errorf("another update's lock was removed: %v", err)
+	}
+}
+
+func TestRename(t *testing.T) {
+	s, dir := newStore(t)
+	mustWrite(t, s, BranchRef("main"), hashA)
+	mustWrite(t, s, BranchRef("feat"), hashB)
+	mustWrite(t, s, BranchRef("other"), hashC)
+
+	if err := s.Rename(BranchRef("main"), BranchRef("topic/main")); err != nil {
+		t.Fatal(err)
+	}
+	if hash, _ := s.Read(BranchRef("topic/main")); hash != hashA {
+		t.Errorf("topic/main = %q, want %q", hash, hashA)
+	}
+	if s.Exists(BranchRef("main")) {
+		t.Error("main still exists after the rename")
+	}
+	if head, err := s.SymbolicHead(); err != nil || head != BranchRef("topic/main") {
+		t.Errorf("HEAD = %q, %v, want the renamed branch", head, err)
+	}
+
+	// A rename that cannot write the new name keeps the old one.
+	if err := os.WriteFile(filepath.Join(dir, "refs", "heads", "locked.lock"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"other", "other/x", "locked"} {
+		if err := s.Rename(BranchRef("feat"), BranchRef(name)); err == nil {
+			t.Errorf("Rename(feat, %s) succeeded", name)
+		}
+		if hash, _ := s.Read(BranchRef("feat")); hash != hashB {
+			t.Errorf("feat = %q after a failed rename to %s, want %q", hash, name, hashB)
+		}
+	}
+	if head, _ := s.SymbolicHead(); head != BranchRef("topic/main") {
+		t.Errorf("a failed rename moved HEAD to %q", head)
+	}
+}
@@ -0,0 +1,194 @@
+package remote
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sdk/pkg/api"
+	"sdk/pkg/refs"
+	"slices"
+	"strings"
+)
+
+// The broker transport reaches a repository hosted by the web app, which
+// keeps the objects in a bucket the CLI has no credentials for. A remote
+// URL such as stk+https://app.example.com/alice/llama-ft names the
+// repository slug llama-ft of user alice; the app answers under
+// <host>/api/cli/repos/alice/llama-ft:
+//
+//	GET  <base>/refs     JSON array of {"name", "hash"}
+//	POST <base>/refs     {"name", "old", "new"}; 409 if name is not at old
+//	POST <base>/has      JSON array of keys; answers the subset stored
+//	POST <base>/presign  PresignRequest; answers a PresignResponse
+//
+// after checking the token from stk login against the repository's
+// access list. Objects then travel straight between the CLI and the
+// bucket through the presigned URLs, which carry no credentials and
+// expire after 15 minutes. stk serve answers the same API, so it can
+// stand in for the web app in tests.
+
+// PresignRequest asks for URLs to GET or PUT the objects under Keys.
+type PresignRequest struct {
+	Method string   `json:"method"`
+	Keys   []string `json:"keys"`
+}
+
+// PresignResponse maps each requested key to its presigned URL.
+type PresignResponse struct {
+	URLs map[string]string `json:"urls"`
+}
+
+// BrokerPath is where the web app serves the broker API of a repository,
+// followed by its owner and slug.
+const BrokerPath = "/api/cli/repos/"
+
+// Broker is a repository whose objects are reached through URLs that the
+// web app presigns.
+type Broker struct {
+	url    string
+	base   string
+	token  string
+	client *http.Client
+}
+
+// NewBroker opens a stk+http:// or stk+https:// remote.
+func NewBroker(rawURL, token string) (*Broker, error) {
+	u, err := parseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	repo := strings.Trim(u.Path, "/")
+	scheme := strings.TrimPrefix(u.Scheme, "stk+")
+	base := scheme + "://" + u.Host + BrokerPath + repo
+	return &Broker{url: rawURL, base: base, token: token, client: &http.Client{}}, nil
+}
+
+func (b *Broker) URL() string { return b.url }
+
+// call makes an API request and decodes the answer into result, which may
+// be nil.
+func (b *Broker) call(method, path string, payload, result any) error {
+	body, err := api.CallAPI(method, b.base+path, b.token, payload)
+	var status *api.StatusError
+	if errors.As(err, &status) {
+		msg := strings.TrimSpace(string(status.Body))
+		// The web app answers {"error": "..."}.
+		var body struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(status.Body, &body) == nil && body.Error != "" {
+			msg = body.Error
+		}
+		switch status.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return fmt.Errorf("%s: %w (%s); run stk login", b.url, ErrAccessDenied, msg)
+		case http.StatusConflict:
+			return fmt.Errorf("%s: %w", msg, ErrStale)
+		}
+		return fmt.Errorf("%s: %s", b.url, msg)
+	}
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(body, result)
+}
+
+func (b *Broker) ListRefs() ([]refs.Ref, error) {
+	var wire []struct {
+		Name string `json:"name"`
+		Hash string `json:"hash"`
+	}
+	if err := b.call(http.MethodGet, "/refs", nil, &wire); err != nil {
+		return nil, err
+	}
+	list := make([]refs.Ref, 0, len(wire))
+	for _, r := range wire {
+		if err := ValidateRef(r.Name); err != nil {
+			return nil, err
+		}
+		list = append(list, refs.Ref{Name: r.Name, Hash: r.Hash})
+	}
+	slices.SortFunc(list, func(a, b refs.Ref) int { return strings.Compare(a.Name, b.Name) })
+	return list, nil
+}
+
+func (b *Broker) Has(keys []string) (map[string]bool, error) {
+	var present []string
+	if err := b.call(http.MethodPost, "/has", keys, &present); err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool, len(present))
+	for _, key := range present {
+		found[key] = true
+	}
+	return found, nil
+}
+
+func (b *Broker) UpdateRef(name, old, hash string) error {
+	if err := ValidateRef(name); err != nil {
+		return err
+	}
+	return b.call(http.MethodPost, "/refs", RefUpdate{Name: name, Old: old, New: hash}, nil)
+}
+
+// presign returns the URL to reach key with method.
+func (b *Broker) presign(method, key string) (string, error) {
+	if err := ValidateKey(key); err != nil {
+		return "", err
+	}
+	var resp PresignResponse
+	if err := b.call(http.MethodPost, "/presign", PresignRequest{Method: method, Keys: []string{key}}, &resp); err != nil {
+		return "", err
+	}
+	u, ok := resp.URLs[key]
+	if !ok {
+		return "", fmt.Errorf("%s: no presigned URL for %s", b.url, key)
+	}
+	return u, nil
+}
+
+func (b *Broker) Get(key string) (io.ReadCloser, error) {
+	u, err := b.presign(http.MethodGet, key)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	defer resp.Body.Close()
+	return nil, statusError(resp, "fetching "+key)
+}
+
+func (b *Broker) Put(key string, r io.Reader, size int64) error {
+	u, err := b.presign(http.MethodPut, key)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, u, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return statusError(resp, "uploading "+key)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package remote
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sdk/pkg/refs"
+)
+
+// File is a repository in a local or mounted directory. The directory is
+// laid out like .stk; pointing at a working repository uses its .stk.
+type File struct {
+	root string
+	refs *refs.Store
+}
+
+func NewFile(dir string) (*File, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(filepath.Join(root, ".stk")); err == nil && info.IsDir() {
+		root = filepath.Join(root, ".stk")
+	}
+	return &File{root: root, refs: refs.New(root)}, nil
+}
+
+func (f *File) URL() string { return "file://" + filepath.ToSlash(f.root) }
+
+func (f *File) ListRefs() ([]refs.Ref, error) {
+	return f.refs.List("refs/")
+}
+
+func (f *File) Get(key string) (io.ReadCloser, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filepath.Join(f.root, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+func (f *File) Has(keys []string) (map[string]bool, error) {
+	found := make(map[string]bool)
+	for _, key := range keys {
+		if err := ValidateKey(key); err != nil {
+			return nil, err
+		}
+		_, err := os.Stat(filepath.Join(f.root, filepath.FromSlash(key)))
+		if err == nil {
+			found[key] = true
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+// Put writes to a temporary file first so a reader never sees a partial
+// object.
+func (f *File) Put(key string, r io.Reader, size int64) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	path := filepath.Join(f.root, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".put-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (f *File) UpdateRef(name, old, hash string) error {
+	if err := ValidateRef(name); err != nil {
+		return err
+	}
+	return f.refs.Update(name, old, hash)
+}
+
+// DeleteRef removes name if it points at old. Unlike UpdateRef it does not
+// hold a lock while checking; stk serve uses it to undo its own updates.
+func (f *File) DeleteRef(name, old string) error {
+	if err := ValidateRef(name); err != nil {
+		return err
+	}
+	current, err := f.refs.Read(name)
+	if err != nil {
+		return err
+	}
+	if current != old {
+		return fmt.Errorf("%s is at %s, expected %s: %w", name, current, old, ErrStale)
+	}
+	return f.refs.Delete(name)
+}
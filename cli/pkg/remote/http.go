@@ -0,0 +1,300 @@
+package remote
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sdk/pkg/refs"
+	"slices"
+	"strings"
+)
+
+// The HTTP transport talks to a server exposing the repository at a base
+// URL:
+//
+//	GET  <base>/refs          JSON array of {"name", "hash"}
+//	GET  <base>/object/<key>  object bytes, 404 if missing
+//	PUT  <base>/object/<key>  store the request body
+//	POST <base>/has           JSON array of keys; answers the subset stored
+//	POST <base>/refs          {"name", "old", "new"}; 409 if name is not at old
+//
+// A smart server such as stk serve lists what else it supports in the
+// Stk-Capabilities header of its /refs response and negotiates whole
+// transfers:
+//
+//	POST <base>/fetch-pack    FetchRequest; answers a pack of what is missing
+//	POST <base>/receive-pack  a ReceiveRequest line, then a pack; applies
+//	                          the ref updates atomically, 409 if any is stale
+//
+// Requests carry "Authorization: Bearer <token>" when a token is set.
+
+// RefUpdate is the body of a ref update request.
+type RefUpdate struct {
+	Name string `json:"name"`
+	Old  string `json:"old"`
+	New  string `json:"new"`
+}
+
+// FetchRequest is the body of a fetch-pack request: the commits wanted,
+// the commits the client already has and the filter of a partial clone.
+type FetchRequest struct {
+	Wants  []string `json:"wants"`
+	Haves  []string `json:"haves,omitempty"`
+	Filter string   `json:"filter,omitempty"`
+}
+
+// ReceiveRequest is the first line of a receive-pack request.
+type ReceiveRequest struct {
+	Updates []RefUpdate `json:"updates"`
+}
+
+// PackContentType is the media type of a pack in a request or response.
+const PackContentType = "application/x-stk-pack"
+
+// CapabilitiesHeader is the response header of /refs naming the smart
+// endpoints a server offers, e.g. "fetch-pack receive-pack".
+const CapabilitiesHeader = "Stk-Capabilities"
+
+// HTTP is a repository served over HTTP or HTTPS.
+type HTTP struct {
+	base   string
+	token  string
+	client *http.Client
+	// capabilities is what the server advertised with its refs.
+	capabilities []string
+}
+
+func NewHTTP(base, token string) *HTTP {
+	return &HTTP{base: strings.TrimRight(base, "/"), token: token, client: &http.Client{}}
+}
+
+func (h *HTTP) URL() string { return h.base }
+
+func (h *HTTP) do(method, path string, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequest(method, h.base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %w (%s); run stk login", h.base, ErrAccessDenied, resp.Status)
+	}
+	return resp, nil
+}
+
+// statusError turns an unexpected response into an error carrying the
+// server's message.
+func statusError(resp *http.Response, what string) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	text := strings.TrimSpace(string(msg))
+	if text == "" {
+		text = resp.Status
+	}
+	return fmt.Errorf("%s: %s", what, text)
+}
+
+func (h *HTTP) ListRefs() ([]refs.Ref, error) {
+	resp, err := h.do(http.MethodGet, "/refs", nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp, "listing refs")
+	}
+	h.capabilities = strings.Fields(resp.Header.Get(CapabilitiesHeader))
+
+	var wire []struct {
+		Name string `json:"name"`
+		Hash string `json:"hash"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&wire); err != nil {
+		return nil, fmt.Errorf("listing refs: %w", err)
+	}
+	all := make([]refs.Ref, 0, len(wire))
+	for _, r := range wire {
+		all = append(all, refs.Ref{Name: r.Name, Hash: r.Hash})
+	}
+	return all, nil
+}
+
+func objectURLPath(key string) string {
+	parts := strings.Split(key, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return "/object/" + strings.Join(parts, "/")
+}
+
+func (h *HTTP) Get(key string) (io.ReadCloser, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+	resp, err := h.do(http.MethodGet, objectURLPath(key), nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	defer resp.Body.Close()
+	return nil, statusError(resp, "downloading "+key)
+}
+
+func (h *HTTP) Has(keys []string) (map[string]bool, error) {
+	for _, key := range keys {
+		if err := ValidateKey(key); err != nil {
+			return nil, err
+		}
+	}
+	body, err := json.Marshal(keys)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.do(http.MethodPost, "/has", bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp, "checking objects")
+	}
+
+	var present []string
+	if err := json.NewDecoder(resp.Body).Decode(&present); err != nil {
+		return nil, fmt.Errorf("checking objects: %w", err)
+	}
+	found := make(map[string]bool, len(present))
+	for _, key := range present {
+		found[key] = true
+	}
+	return found, nil
+}
+
+func (h *HTTP) Put(key string, r io.Reader, size int64) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	resp, err := h.do(http.MethodPut, objectURLPath(key), r, size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return statusError(resp, "uploading "+key)
+	}
+	return nil
+}
+
+// supports reports whether the server advertised capability. Refs must
+// have been listed first.
+func (h *HTTP) supports(capability string) bool {
+	return slices.Contains(h.capabilities, capability)
+}
+
+func (h *HTTP) FetchPack(wants, haves []string, filter string) (io.ReadCloser, error) {
+	if !h.supports("fetch-pack") {
+		return nil, errors.ErrUnsupported
+	}
+	body, err := json.Marshal(FetchRequest{Wants: wants, Haves: haves, Filter: filter})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.do(http.MethodPost, "/fetch-pack", bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, statusError(resp, "fetching objects")
+	}
+	return resp.Body, nil
+}
+
+func (h *HTTP) PushPack(updates []RefUpdate, write func(w io.Writer) error) error {
+	if !h.supports("receive-pack") {
+		return errors.ErrUnsupported
+	}
+	for _, update := range updates {
+		if err := ValidateRef(update.Name); err != nil {
+			return err
+		}
+	}
+	header, err := json.Marshal(ReceiveRequest{Updates: updates})
+	if err != nil {
+		return err
+	}
+
+	// Stream the pack as it is written rather than buffering it.
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := pw.Write(append(header, '\n'))
+		if err == nil {
+			err = write(pw)
+		}
+		pw.CloseWithError(err)
+	}()
+	req, err := http.NewRequest(http.MethodPost, h.base+"/receive-pack", pr)
+	if err != nil {
+		pr.Close()
+		return err
+	}
+	req.Header.Set("Content-Type", PackContentType)
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%s: %w (%s); run stk login", h.base, ErrAccessDenied, resp.Status)
+	case resp.StatusCode == http.StatusConflict:
+		return fmt.Errorf("%s: %w", statusError(resp, "updating refs"), ErrStale)
+	case resp.StatusCode/100 != 2:
+		return statusError(resp, "pushing")
+	}
+	return nil
+}
+
+func (h *HTTP) UpdateRef(name, old, hash string) error {
+	if err := ValidateRef(name); err != nil {
+		return err
+	}
+	body, err := json.Marshal(RefUpdate{Name: name, Old: old, New: hash})
+	if err != nil {
+		return err
+	}
+	resp, err := h.do(http.MethodPost, "/refs", bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusConflict:
+		return fmt.Errorf("%s: %w", statusError(resp, "updating "+name), ErrStale)
+	case resp.StatusCode/100 != 2:
+		return statusError(resp, "updating "+name)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+// Package remote moves objects and refs between a repository and the place
+// it is published. Every transport stores the same layout as .stk: object
+// files under keys such as "objects/blobs/ab/cdef…", "commits/…" and
+// "models/…", kept compressed exactly as on disk, and refs such as
+// "refs/heads/main" holding a commit hash.
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sdk/pkg/refs"
+	"strings"
+)
+
+var (
+	// ErrNotFound is returned by Get for a key the remote does not have.
+	ErrNotFound = errors.New("object not found on remote")
+	// ErrStale is returned by UpdateRef when the remote ref no longer
+	// holds the expected value.
+	ErrStale = refs.ErrStale
+	// ErrAccessDenied is returned when the remote rejects the credentials.
+	ErrAccessDenied = errors.New("access denied")
+)
+
+// Remote is a repository reached through one transport.
+type Remote interface {
+	// URL returns the address the remote was opened with.
+	URL() string
+	// ListRefs returns every ref on the remote, sorted by name.
+	ListRefs() ([]refs.Ref, error)
+	// Get opens the object stored under key.
+	Get(key string) (io.ReadCloser, error)
+	// Has reports which of keys the remote already stores. Callers pass
+	// at most BatchSize keys at a time.
+	Has(keys []string) (map[string]bool, error)
+	// Put stores size bytes read from r under key, replacing any object
+	// already there. Objects are content addressed, so a replaced object
+	// has the same content.
+	Put(key string, r io.Reader, size int64) error
+	// UpdateRef points name at hash if it still points at old; an empty
+	// old requires that the ref does not exist yet. It fails with ErrStale
+	// otherwise.
+	UpdateRef(name, old, hash string) error
+}
+
+// BatchSize is how many keys are checked with one call to Has.
+const BatchSize = 256
+
+// PackTransport is implemented by transports whose server works out which
+// objects the other side lacks and moves them as a single pack (see
+// pkg/pack) instead of one request per object. Both methods fail with
+// errors.ErrUnsupported unless the server advertised them with its refs,
+// so ListRefs must be called first.
+type PackTransport interface {
+	// FetchPack streams the objects reachable from the wants commits that
+	// are not reachable from the haves commits, in the order they must be
+	// stored. filter is the filter of a partial clone, or "".
+	FetchPack(wants, haves []string, filter string) (io.ReadCloser, error)
+	// PushPack sends the pack that write produces, then applies updates
+	// all together once the server holds every object they need. If any
+	// ref has moved, none is updated and the error wraps ErrStale.
+	PushPack(updates []RefUpdate, write func(w io.Writer) error) error
+}
+
+// MultipartUploader is implemented by transports that can upload a large
+// object in parts and resume an interrupted upload.
+type MultipartUploader interface {
+	// PutMultipart stores size bytes of r under key in parts of PartSize
+	// bytes, several at a time.
+	PutMultipart(key string, r io.ReaderAt, size int64, opts MultipartOptions) error
+}
+
+// MultipartThreshold is the object size from which callers should prefer
+// PutMultipart, and PartSize the size of each part but the last.
+const (
+	MultipartThreshold = 64 << 20
+	PartSize           = 16 << 20
+)
+
+// MultipartOptions lets a caller persist and resume a multipart upload.
+type MultipartOptions struct {
+	// UploadID continues an upload started earlier; parts already on the
+	// remote are not sent again. An unknown or expired ID starts afresh.
+	UploadID string
+	// Started is called with the ID of a newly started upload so it can
+	// be passed back as UploadID after an interruption.
+	Started func(uploadID string) error
+	// Uploaded is called with the size of every part stored, including
+	// parts found from an earlier attempt.
+	Uploaded func(n int64)
+}
+
+// Options carries what a transport may need besides the URL.
+type Options struct {
+	// Token is sent as a bearer token by the HTTP transport.
+	Token string
+}
+
+// Open picks the transport from the scheme of rawURL: file:// or a plain
+// path for a directory, s3://bucket/prefix for S3 and S3-compatible stores
+// such as MinIO, http:// or https:// for a server such as stk serve, and
+// stk+https:// for a repository of the web app (see Broker).
+func Open(rawURL string, opts Options) (Remote, error) {
+	u, err := parseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "":
+		return NewFile(rawURL)
+	case "file":
+		return NewFile(filepath.FromSlash(u.Path))
+	case "s3":
+		return NewS3(u.Host, strings.Trim(u.Path, "/"))
+	case "stk+http", "stk+https":
+		return NewBroker(rawURL, opts.Token)
+	default:
+		return NewHTTP(rawURL, opts.Token), nil
+	}
+}
+
+// ValidateURL checks that rawURL names a supported transport without
+// connecting to it.
+func ValidateURL(rawURL string) error {
+	_, err := parseURL(rawURL)
+	return err
+}
+
+// parseURL parses a remote URL. A plain path yields a URL without scheme.
+func parseURL(rawURL string) (*url.URL, error) {
+	if rawURL == "" {
+		return nil, errors.New("remote URL is empty")
+	}
+	if !strings.Contains(rawURL, "://") {
+		return &url.URL{Path: rawURL}, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote URL %q: %w", rawURL, err)
+	}
+	switch u.Scheme {
+	case "file":
+		if u.Host != "" && u.Host != "localhost" {
+			return nil, fmt.Errorf("file remote %q must name a local path", rawURL)
+		}
+	case "s3":
+		if u.Host == "" {
+			return nil, fmt.Errorf("s3 remote %q has no bucket", rawURL)
+		}
+	case "http", "https":
+	case "stk+http", "stk+https":
+		if repo := strings.Trim(u.Path, "/"); strings.Count(repo, "/") != 1 {
+			return nil, fmt.Errorf("remote %q must name <owner>/<repository>", rawURL)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported remote URL scheme %q", u.Scheme)
+	}
+	return u, nil
+}
+
+// ValidateKey rejects keys that could escape the repository layout.
+func ValidateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") || path.Clean(key) != key || key == ".." || strings.HasPrefix(key, "../") {
+		return fmt.Errorf("invalid remote key %q", key)
+	}
+	return nil
+}
+
+// ValidateRef checks a fully qualified ref name such as refs/heads/main.
+func ValidateRef(name string) error {
+	if !strings.HasPrefix(name, "refs/") {
+		return fmt.Errorf("invalid remote ref %q: must start with refs/", name)
+	}
+	return refs.ValidateName(strings.TrimPrefix(name, "refs/"))
+}
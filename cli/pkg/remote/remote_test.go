@@ -0,0 +1,133 @@
+package remote
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sdk/pkg/refs"
+	"strings"
+	"testing"
+)
+
+const (
+	hashA = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	hashB = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+)
+
+func TestValidateURL(t *testing.T) {
+	valid := []string{"/mnt/nas/repo", "relative/repo", "file:///mnt/repo", "file://localhost/mnt/repo", "s3://bucket", "s3://bucket/prefix", "https://models.example.com/repo", "stk+https://app.example.com/owner/repo"}
+	invalid := []string{"", "file://host/repo", "s3://", "s3:///prefix", "stk+https://app.example.com/repo", "stk+https://app.example.com/a/b/c", "ftp://host/repo"}
+	for _, url := range valid {
+		if err := ValidateURL(url); err != nil {
+			t.Errorf("ValidateURL(%q) = %v, want nil", url, err)
+		}
+	}
+	for _, url := range invalid {
+		if err := ValidateURL(url); err == nil {
+			t.Errorf("ValidateURL(%q) = nil, want an error", url)
+		}
+	}
+}
+
+func TestValidateKey(t *testing.T) {
+	valid := []string{"objects/blobs/ab/cdef", "commits/ab", "HEAD"}
+	invalid := []string{"", "/etc/passwd", "..", "../x", "a/../../x", "a//b", "a/./b", "a/", `a\b`}
+	for _, key := range valid {
+		if err := ValidateKey(key); err != nil {
+			t.Errorf("ValidateKey(%q) = %v, want nil", key, err)
+		}
+	}
+	for _, key := range invalid {
+		if err := ValidateKey(key); err == nil {
+			t.Errorf("ValidateKey(%q) = nil, want an error", key)
+		}
+	}
+}
+
+func TestFileObjects(t *testing.T) {
+	dir := t.TempDir()
+	r, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const key = "objects/blobs/ab/cdef"
+	if err := r.Put(key, strings.NewReader("content"), 7); err != nil {
+		t.Fatal(err)
+	}
+	has, err := r.Has([]string{key, "objects/blobs/ab/missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(has) != 1 || !has[key] {
+		t.Errorf("Has = %v, want only %s", has, key)
+	}
+	rc, err := r.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(data) != "content" {
+		t.Errorf("Get = %q, %v", data, err)
+	}
+	if _, err := r.Get("objects/blobs/ab/missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a missing key = %v, want ErrNotFound", err)
+	}
+	if err := r.Put("../escape", strings.NewReader("x"), 1); err == nil {
+		t.Error("Put wrote outside the repository")
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(dir, "objects", "blobs", "ab", ".put-*"))
+	if len(leftovers) != 0 {
+		t.Errorf("temporary files left behind: %v", leftovers)
+	}
+}
+
+func TestFileRefs(t *testing.T) {
+	// A working repository is used through its .stk directory.
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, ".stk"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	main := refs.BranchRef("main")
+	if err := f.UpdateRef(main, "", hashA); err != nil {
+		t.Fatal(err)
+	}
+	if hash, err := refs.New(filepath.Join(dir, ".stk")).Read(main); err != nil || hash != hashA {
+		t.Errorf("main = %s, %v in .stk, want %s", hash, err, hashA)
+	}
+	if err := f.UpdateRef(main, "", hashB); !errors.Is(err, ErrStale) {
+		t.Errorf("creating an existing ref = %v, want ErrStale", err)
+	}
+	if err := f.UpdateRef(main, hashB, hashB); !errors.Is(err, ErrStale) {
+		t.Errorf("UpdateRef from a stale value = %v, want ErrStale", err)
+	}
+	if err := f.UpdateRef(main, hashA, hashB); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.UpdateRef("heads/main", "", hashA); err == nil {
+		t.Error("UpdateRef accepted a ref outside refs/")
+	}
+
+	list, err := f.ListRefs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0] != (refs.Ref{Name: main, Hash: hashB}) {
+		t.Errorf("ListRefs = %v", list)
+	}
+
+	if err := f.DeleteRef(main, hashA); !errors.Is(err, ErrStale) {
+		t.Errorf("DeleteRef from a stale value = %v, want ErrStale", err)
+	}
+	if err := f.DeleteRef(main, hashB); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := f.ListRefs(); len(list) != 0 {
+		t.Errorf("ListRefs after DeleteRef = %v", list)
+	}
+}
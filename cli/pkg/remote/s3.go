@@ -0,0 +1,369 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sdk/pkg/refs"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// S3 is a repository stored under a prefix of an S3 bucket. Credentials
+// and region come from the usual AWS environment variables, shared config
+// files or instance role. Setting AWS_ENDPOINT_URL points it at an
+// S3-compatible store such as MinIO, addressed path-style.
+type S3 struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+func NewS3(bucket, prefix string) (*S3, error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS configuration: %w", err)
+	}
+	pathStyle := os.Getenv("AWS_ENDPOINT_URL") != "" || os.Getenv("AWS_ENDPOINT_URL_S3") != ""
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UsePathStyle = pathStyle
+	})
+	return &S3{client: client, bucket: bucket, prefix: prefix}, nil
+}
+
+func (s *S3) URL() string {
+	if s.prefix == "" {
+		return "s3://" + s.bucket
+	}
+	return "s3://" + s.bucket + "/" + s.prefix
+}
+
+func (s *S3) objectKey(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return s.prefix + "/" + key
+}
+
+func (s *S3) ListRefs() ([]refs.Ref, error) {
+	var names []string
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.objectKey("refs/")),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, fmt.Errorf("listing refs in %s: %w", s.URL(), err)
+		}
+		for _, obj := range page.Contents {
+			names = append(names, strings.TrimPrefix(aws.ToString(obj.Key), s.objectKey("")))
+		}
+	}
+	sort.Strings(names)
+
+	var all []refs.Ref
+	for _, name := range names {
+		hash, _, err := s.readRef(name)
+		if errors.Is(err, ErrNotFound) {
+			// Deleted since it was listed.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, refs.Ref{Name: name, Hash: hash})
+	}
+	return all, nil
+}
+
+func (s *S3) Get(key string) (io.ReadCloser, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+	resp, err := s.client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(key)),
+	})
+	if err != nil {
+		if isS3Error(err, "NoSuchKey", "NotFound") {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("downloading %s: %w", key, accessError(err))
+	}
+	return resp.Body, nil
+}
+
+// headConcurrency bounds the HEAD requests Has keeps in flight, since S3
+// has no batch existence check.
+const headConcurrency = 16
+
+func (s *S3) Has(keys []string) (map[string]bool, error) {
+	for _, key := range keys {
+		if err := ValidateKey(key); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		mu       sync.Mutex
+		found    = make(map[string]bool)
+		firstErr error
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, headConcurrency)
+	)
+	for _, key := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(key string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			_, err := s.client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+				Bucket: aws.String(s.bucket),
+				Key:    aws.String(s.objectKey(key)),
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				found[key] = true
+			case isS3Error(err, "NotFound", "NoSuchKey"):
+			case firstErr == nil:
+				firstErr = fmt.Errorf("checking %s: %w", key, err)
+			}
+		}(key)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return found, nil
+}
+
+func (s *S3) Put(key string, r io.Reader, size int64) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	_, err := s.client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(s.objectKey(key)),
+		Body:          r,
+		ContentLength: aws.Int64(size),
+	})
+	if err != nil {
+		return fmt.Errorf("uploading %s: %w", key, accessError(err))
+	}
+	return nil
+}
+
+// partConcurrency bounds the parts of one object uploaded at once.
+const partConcurrency = 4
+
+// PutMultipart uploads a large object in parts. A failed upload is left
+// open on S3 so that passing its ID back resumes it; parts S3 already
+// holds with the right size are kept.
+func (s *S3) PutMultipart(key string, r io.ReaderAt, size int64, opts MultipartOptions) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	objectKey := s.objectKey(key)
+	uploaded := func(n int64) {
+		if opts.Uploaded != nil {
+			opts.Uploaded(n)
+		}
+	}
+
+	uploadID := opts.UploadID
+	existing := make(map[int32]types.Part)
+	if uploadID != "" {
+		parts, err := s.listParts(objectKey, uploadID)
+		switch {
+		case isS3Error(err, "NoSuchUpload"):
+			uploadID = ""
+		case err != nil:
+			return fmt.Errorf("resuming upload of %s: %w", key, err)
+		default:
+			existing = parts
+		}
+	}
+	if uploadID == "" {
+		out, err := s.client.CreateMultipartUpload(context.TODO(), &s3.CreateMultipartUploadInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(objectKey),
+		})
+		if err != nil {
+			return fmt.Errorf("starting upload of %s: %w", key, accessError(err))
+		}
+		uploadID = aws.ToString(out.UploadId)
+		if opts.Started != nil {
+			if err := opts.Started(uploadID); err != nil {
+				return err
+			}
+		}
+	}
+
+	count := int((size + PartSize - 1) / PartSize)
+	completed := make([]types.CompletedPart, count)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		sem      = make(chan struct{}, partConcurrency)
+	)
+	for i := range count {
+		number := int32(i + 1)
+		offset := int64(i) * PartSize
+		length := min(PartSize, size-offset)
+		if part, ok := existing[number]; ok && aws.ToInt64(part.Size) == length {
+			completed[i] = types.CompletedPart{PartNumber: aws.Int32(number), ETag: part.ETag}
+			uploaded(length)
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			out, err := s.client.UploadPart(context.TODO(), &s3.UploadPartInput{
+				Bucket:        aws.String(s.bucket),
+				Key:           aws.String(objectKey),
+				UploadId:      aws.String(uploadID),
+				PartNumber:    aws.Int32(number),
+				Body:          io.NewSectionReader(r, offset, length),
+				ContentLength: aws.Int64(length),
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("uploading part %d of %s: %w", number, key, accessError(err))
+				}
+				return
+			}
+			completed[i] = types.CompletedPart{PartNumber: aws.Int32(number), ETag: out.ETag}
+			uploaded(length)
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+
+	_, err := s.client.CompleteMultipartUpload(context.TODO(), &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(objectKey),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return fmt.Errorf("completing upload of %s: %w", key, err)
+	}
+	return nil
+}
+
+// listParts returns the parts already stored for a multipart upload.
+func (s *S3) listParts(objectKey, uploadID string) (map[int32]types.Part, error) {
+	parts := make(map[int32]types.Part)
+	paginator := s3.NewListPartsPaginator(s.client, &s3.ListPartsInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(objectKey),
+		UploadId: aws.String(uploadID),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range page.Parts {
+			parts[aws.ToInt32(part.PartNumber)] = part
+		}
+	}
+	return parts, nil
+}
+
+// UpdateRef relies on S3 conditional writes: the new value is written only
+// if the ref object still has the ETag it had when it was read, or does
+// not exist when old is empty.
+func (s *S3) UpdateRef(name, old, hash string) error {
+	if err := ValidateRef(name); err != nil {
+		return err
+	}
+	current, etag, err := s.readRef(name)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if current != old {
+		return fmt.Errorf("%s is at %q, expected %q: %w", name, current, old, ErrStale)
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(name)),
+		Body:   bytes.NewReader([]byte(hash + "\n")),
+	}
+	if old == "" {
+		input.IfNoneMatch = aws.String("*")
+	} else {
+		input.IfMatch = aws.String(etag)
+	}
+	if _, err := s.client.PutObject(context.TODO(), input); err != nil {
+		if isS3Error(err, "PreconditionFailed", "ConditionalRequestConflict") {
+			return fmt.Errorf("%s changed during the update: %w", name, ErrStale)
+		}
+		return fmt.Errorf("updating %s: %w", name, err)
+	}
+	return nil
+}
+
+// readRef returns the hash stored in a ref object and the object's ETag.
+func (s *S3) readRef(name string) (string, string, error) {
+	resp, err := s.client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(name)),
+	})
+	if err != nil {
+		if isS3Error(err, "NoSuchKey", "NotFound") {
+			return "", "", ErrNotFound
+		}
+		return "", "", fmt.Errorf("reading %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(string(data)), aws.ToString(resp.ETag), nil
+}
+
+// accessError marks errors caused by missing or wrong credentials, which
+// retrying cannot fix.
+func accessError(err error) error {
+	if isS3Error(err, "AccessDenied", "InvalidAccessKeyId", "SignatureDoesNotMatch", "ExpiredToken") {
+		return fmt.Errorf("%w: %w", ErrAccessDenied, err)
+	}
+	return err
+}
+
+func isS3Error(err error, codes ...string) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	for _, code := range codes {
+		if apiErr.ErrorCode() == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,354 @@
+package safetensors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+const metadataKey = "__metadata__"
+
+type Tensor struct {
+	DType string
+	Shape []int64
+	Data  []byte
+}
+
+// File is a parsed safetensors file. Tensors keep the order in which they
+// were laid out so that a round trip produces the same bytes.
+type File struct {
+	Metadata map[string]string
+	Tensors  map[string]Tensor
+	order    []string
+}
+
+type headerEntry struct {
+	DType       string   `json:"dtype"`
+	Shape       []int64  `json:"shape"`
+	DataOffsets [2]int64 `json:"data_offsets"`
+}
+
+func New() *File {
+	return &File{Tensors: make(map[string]Tensor)}
+}
+
+func Parse(data []byte) (*File, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("safetensors: file too short")
+	}
+	headerLen := binary.LittleEndian.Uint64(data[:8])
+	if headerLen > uint64(len(data)-8) {
+		return nil, fmt.Errorf("safetensors: header length %d exceeds file size", headerLen)
+	}
+	header := data[8 : 8+headerLen]
+	body := data[8+headerLen:]
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(header, &raw); err != nil {
+		return nil, fmt.Errorf("safetensors: invalid header: %w", err)
+	}
+
+	f := New()
+	type located struct {
+		name  string
+		start int64
+	}
+	var names []located
+
+	for name, value := range raw {
+		if name == metadataKey {
+			if err := json.Unmarshal(value, &f.Metadata); err != nil {
+				return nil, fmt.Errorf("safetensors: invalid metadata: %w", err)
+			}
+			continue
+		}
+
+		var entry headerEntry
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return nil, fmt.Errorf("safetensors: invalid entry %q: %w", name, err)
+		}
+		start, end := entry.DataOffsets[0], entry.DataOffsets[1]
+		if start < 0 || end < start || end > int64(len(body)) {
+			return nil, fmt.Errorf("safetensors: tensor %q has invalid offsets [%d, %d]", name, start, end)
+		}
+
+		f.Tensors[name] = Tensor{DType: entry.DType, Shape: entry.Shape, Data: body[start:end]}
+		names = append(names, located{name: name, start: start})
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		if names[i].start != names[j].start {
+			return names[i].start < names[j].start
+		}
+		return names[i].name < names[j].name
+	})
+	for _, n := range names {
+		f.order = append(f.order, n.name)
+	}
+	return f, nil
+}
+
+// TensorInfo describes one tensor of a file without its data.
+type TensorInfo struct {
+	Name  string  `json:"name"`
+	DType string  `json:"dtype"`
+	Shape []int64 `json:"shape"`
+}
+
+// maxHeaderLen bounds the header read by ReadTensorInfo.
+const maxHeaderLen = 100 * 1024 * 1024
+
+// ReadTensorInfo reads only the header of a safetensors stream and lists its
+// tensors in file order, so large files need not be loaded into memory.
+func ReadTensorInfo(r io.Reader) ([]TensorInfo, error) {
+	var lenBuf [8]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, fmt.Errorf("safetensors: file too short")
+	}
+	headerLen := binary.LittleEndian.Uint64(lenBuf[:])
+	if headerLen > maxHeaderLen {
+		return nil, fmt.Errorf("safetensors: header length %d is too large", headerLen)
+	}
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("safetensors: truncated header: %w", err)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(header, &raw); err != nil {
+		return nil, fmt.Errorf("safetensors: invalid header: %w", err)
+	}
+
+	type located struct {
+		info  TensorInfo
+		start int64
+	}
+	var tensors []located
+	for name, value := range raw {
+		if name == metadataKey {
+			continue
+		}
+		var entry headerEntry
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return nil, fmt.Errorf("safetensors: invalid entry %q: %w", name, err)
+		}
+		tensors = append(tensors, located{TensorInfo{Name: name, DType: entry.DType, Shape: entry.Shape}, entry.DataOffsets[0]})
+	}
+
+	sort.Slice(tensors, func(i, j int) bool {
+		if tensors[i].start != tensors[j].start {
+			return tensors[i].start < tensors[j].start
+		}
+		return tensors[i].info.Name < tensors[j].info.Name
+	})
+	infos := make([]TensorInfo, len(tensors))
+	for i, t := range tensors {
+		infos[i] = t.info
+	}
+	return infos, nil
+}
+
+// Names lists the tensors in file order. Tensors added with Set after
+// parsing come last, sorted by name.
+func (f *File) Names() []string {
+	seen := make(map[string]bool, len(f.order))
+	names := make([]string, 0, len(f.Tensors))
+	for _, name := range f.order {
+		if _, ok := f.Tensors[name]; ok && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	var extra []string
+	for name := range f.Tensors {
+		if !seen[name] {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	return append(names, extra...)
+}
+
+func (f *File) Set(name string, t Tensor) {
+	if _, ok := f.Tensors[name]; !ok {
+		f.order = append(f.order, name)
+	}
+	f.Tensors[name] = t
+}
+
+// Bytes serializes the file. The header is padded with spaces to a multiple
+// of 8 bytes as the reference implementation does.
+func (f *File) Bytes() ([]byte, error) {
+	header := make(map[string]interface{}, len(f.Tensors)+1)
+	if len(f.Metadata) > 0 {
+		header[metadataKey] = f.Metadata
+	}
+
+	var body bytes.Buffer
+	for _, name := range f.Names() {
+		t := f.Tensors[name]
+		start := int64(body.Len())
+		body.Write(t.Data)
+		header[name] = headerEntry{DType: t.DType, Shape: t.Shape, DataOffsets: [2]int64{start, int64(body.Len())}}
+	}
+
+	headerBytes, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	for len(headerBytes)%8 != 0 {
+		headerBytes = append(headerBytes, ' ')
+	}
+
+	out := make([]byte, 8, 8+len(headerBytes)+body.Len())
+	binary.LittleEndian.PutUint64(out, uint64(len(headerBytes)))
+	out = append(out, headerBytes...)
+	out = append(out, body.Bytes()...)
+	return out, nil
+}
+
+// IsFloat reports whether values of dtype can be converted with Floats.
+func IsFloat(dtype string) bool {
+	switch dtype {
+	case "F64", "F32", "F16", "BF16":
+		return true
+	}
+	return false
+}
+
+// Floats decodes a floating point tensor into float64 values.
+func (t Tensor) Floats() ([]float64, error) {
+	size, err := elementSize(t.DType)
+	if err != nil {
+		return nil, err
+	}
+	if len(t.Data)%size != 0 {
+		return nil, fmt.Errorf("safetensors: %d bytes is not a multiple of %s", len(t.Data), t.DType)
+	}
+
+	n := len(t.Data) / size
+	values := make([]float64, n)
+	for i := 0; i < n; i++ {
+		b := t.Data[i*size : (i+1)*size]
+		switch t.DType {
+		case "F64":
+			values[i] = math.Float64frombits(binary.LittleEndian.Uint64(b))
+		case "F32":
+			values[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		case "F16":
+			values[i] = float64(halfToFloat32(binary.LittleEndian.Uint16(b)))
+		case "BF16":
+			values[i] = float64(math.Float32frombits(uint32(binary.LittleEndian.Uint16(b)) << 16))
+		}
+	}
+	return values, nil
+}
+
+// FromFloats encodes values as a tensor of the given floating point dtype.
+func FromFloats(dtype string, shape []int64, values []float64) (Tensor, error) {
+	size, err := elementSize(dtype)
+	if err != nil {
+		return Tensor{}, err
+	}
+
+	data := make([]byte, len(values)*size)
+	for i, v := range values {
+		b := data[i*size : (i+1)*size]
+		switch dtype {
+		case "F64":
+			binary.LittleEndian.PutUint64(b, math.Float64bits(v))
+		case "F32":
+			binary.LittleEndian.PutUint32(b, math.Float32bits(float32(v)))
+		case "F16":
+			binary.LittleEndian.PutUint16(b, float32ToHalf(float32(v)))
+		case "BF16":
+			binary.LittleEndian.PutUint16(b, float32ToBFloat16(float32(v)))
+		}
+	}
+	return Tensor{DType: dtype, Shape: append([]int64(nil), shape...), Data: data}, nil
+}
+
+func elementSize(dtype string) (int, error) {
+	switch dtype {
+	case "F64":
+		return 8, nil
+	case "F32":
+		return 4, nil
+	case "F16", "BF16":
+		return 2, nil
+	}
+	return 0, fmt.Errorf("safetensors: dtype %s is not a floating point type", dtype)
+}
+
+func halfToFloat32(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h) & 0x3ff
+
+	switch {
+	case exp == 0 && mant == 0:
+		return math.Float32frombits(sign)
+	case exp == 0:
+		// Subnormal: normalise the mantissa.
+		for mant&0x400 == 0 {
+			mant <<= 1
+			exp--
+		}
+		exp++
+		mant &= 0x3ff
+	case exp == 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	}
+	return math.Float32frombits(sign | (exp+112)<<23 | mant<<13)
+}
+
+func float32ToHalf(f float32) uint16 {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exp := int32(bits>>23) & 0xff
+	mant := bits & 0x7fffff
+
+	if exp == 0xff {
+		if mant != 0 {
+			return sign | 0x7e00
+		}
+		return sign | 0x7c00
+	}
+
+	exp = exp - 127 + 15
+	switch {
+	case exp >= 0x1f:
+		return sign | 0x7c00
+	case exp <= 0:
+		if exp < -10 {
+			return sign
+		}
+		mant |= 0x800000
+		shift := uint32(14 - exp)
+		half := uint16(mant >> shift)
+		if mant>>(shift-1)&1 == 1 && (mant&(1<<(shift-1)-1) != 0 || half&1 == 1) {
+			half++
+		}
+		return sign | half
+	}
+
+	half := sign | uint16(exp)<<10 | uint16(mant>>13)
+	if mant&0x1000 != 0 && (mant&0xfff != 0 || half&1 == 1) {
+		half++
+	}
+	return half
+}
+
+func float32ToBFloat16(f float32) uint16 {
+	bits := math.Float32bits(f)
+	if bits&0x7fffffff > 0x7f800000 {
+		return uint16(bits>>16) | 0x40
+	}
+	rounding := uint32(0x7fff) + (bits>>16)&1
+	return uint16((bits + rounding) >> 16)
+}
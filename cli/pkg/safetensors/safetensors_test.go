@@ -0,0 +1,169 @@
+package safetensors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	f := New()
+	f.Metadata = map[string]string{"format": "pt"}
+	f.Set("layer.1.weight", Tensor{DType: "F32", Shape: []int64{2}, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}})
+	f.Set("layer.0.weight", Tensor{DType: "I8", Shape: []int64{3}, Data: []byte{9, 10, 11}})
+	f.Set("empty", Tensor{DType: "F16", Shape: []int64{0}, Data: []byte{}})
+
+	data, err := f.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headerLen := binary.LittleEndian.Uint64(data[:8]); headerLen%8 != 0 {
+		t.Errorf("header length %d is not padded to 8 bytes", headerLen)
+	}
+
+	parsed, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed.Metadata, f.Metadata) {
+		t.Errorf("Metadata = %v, want %v", parsed.Metadata, f.Metadata)
+	}
+	wantNames := []string{"layer.1.weight", "layer.0.weight", "empty"}
+	if names := parsed.Names(); !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("Names() = %v, want the layout order %v", names, wantNames)
+	}
+	for name, want := range f.Tensors {
+		got := parsed.Tensors[name]
+		if got.DType != want.DType || !reflect.DeepEqual(got.Shape, want.Shape) || !bytes.Equal(got.Data, want.Data) {
+			t.Errorf("tensor %s = %+v, want %+v", name, got, want)
+		}
+	}
+
+	again, err := parsed.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, data) {
+		t.Error("a parsed file does not serialize to the same bytes")
+	}
+
+	infos, err := ReadTensorInfo(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantInfos := []TensorInfo{
+		{Name: "layer.1.weight", DType: "F32", Shape: []int64{2}},
+		{Name: "layer.0.weight", DType: "I8", Shape: []int64{3}},
+		{Name: "empty", DType: "F16", Shape: []int64{0}},
+	}
+	if !reflect.DeepEqual(infos, wantInfos) {
+		t.Errorf("ReadTensorInfo = %+v, want %+v", infos, wantInfos)
+	}
+}
+
+func TestSetAfterParse(t *testing.T) {
+	f := New()
+	f.Set("b", Tensor{DType: "U8", Shape: []int64{1}, Data: []byte{1}})
+	data, err := f.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed.Set("a", Tensor{DType: "U8", Shape: []int64{1}, Data: []byte{2}})
+	parsed.Set("b", Tensor{DType: "U8", Shape: []int64{1}, Data: []byte{3}})
+	if names := parsed.Names(); !reflect.DeepEqual(names, []string{"b", "a"}) {
+		t.Errorf("Names() = %v, want [b a]", names)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	header := func(json string) []byte {
+		out := make([]byte, 8)
+		binary.LittleEndian.PutUint64(out, uint64(len(json)))
+		return append(out, json...)
+	}
+	tests := map[string][]byte{
+		"too short":       {1, 2, 3},
+		"header too long": append([]byte{0xff, 0, 0, 0, 0, 0, 0, 0}, "{}"...),
+		"not json":        header("{nope"),
+		"bad offsets":     header(`{"w":{"dtype":"F32","shape":[1],"data_offsets":[0,4]}}`),
+		"reversed":        append(header(`{"w":{"dtype":"U8","shape":[1],"data_offsets":[1,0]}}`), 0),
+		"bad metadata":    header(`{"__metadata__":[1]}`),
+	}
+	for name, data := range tests {
+		if _, err := Parse(data); err == nil {
+			t.Errorf("%s: Parse succeeded", name)
+		}
+	}
+}
+
+func TestFloats(t *testing.T) {
+	values := []float64{0, 1, -2, 0.5, 0.25, -1024, 3.140625}
+	for _, dtype := range []string{"F64", "F32", "F16", "BF16"} {
+		if !IsFloat(dtype) {
+			t.Errorf("IsFloat(%s) = false", dtype)
+		}
+		tensor, err := FromFloats(dtype, []int64{int64(len(values))}, values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := tensor.Floats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, values) {
+			t.Errorf("%s round trip = %v, want %v", dtype, got, values)
+		}
+	}
+	if IsFloat("I32") {
+		t.Error("IsFloat(I32) = true")
+	}
+	if _, err := (Tensor{DType: "I32", Data: make([]byte, 4)}).Floats(); err == nil {
+		t.Error("Floats of an I32 tensor succeeded")
+	}
+	if _, err := (Tensor{DType: "F32", Data: make([]byte, 6)}).Floats(); err == nil {
+		t.Error("Floats of a truncated F32 tensor succeeded")
+	}
+}
+
+func TestHalfPrecision(t *testing.T) {
+	tests := []struct {
+		in, want float64
+	}{
+		{65504, 65504},                           // largest finite half
+		{1e6, math.Inf(1)},                       // overflows
+		{-1e6, math.Inf(-1)},                     // overflows
+		{math.Ldexp(1, -24), math.Ldexp(1, -24)}, // smallest subnormal
+		{math.Ldexp(1, -20), math.Ldexp(1, -20)}, // subnormal
+		{math.Ldexp(1, -30), 0},                  // underflows
+		{1 + math.Ldexp(1, -11), 1},              // ties to even
+		{1 + 3*math.Ldexp(1, -11), 1 + math.Ldexp(1, -9)},
+	}
+	for _, tt := range tests {
+		tensor, err := FromFloats("F16", []int64{1}, []float64{tt.in})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := tensor.Floats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got[0] != tt.want {
+			t.Errorf("F16(%g) = %g, want %g", tt.in, got[0], tt.want)
+		}
+	}
+
+	nan, _ := FromFloats("F16", []int64{1}, []float64{math.NaN()})
+	if got, _ := nan.Floats(); !math.IsNaN(got[0]) {
+		t.Errorf("F16(NaN) = %g", got[0])
+	}
+	nan, _ = FromFloats("BF16", []int64{1}, []float64{math.NaN()})
+	if got, _ := nan.Floats(); !math.IsNaN(got[0]) {
+		t.Errorf("BF16(NaN) = %g", got[0])
+	}
+}
@@ -0,0 +1,153 @@
+package transfer
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Journal records the progress of a push so that running it again after
+// an interruption skips what was already uploaded and continues multipart
+// uploads instead of restarting them. It is an append-only text file:
+//
+//	remote s3://bucket/repo
+//	done objects/blobs/ab/cdef…
+//	upload objects/blobs/12/3456… <multipart upload ID>
+//
+// A journal written for a different remote is discarded.
+type Journal struct {
+	path string
+
+	mu      sync.Mutex
+	file    *os.File
+	done    map[string]bool
+	uploads map[string]string
+}
+
+// OpenJournal loads the journal at path if it was written for remoteURL
+// and starts a new one otherwise.
+func OpenJournal(path, remoteURL string) (*Journal, error) {
+	j := &Journal{path: path, done: make(map[string]bool), uploads: make(map[string]string)}
+
+	fresh, cut := true, false
+	if f, err := os.Open(path); err == nil {
+		reader := bufio.NewReader(f)
+		for first := true; ; first = false {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				// EOF, possibly after a line cut short by a crash.
+				cut = line != ""
+				break
+			}
+			kind, rest, _ := strings.Cut(strings.TrimSuffix(line, "\n"), " ")
+			if first {
+				fresh = kind != "remote" || rest != remoteURL
+				if fresh {
+					break
+				}
+				continue
+			}
+			switch kind {
+			case "done":
+				j.done[rest] = true
+				delete(j.uploads, rest)
+			case "upload":
+				if key, id, ok := strings.Cut(rest, " "); ok {
+					j.uploads[key] = id
+				}
+			}
+		}
+		f.Close()
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if fresh {
+		j.done = make(map[string]bool)
+		j.uploads = make(map[string]string)
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	j.file = f
+	if cut && !fresh {
+		// Start the next entry on a line of its own.
+		if _, err := f.WriteString("\n"); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if fresh {
+		if err := j.append("remote", remoteURL); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+// Resumed reports how many objects an earlier, interrupted run uploaded.
+func (j *Journal) Resumed() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.done)
+}
+
+// Done reports whether key was uploaded by an earlier run.
+func (j *Journal) Done(key string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.done[key]
+}
+
+// MarkDone records that key is on the remote.
+func (j *Journal) MarkDone(key string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.done[key] = true
+	delete(j.uploads, key)
+	return j.append("done", key)
+}
+
+// UploadID returns the multipart upload an earlier run started for key.
+func (j *Journal) UploadID(key string) string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.uploads[key]
+}
+
+// SetUploadID records the multipart upload started for key.
+func (j *Journal) SetUploadID(key, id string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.uploads[key] = id
+	return j.append("upload", key+" "+id)
+}
+
+// Close closes the journal, keeping it for the next run.
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
+
+// Remove closes and deletes the journal once the push has completed.
+func (j *Journal) Remove() error {
+	j.file.Close()
+	if err := os.Remove(j.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// append writes one line. Each line goes out in a single write, so a
+// crash leaves at worst a truncated last line, which is ignored.
+func (j *Journal) append(kind, value string) error {
+	if _, err := fmt.Fprintf(j.file, "%s %s\n", kind, value); err != nil {
+		return fmt.Errorf("writing transfer journal: %w", err)
+	}
+	return nil
+}
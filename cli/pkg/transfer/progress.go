@@ -0,0 +1,152 @@
+package transfer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-isatty"
+)
+
+// redrawInterval limits how often the progress bar is redrawn.
+const redrawInterval = 100 * time.Millisecond
+
+const barWidth = 24
+
+// Progress draws a progress bar with throughput and estimated time left,
+// such as
+//
+//	Writing objects [#########---------------]  38% 120/310 1.2 GiB/3.1 GiB 45.3 MiB/s ETA 41s
+//
+// It only draws when its output is a terminal. All methods are safe for
+// concurrent use and do nothing on a nil *Progress.
+type Progress struct {
+	label string
+	out   io.Writer
+
+	mu         sync.Mutex
+	objects    int
+	total      int
+	bytes      int64
+	totalBytes int64
+	start      time.Time
+	drawn      time.Time
+}
+
+// NewProgress starts a progress bar for objects objects holding
+// totalBytes bytes; totalBytes is 0 when the sizes are not known, and the
+// time left is then estimated from the object count. When neither is
+// known only the counts and throughput are shown. It returns nil when
+// standard error is not a terminal.
+func NewProgress(label string, objects int, totalBytes int64) *Progress {
+	if !isatty.IsTerminal(os.Stderr.Fd()) && !isatty.IsCygwinTerminal(os.Stderr.Fd()) {
+		return nil
+	}
+	return &Progress{
+		label:      label,
+		out:        os.Stderr,
+		total:      objects,
+		totalBytes: totalBytes,
+		start:      time.Now(),
+	}
+}
+
+// Add counts n more bytes as transferred; n is negative when a failed
+// attempt is taken back.
+func (p *Progress) Add(n int64) {
+	if p == nil || n == 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bytes += n
+	p.redraw(false)
+}
+
+// Done counts n more objects as transferred.
+func (p *Progress) Done(n int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.objects += n
+	p.redraw(false)
+}
+
+// Finish draws the final state and ends the line.
+func (p *Progress) Finish() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.redraw(true)
+	fmt.Fprintln(p.out)
+}
+
+func (p *Progress) redraw(force bool) {
+	now := time.Now()
+	if !force && now.Sub(p.drawn) < redrawInterval {
+		return
+	}
+	p.drawn = now
+	elapsed := now.Sub(p.start)
+
+	var line strings.Builder
+	if p.total == 0 && p.totalBytes == 0 {
+		// Nothing to measure against, as when reading a pack.
+		fmt.Fprintf(&line, "%s %d %s ", p.label, p.objects, FormatBytes(p.bytes))
+		if seconds := elapsed.Seconds(); seconds > 0 {
+			fmt.Fprintf(&line, "%s/s", FormatBytes(int64(float64(p.bytes)/seconds)))
+		}
+		fmt.Fprintf(p.out, "\r%s\x1b[K", line.String())
+		return
+	}
+
+	fraction := 1.0
+	switch {
+	case p.totalBytes > 0:
+		fraction = float64(p.bytes) / float64(p.totalBytes)
+	case p.total > 0:
+		fraction = float64(p.objects) / float64(p.total)
+	}
+	fraction = min(max(fraction, 0), 1)
+	filled := int(fraction * barWidth)
+
+	fmt.Fprintf(&line, "%s [%s%s] %3d%% %d/%d ", p.label,
+		strings.Repeat("#", filled), strings.Repeat("-", barWidth-filled),
+		int(fraction*100), p.objects, p.total)
+	if p.totalBytes > 0 {
+		fmt.Fprintf(&line, "%s/%s ", FormatBytes(p.bytes), FormatBytes(p.totalBytes))
+	} else {
+		fmt.Fprintf(&line, "%s ", FormatBytes(p.bytes))
+	}
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		fmt.Fprintf(&line, "%s/s", FormatBytes(int64(float64(p.bytes)/seconds)))
+	}
+	if fraction > 0 && fraction < 1 {
+		left := time.Duration(float64(elapsed) * (1 - fraction) / fraction)
+		fmt.Fprintf(&line, " ETA %s", left.Round(time.Second))
+	}
+	// Return to the start of the line and clear what a longer previous
+	// line left behind.
+	fmt.Fprintf(p.out, "\r%s\x1b[K", line.String())
+}
+
+// FormatBytes renders n with a binary unit, e.g. "1.5 MiB".
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, exp := float64(n)/unit, 0
+	for value >= unit && exp < 4 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTP"[exp])
+}
@@ -0,0 +1,147 @@
+// Package transfer moves many objects between a repository and a remote:
+// it runs a bounded number of transfers at once, retries transient
+// failures with exponential backoff, records progress in a journal so an
+// interrupted push can resume, and draws a progress bar.
+package transfer
+
+import (
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultConcurrency is how many objects are transferred at once
+	// unless configured otherwise. Chunks are small, so latency rather
+	// than bandwidth bounds a sequential transfer.
+	DefaultConcurrency = 16
+	// DefaultRetries is how many times a failed transfer is retried.
+	DefaultRetries = 5
+
+	initialBackoff = 500 * time.Millisecond
+	maxBackoff     = 30 * time.Second
+)
+
+// Job is one object to transfer.
+type Job struct {
+	Key string
+	// Size is the number of bytes moved, when known up front.
+	Size int64
+}
+
+// Task transfers one object. It may report bytes through count as they
+// are moved, from any goroutine; whatever it does not report is counted
+// when it succeeds.
+type Task func(job Job, count func(n int64)) error
+
+// Options tunes Run. Zero values select the defaults.
+type Options struct {
+	Concurrency int
+	Retries     int
+	// Progress, when set, is advanced as jobs complete.
+	Progress *Progress
+}
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying, such as a missing object or
+// a rejected credential.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// IsPermanent reports whether err was marked with Permanent.
+func IsPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+// Run calls task for every job, at most opts.Concurrency at a time, and
+// retries a failed job with exponential backoff. After a job fails for
+// good no further jobs are started; Run waits for those in flight and
+// returns the first error.
+func Run(jobs []Job, opts Options, task Task) error {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	retries := opts.Retries
+	if retries <= 0 {
+		retries = DefaultRetries
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		queue    = make(chan Job)
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+	for range min(concurrency, len(jobs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				if failed() {
+					continue
+				}
+				if err := runJob(job, retries, opts.Progress, task); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, job := range jobs {
+		if failed() {
+			break
+		}
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+	return firstErr
+}
+
+// runJob runs task for job until it succeeds, fails permanently or runs
+// out of retries. Bytes counted by a failed attempt are taken back.
+func runJob(job Job, retries int, progress *Progress, task Task) error {
+	backoff := initialBackoff
+	for attempt := 0; ; attempt++ {
+		var counted atomic.Int64
+		count := func(n int64) {
+			counted.Add(n)
+			progress.Add(n)
+		}
+		err := task(job, count)
+		if err == nil {
+			if rest := job.Size - counted.Load(); rest > 0 {
+				progress.Add(rest)
+			}
+			progress.Done(1)
+			return nil
+		}
+		progress.Add(-counted.Load())
+		if IsPermanent(err) || attempt >= retries {
+			return err
+		}
+		// Jitter keeps parallel workers from retrying in lockstep.
+		time.Sleep(backoff/2 + rand.N(backoff/2))
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
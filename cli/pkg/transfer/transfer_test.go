@@ -0,0 +1,176 @@
+package transfer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func jobs(n int) []Job {
+	list := make([]Job, n)
+	for i := range list {
+		list[i] = Job{Key: string(rune('a' + i)), Size: 10}
+	}
+	return list
+}
+
+func TestRunConcurrency(t *testing.T) {
+	var running, peak atomic.Int32
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	err := Run(jobs(20), Options{Concurrency: 3}, func(job Job, count func(int64)) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		mu.Lock()
+		seen[job.Key] = true
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 20 {
+		t.Errorf("ran %d of 20 jobs", len(seen))
+	}
+	if peak.Load() > 3 {
+		t.Errorf("%d jobs ran at once, want at most 3", peak.Load())
+	}
+}
+
+func TestRunRetries(t *testing.T) {
+	var attempts atomic.Int32
+	errFlaky := errors.New("connection reset")
+	err := Run(jobs(1), Options{Retries: 2}, func(job Job, count func(int64)) error {
+		if attempts.Add(1) < 2 {
+			return errFlaky
+		}
+		return nil
+	})
+	if err != nil || attempts.Load() != 2 {
+		t.Errorf("Run = %v after %d attempts, want success on the second", err, attempts.Load())
+	}
+
+	attempts.Store(0)
+	err = Run(jobs(1), Options{Retries: 1}, func(job Job, count func(int64)) error {
+		attempts.Add(1)
+		return errFlaky
+	})
+	if !errors.Is(err, errFlaky) || attempts.Load() != 2 {
+		t.Errorf("Run = %v after %d attempts, want the error after one retry", err, attempts.Load())
+	}
+}
+
+func TestRunPermanent(t *testing.T) {
+	errGone := errors.New("object not found")
+	var started atomic.Int32
+	err := Run(jobs(10), Options{Concurrency: 1}, func(job Job, count func(int64)) error {
+		started.Add(1)
+		if job.Key == "a" {
+			return Permanent(errGone)
+		}
+		return nil
+	})
+	if !errors.Is(err, errGone) || !IsPermanent(err) {
+		t.Errorf("Run = %v, want the permanent error", err)
+	}
+	// The failing job is not retried and stops the jobs after it; one
+	// may already have been handed to the worker.
+	if n := started.Load(); n > 2 {
+		t.Errorf("%d jobs ran after a permanent failure", n-1)
+	}
+	if Permanent(nil) != nil {
+		t.Error("Permanent(nil) != nil")
+	}
+}
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	const url = "s3://bucket/repo"
+	j, err := OpenJournal(path, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.MarkDone("objects/blobs/aa/1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.SetUploadID("objects/blobs/bb/2", "upload-2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.SetUploadID("objects/blobs/cc/3", "upload-3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.MarkDone("objects/blobs/cc/3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// A crash in the middle of a line leaves it cut short.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("done objects/blo")
+	f.Close()
+
+	j, err = OpenJournal(path, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := j.Resumed(); got != 2 {
+		t.Errorf("Resumed() = %d, want 2", got)
+	}
+	for key, want := range map[string]bool{"objects/blobs/aa/1": true, "objects/blobs/bb/2": false, "objects/blobs/cc/3": true, "objects/blo": false} {
+		if j.Done(key) != want {
+			t.Errorf("Done(%s) = %v, want %v", key, !want, want)
+		}
+	}
+	if id := j.UploadID("objects/blobs/bb/2"); id != "upload-2" {
+		t.Errorf("UploadID of the unfinished upload = %q", id)
+	}
+	if id := j.UploadID("objects/blobs/cc/3"); id != "" {
+		t.Errorf("UploadID of a finished upload = %q", id)
+	}
+	if err := j.MarkDone("objects/blobs/bb/2"); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "objects/blo\ndone objects/blobs/bb/2\n") {
+		t.Errorf("entry after the cut line is not on its own line:\n%s", data)
+	}
+
+	// A journal for another remote starts afresh.
+	j, err = OpenJournal(path, "s3://bucket/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Resumed() != 0 || j.Done("objects/blobs/aa/1") {
+		t.Error("journal of another remote was reused")
+	}
+	if err := j.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("journal left behind after Remove: %v", err)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := map[int64]string{0: "0 B", 1023: "1023 B", 1024: "1.0 KiB", 1536: "1.5 KiB", 5 << 30: "5.0 GiB"}
+	for n, want := range tests {
+		if got := FormatBytes(n); got != want {
+			t.Errorf("FormatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
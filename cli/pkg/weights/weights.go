@@ -0,0 +1,195 @@
+package weights
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Method is a strategy for combining two fine-tuned versions of the same
+// tensor, optionally relative to the weights they both started from.
+type Method string
+
+const (
+	Average        Method = "avg"
+	TaskArithmetic Method = "task-arith"
+	TIES           Method = "ties"
+	SLERP          Method = "slerp"
+)
+
+var Methods = []Method{Average, TaskArithmetic, TIES, SLERP}
+
+type Options struct {
+	// Alpha is the interpolation weight towards theirs for avg and slerp,
+	// and the scale applied to the combined task vector for task-arith
+	// and ties.
+	Alpha float64
+	// Density is the fraction of each task vector kept by ties.
+	Density float64
+}
+
+func ParseMethod(s string) (Method, error) {
+	for _, m := range Methods {
+		if string(m) == s {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("unknown merge method %q (expected avg, task-arith, ties or slerp)", s)
+}
+
+// NeedsBase reports whether the method works on task vectors and therefore
+// needs the merge base's weights.
+func (m Method) NeedsBase() bool {
+	return m == TaskArithmetic || m == TIES
+}
+
+// DefaultAlpha is used when no alpha was given on the command line.
+func (m Method) DefaultAlpha() float64 {
+	if m.NeedsBase() {
+		return 1.0
+	}
+	return 0.5
+}
+
+// Merge combines ours and theirs element-wise. base may be nil for methods
+// that do not need it. All slices must have the same length.
+func Merge(m Method, base, ours, theirs []float64, opts Options) ([]float64, error) {
+	if len(ours) != len(theirs) || (base != nil && len(base) != len(ours)) {
+		return nil, fmt.Errorf("tensor lengths differ")
+	}
+	if m.NeedsBase() && base == nil {
+		return nil, fmt.Errorf("%s needs the merge base weights", m)
+	}
+
+	switch m {
+	case Average:
+		return lerp(ours, theirs, opts.Alpha), nil
+	case TaskArithmetic:
+		return taskArithmetic(base, ours, theirs, opts.Alpha), nil
+	case TIES:
+		return ties(base, ours, theirs, opts.Alpha, opts.Density), nil
+	case SLERP:
+		return slerp(ours, theirs, opts.Alpha), nil
+	}
+	return nil, fmt.Errorf("unknown merge method %q", m)
+}
+
+func lerp(a, b []float64, t float64) []float64 {
+	out := make([]float64, len(a))
+	for i := range a {
+		out[i] = (1-t)*a[i] + t*b[i]
+	}
+	return out
+}
+
+// taskArithmetic adds the scaled sum of both task vectors to base.
+func taskArithmetic(base, ours, theirs []float64, scale float64) []float64 {
+	out := make([]float64, len(base))
+	for i := range base {
+		out[i] = base[i] + scale*((ours[i]-base[i])+(theirs[i]-base[i]))
+	}
+	return out
+}
+
+// ties implements TIES-merging: each task vector is trimmed to its largest
+// entries by magnitude, a sign is elected per element from the trimmed sum,
+// and only the entries agreeing with that sign are averaged.
+func ties(base, ours, theirs []float64, scale, density float64) []float64 {
+	tasks := [][]float64{
+		trim(subtract(ours, base), density),
+		trim(subtract(theirs, base), density),
+	}
+
+	out := make([]float64, len(base))
+	for i := range base {
+		var sum float64
+		for _, task := range tasks {
+			sum += task[i]
+		}
+		sign := math.Copysign(1, sum)
+		if sum == 0 {
+			out[i] = base[i]
+			continue
+		}
+
+		var total float64
+		var count int
+		for _, task := range tasks {
+			if task[i] != 0 && math.Signbit(task[i]) == math.Signbit(sign) {
+				total += task[i]
+				count++
+			}
+		}
+		merged := 0.0
+		if count > 0 {
+			merged = total / float64(count)
+		}
+		out[i] = base[i] + scale*merged
+	}
+	return out
+}
+
+func subtract(a, b []float64) []float64 {
+	out := make([]float64, len(a))
+	for i := range a {
+		out[i] = a[i] - b[i]
+	}
+	return out
+}
+
+// trim zeroes all but the top density fraction of entries by magnitude.
+func trim(task []float64, density float64) []float64 {
+	if density >= 1 || len(task) == 0 {
+		return task
+	}
+	keep := int(math.Ceil(density * float64(len(task))))
+	if keep <= 0 {
+		return make([]float64, len(task))
+	}
+
+	magnitudes := make([]float64, len(task))
+	for i, v := range task {
+		magnitudes[i] = math.Abs(v)
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(magnitudes)))
+	threshold := magnitudes[keep-1]
+
+	out := make([]float64, len(task))
+	for i, v := range task {
+		if math.Abs(v) >= threshold {
+			out[i] = v
+		}
+	}
+	return out
+}
+
+// slerp interpolates along the great circle between the two tensors viewed
+// as flat vectors, falling back to linear interpolation when they are nearly
+// parallel.
+func slerp(a, b []float64, t float64) []float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return lerp(a, b, t)
+	}
+
+	cos := dot / (math.Sqrt(normA) * math.Sqrt(normB))
+	if math.Abs(cos) > 0.9995 {
+		return lerp(a, b, t)
+	}
+
+	theta := math.Acos(cos)
+	sinTheta := math.Sin(theta)
+	wa := math.Sin((1-t)*theta) / sinTheta
+	wb := math.Sin(t*theta) / sinTheta
+
+	out := make([]float64, len(a))
+	for i := range a {
+		out[i] = wa*a[i] + wb*b[i]
+	}
+	return out
+}
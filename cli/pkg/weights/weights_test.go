@@ -0,0 +1,127 @@
+package weights
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name               string
+		method             Method
+		base, ours, theirs []float64
+		opts               Options
+		want               []float64
+	}{
+		{
+			name: "avg", method: Average,
+			ours: []float64{1, 2, -4}, theirs: []float64{3, 4, 4},
+			opts: Options{Alpha: 0.5},
+			want: []float64{2, 3, 0},
+		},
+		{
+			name: "avg towards theirs", method: Average,
+			ours: []float64{0, 10}, theirs: []float64{4, 0},
+			opts: Options{Alpha: 0.25},
+			want: []float64{1, 7.5},
+		},
+		{
+			name: "task-arith", method: TaskArithmetic,
+			base: []float64{1, 1, 1}, ours: []float64{2, 1, 0}, theirs: []float64{1, 3, 0},
+			opts: Options{Alpha: 1},
+			want: []float64{2, 3, -1},
+		},
+		{
+			name: "task-arith scaled", method: TaskArithmetic,
+			base: []float64{0, 0}, ours: []float64{2, 0}, theirs: []float64{2, 4},
+			opts: Options{Alpha: 0.5},
+			want: []float64{2, 2},
+		},
+		{
+			// Agreeing changes are averaged, a disagreement goes to the
+			// larger side, a cancelled one keeps base and a change made
+			// by one side only is kept whole.
+			name: "ties", method: TIES,
+			base: []float64{0, 0, 0, 1}, ours: []float64{1, -2, 3, 2}, theirs: []float64{3, 2, -1, 1},
+			opts: Options{Alpha: 1, Density: 1},
+			want: []float64{2, 0, 3, 2},
+		},
+		{
+			// Trimming to half keeps the two largest changes of each side.
+			name: "ties trimmed", method: TIES,
+			base: []float64{0, 0, 0, 0}, ours: []float64{4, 0.1, -3, 0.2}, theirs: []float64{0.1, 2, 0.3, -5},
+			opts: Options{Alpha: 1, Density: 0.5},
+			want: []float64{4, 2, -3, -5},
+		},
+		{
+			name: "slerp orthogonal", method: SLERP,
+			ours: []float64{1, 0}, theirs: []float64{0, 1},
+			opts: Options{Alpha: 0.5},
+			want: []float64{math.Sqrt(0.5), math.Sqrt(0.5)},
+		},
+		{
+			name: "slerp keeps the norm", method: SLERP,
+			ours: []float64{2, 0}, theirs: []float64{0, 2},
+			opts: Options{Alpha: 1.0 / 3},
+			want: []float64{math.Sqrt(3), 1},
+		},
+		{
+			name: "slerp parallel falls back to lerp", method: SLERP,
+			ours: []float64{1, 2}, theirs: []float64{2, 4},
+			opts: Options{Alpha: 0.5},
+			want: []float64{1.5, 3},
+		},
+		{
+			name: "slerp from zero", method: SLERP,
+			ours: []float64{0, 0}, theirs: []float64{2, 4},
+			opts: Options{Alpha: 0.5},
+			want: []float64{1, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Merge(tt.method, tt.base, tt.ours, tt.theirs, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Merge = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if math.Abs(got[i]-tt.want[i]) > 1e-12 {
+					t.Errorf("Merge = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestMergeErrors(t *testing.T) {
+	if _, err := Merge(Average, nil, []float64{1}, []float64{1, 2}, Options{}); err == nil {
+		t.Error("merged tensors of different lengths")
+	}
+	if _, err := Merge(TaskArithmetic, []float64{1, 2}, []float64{1}, []float64{1}, Options{}); err == nil {
+		t.Error("merged against a base of a different length")
+	}
+	for _, m := range []Method{TaskArithmetic, TIES} {
+		if _, err := Merge(m, nil, []float64{1}, []float64{1}, Options{}); err == nil {
+			t.Errorf("%s merged without a base", m)
+		}
+	}
+}
+
+func TestParseMethod(t *testing.T) {
+	for _, m := range Methods {
+		got, err := ParseMethod(string(m))
+		if err != nil || got != m {
+			t.Errorf("ParseMethod(%q) = %q, %v", m, got, err)
+		}
+	}
+	if _, err := ParseMethod("mean"); err == nil {
+		t.Error("ParseMethod(mean) succeeded")
+	}
+	if Average.DefaultAlpha() != 0.5 || TIES.DefaultAlpha() != 1 {
+		t.Error("unexpected default alpha")
+	}
+}
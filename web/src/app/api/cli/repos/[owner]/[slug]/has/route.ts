@@ -0,0 +1,22 @@
+import { NextResponse, type NextRequest } from "next/server";
+import { authorizeRepository } from "~/server/cliAuth";
+import { MAX_KEYS, hasObjects, isObjectKey } from "~/server/objectStore";
+
+type Params = { params: Promise<{ owner: string; slug: string }> };
+
+// POST answers which of the object keys in the body are stored.
+export async function POST(req: NextRequest, { params }: Params) {
+  const access = await authorizeRepository(req, params, false);
+  if (access.error) return access.error;
+
+  try {
+    const keys = await req.json();
+    if (!Array.isArray(keys) || keys.length > MAX_KEYS || !keys.every(isObjectKey)) {
+      return NextResponse.json({ error: `Expected at most ${MAX_KEYS} object keys` }, { status: 400 });
+    }
+    return NextResponse.json(await hasObjects(access.repository.id, keys));
+  } catch (err) {
+    console.error(err);
+    return NextResponse.json({ error: "Server error" }, { status: 500 });
+  }
+}
@@ -0,0 +1,28 @@
+import { NextResponse, type NextRequest } from "next/server";
+import { authorizeRepository } from "~/server/cliAuth";
+import { MAX_KEYS, isObjectKey, presignObjects } from "~/server/objectStore";
+
+type Params = { params: Promise<{ owner: string; slug: string }> };
+
+// POST hands out URLs to GET or PUT objects of the repository straight
+// from the bucket. Refs are not presigned; they only move through the refs
+// route, which checks their old value.
+export async function POST(req: NextRequest, { params }: Params) {
+  try {
+    const { method, keys } = await req.json();
+    if (method !== "GET" && method !== "PUT") {
+      return NextResponse.json({ error: `Cannot presign ${method}` }, { status: 400 });
+    }
+    if (!Array.isArray(keys) || keys.length > MAX_KEYS || !keys.every(isObjectKey)) {
+      return NextResponse.json({ error: `Expected at most ${MAX_KEYS} object keys` }, { status: 400 });
+    }
+
+    const access = await authorizeRepository(req, params, method === "PUT");
+    if (access.error) return access.error;
+
+    return NextResponse.json({ urls: await presignObjects(access.repository.id, method, keys) });
+  } catch (err) {
+    console.error(err);
+    return NextResponse.json({ error: "Server error" }, { status: 500 });
+  }
+}
@@ -0,0 +1,49 @@
+import { NextResponse, type NextRequest } from "next/server";
+import { authorizeRepository } from "~/server/cliAuth";
+import { hasObjects, isCommitHash, isRefName, listRefs, updateRef } from "~/server/objectStore";
+
+type Params = { params: Promise<{ owner: string; slug: string }> };
+
+export async function GET(req: NextRequest, { params }: Params) {
+  const access = await authorizeRepository(req, params, false);
+  if (access.error) return access.error;
+
+  try {
+    return NextResponse.json(await listRefs(access.repository.id));
+  } catch (err) {
+    console.error(err);
+    return NextResponse.json({ error: "Server error" }, { status: 500 });
+  }
+}
+
+// POST moves a ref from its old to its new commit; 409 if it has moved.
+export async function POST(req: NextRequest, { params }: Params) {
+  const access = await authorizeRepository(req, params, true);
+  if (access.error) return access.error;
+
+  try {
+    const { name, old, new: hash } = await req.json();
+    if (!isRefName(name) || !isCommitHash(hash) || (old !== "" && !isCommitHash(old))) {
+      return NextResponse.json({ error: "Invalid ref update" }, { status: 400 });
+    }
+
+    const repositoryId = access.repository.id;
+    const commitKey = `commits/${hash.slice(0, 2)}/${hash.slice(2)}`;
+    if ((await hasObjects(repositoryId, [commitKey])).length === 0) {
+      return NextResponse.json({ error: `Commit ${hash} has not been uploaded` }, { status: 400 });
+    }
+
+    const result = await updateRef(repositoryId, name, old, hash);
+    if (result.stale) {
+      return NextResponse.json(
+        { error: `${name} is at ${result.current || "nothing"}, expected ${old || "nothing"}` },
+        { status: 409 }
+      );
+    }
+    console.log(`${access.userId} updated ${name} in ${repositoryId}: ${old || "nothing"} -> ${hash}`);
+    return new NextResponse(null, { status: 204 });
+  } catch (err) {
+    console.error(err);
+    return NextResponse.json({ error: "Server error" }, { status: 500 });
+  }
+}
@@ -16,6 +16,9 @@ export const env = createEnv({
     DATABASE_URL: z.string().url(),
     DIRECT_URL: z.string().url().optional(),
     NEXTAUTH_URL: z.string().url().optional(),
+    STK_S3_BUCKET: z.string().optional(),
+    STK_S3_ENDPOINT: z.string().url().optional(),
+    AWS_REGION: z.string().optional(),
     NODE_ENV: z
       .enum(["development", "test", "production"])
       .default("development"),
@@ -41,6 +44,9 @@ export const env = createEnv({
     DATABASE_URL: process.env.DATABASE_URL,
     DIRECT_URL: process.env.DIRECT_URL,
     NEXTAUTH_URL: process.env.NEXTAUTH_URL,
+    STK_S3_BUCKET: process.env.STK_S3_BUCKET,
+    STK_S3_ENDPOINT: process.env.STK_S3_ENDPOINT,
+    AWS_REGION: process.env.AWS_REGION,
     NODE_ENV: process.env.NODE_ENV,
   },
   /**
@@ -0,0 +1,51 @@
+import { jwtVerify } from "jose";
+import { NextResponse, type NextRequest } from "next/server";
+import { db } from "~/server/db";
+
+// cliUserId returns the user a token from `stk login` was issued to, or
+// null when the request carries no valid CLI token.
+export async function cliUserId(req: NextRequest) {
+  const token = req.headers.get("authorization")?.replace("Bearer ", "");
+  if (!token) return null;
+  try {
+    const secret = new TextEncoder().encode(process.env.AUTH_SECRET);
+    const { payload } = await jwtVerify(token, secret, { algorithms: ["HS256"] });
+    if (payload.type !== "cli" || typeof payload.sub !== "string") return null;
+    return payload.sub;
+  } catch {
+    return null;
+  }
+}
+
+// authorizeRepository finds the repository <owner>/<slug> a CLI request
+// names and checks that its user may read it, or write to it: the owner
+// and users with RepositoryAccess may do both, anyone signed in may read a
+// public repository. It answers with the error response otherwise.
+export async function authorizeRepository(
+  req: NextRequest,
+  params: Promise<{ owner: string; slug: string }>,
+  write: boolean
+) {
+  const userId = await cliUserId(req);
+  if (!userId) {
+    return { error: NextResponse.json({ error: "A valid token from stk login is required" }, { status: 401 }) };
+  }
+
+  const { owner, slug } = await params;
+  const repository = await db.repository.findFirst({
+    where: { slug, User: { name: owner } },
+    include: { RepositoryAccess: { where: { userId } } },
+  });
+  if (!repository) {
+    return { error: NextResponse.json({ error: `Repository ${owner}/${slug} not found` }, { status: 404 }) };
+  }
+
+  const member = repository.ownerId === userId || repository.RepositoryAccess.length > 0;
+  if (!member && (write || !repository.isPublic)) {
+    const action = write ? "push to" : "read";
+    return {
+      error: NextResponse.json({ error: `You do not have access to ${action} ${owner}/${slug}` }, { status: 403 }),
+    };
+  }
+  return { error: null, repository, userId };
+}
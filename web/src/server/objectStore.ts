@@ -0,0 +1,164 @@
+import {
+  GetObjectCommand,
+  HeadObjectCommand,
+  ListObjectsV2Command,
+  PutObjectCommand,
+  S3Client,
+  S3ServiceException,
+} from "@aws-sdk/client-s3";
+import { getSignedUrl } from "@aws-sdk/s3-request-presigner";
+
+// The objects and refs of each repository live in STK_S3_BUCKET under
+// repos/<repository id>/, laid out like .stk (and like an s3:// remote of
+// the CLI): "objects/blobs/ab/cdef…", "commits/…", "models/…" hold the
+// compressed objects and "refs/heads/main" holds a commit hash. Only the
+// server has credentials for the bucket; the CLI gets presigned URLs.
+
+export const PRESIGN_EXPIRY_SECONDS = 15 * 60;
+
+// The most keys one request may check or presign, as the CLI batches them.
+export const MAX_KEYS = 256;
+
+const OBJECT_KEY = /^(commits|objects\/trees|objects\/blobs|models)\/[0-9a-f]{2}\/[0-9a-f]{62}$/;
+const COMMIT_HASH = /^[0-9a-f]{64}$/;
+const REF_NAME = /^refs\/(heads|tags)\/[A-Za-z0-9._\-\/]+$/;
+
+export function isObjectKey(key: unknown): key is string {
+  return typeof key === "string" && OBJECT_KEY.test(key);
+}
+
+export function isCommitHash(hash: unknown): hash is string {
+  return typeof hash === "string" && COMMIT_HASH.test(hash);
+}
+
+export function isRefName(name: unknown): name is string {
+  return (
+    typeof name === "string" &&
+    REF_NAME.test(name) &&
+    !name.split("/").some((part) => part === "" || part.startsWith(".") || part.endsWith(".lock"))
+  );
+}
+
+const globalForS3 = globalThis as unknown as { s3: S3Client | undefined };
+
+// STK_S3_ENDPOINT points at an S3-compatible store such as MinIO.
+const s3 =
+  globalForS3.s3 ??
+  new S3Client({
+    region: process.env.AWS_REGION ?? "us-east-1",
+    endpoint: process.env.STK_S3_ENDPOINT,
+    forcePathStyle: !!process.env.STK_S3_ENDPOINT,
+  });
+
+if (process.env.NODE_ENV !== "production") globalForS3.s3 = s3;
+
+function bucket() {
+  const name = process.env.STK_S3_BUCKET;
+  if (!name) {
+    throw new Error("STK_S3_BUCKET is not set; it names the bucket holding repository objects.");
+  }
+  return name;
+}
+
+function storageKey(repositoryId: string, key: string) {
+  return `repos/${repositoryId}/${key}`;
+}
+
+function isMissing(err: unknown) {
+  return (
+    err instanceof S3ServiceException &&
+    (err.name === "NotFound" || err.name === "NoSuchKey" || err.$metadata.httpStatusCode === 404)
+  );
+}
+
+export async function presignObjects(repositoryId: string, method: "GET" | "PUT", keys: string[]) {
+  const urls: Record<string, string> = {};
+  await Promise.all(
+    keys.map(async (key) => {
+      const input = { Bucket: bucket(), Key: storageKey(repositoryId, key) };
+      const options = { expiresIn: PRESIGN_EXPIRY_SECONDS };
+      urls[key] =
+        method === "GET"
+          ? await getSignedUrl(s3, new GetObjectCommand(input), options)
+          : await getSignedUrl(s3, new PutObjectCommand(input), options);
+    })
+  );
+  return urls;
+}
+
+// hasObjects returns the keys the bucket already stores.
+export async function hasObjects(repositoryId: string, keys: string[]) {
+  const found = await Promise.all(
+    keys.map(async (key) => {
+      try {
+        await s3.send(new HeadObjectCommand({ Bucket: bucket(), Key: storageKey(repositoryId, key) }));
+        return true;
+      } catch (err) {
+        if (isMissing(err)) return false;
+        throw err;
+      }
+    })
+  );
+  return keys.filter((_, i) => found[i]);
+}
+
+async function readRef(repositoryId: string, name: string) {
+  try {
+    const object = await s3.send(new GetObjectCommand({ Bucket: bucket(), Key: storageKey(repositoryId, name) }));
+    const hash = ((await object.Body?.transformToString()) ?? "").trim();
+    return { hash, etag: object.ETag };
+  } catch (err) {
+    if (isMissing(err)) return null;
+    throw err;
+  }
+}
+
+export async function listRefs(repositoryId: string) {
+  const prefix = storageKey(repositoryId, "");
+  const names: string[] = [];
+  let token: string | undefined;
+  do {
+    const page = await s3.send(
+      new ListObjectsV2Command({ Bucket: bucket(), Prefix: prefix + "refs/", ContinuationToken: token })
+    );
+    for (const object of page.Contents ?? []) {
+      const name = object.Key!.slice(prefix.length);
+      if (isRefName(name)) names.push(name);
+    }
+    token = page.NextContinuationToken;
+  } while (token);
+
+  const refs = await Promise.all(
+    names.sort().map(async (name) => ({ name, hash: (await readRef(repositoryId, name))?.hash ?? "" }))
+  );
+  return refs.filter((ref) => ref.hash !== "");
+}
+
+// updateRef points name at hash if it still points at old ("" for a new
+// ref), with a conditional write so that concurrent pushes cannot both
+// win. It returns the current value when the ref has moved.
+export async function updateRef(repositoryId: string, name: string, old: string, hash: string) {
+  const current = await readRef(repositoryId, name);
+  if ((current?.hash ?? "") !== old) {
+    return { stale: true, current: current?.hash ?? "" };
+  }
+  try {
+    await s3.send(
+      new PutObjectCommand({
+        Bucket: bucket(),
+        Key: storageKey(repositoryId, name),
+        Body: hash + "\n",
+        ...(current ? { IfMatch: current.etag } : { IfNoneMatch: "*" }),
+      })
+    );
+  } catch (err) {
+    if (
+      err instanceof S3ServiceException &&
+      (err.name === "PreconditionFailed" || err.name === "ConditionalRequestConflict")
+    ) {
+      return { stale: true, current: "" };
+    }
+    throw err;
+  }
+  return { stale: false, current: hash };
+}